@@ -0,0 +1,231 @@
+package compiler
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Symbol is a name known to Equity source: a keyword, a built-in
+// function, or a name declared by a contract.
+type Symbol struct {
+	// Name is the symbol's name.
+	Name string `json:"name"`
+
+	// Role describes what the name denotes, such as "contract
+	// parameter" or "clause variable".
+	Role string `json:"role"`
+
+	// Type is the declared type of the symbol, if it has one.
+	Type string `json:"type,omitempty"`
+
+	// Signature is the call signature of a contract or built-in
+	// function.
+	Signature string `json:"signature,omitempty"`
+
+	// Location is the name in the symbol's declaration. It is unknown
+	// for keywords and built-in functions.
+	Location
+}
+
+// Reference is a use of a Symbol in source.
+type Reference struct {
+	Symbol *Symbol `json:"symbol"`
+	Location
+}
+
+// Analysis is the result of checking Equity source without requiring
+// that it compile, for editors and other tools.
+type Analysis struct {
+	// Contracts is the list of contracts parsed from the source,
+	// including imported ones. It may be incomplete if there were
+	// syntax errors.
+	Contracts []*Contract
+
+	// Symbols is the list of all known names, predeclared ones first.
+	Symbols []*Symbol
+
+	// References is the list of resolved uses of names in clauses.
+	References []*Reference
+
+	// Diagnostics is the list of problems found in the source.
+	Diagnostics Diagnostics
+}
+
+// Analyze parses and compiles the Equity source in buf, which is
+// reported as coming from the named file, and resolves the names it
+// uses.
+func Analyze(buf []byte, name string) *Analysis {
+	return AnalyzeWithOptions(buf, name, Options{})
+}
+
+// AnalyzeWithOptions is Analyze with the given options, such as the
+// resolver of the files that buf imports.
+func AnalyzeWithOptions(buf []byte, name string, opts Options) *Analysis {
+	if err := opts.check(); err != nil {
+		return &Analysis{Diagnostics: toDiagnostics(err)}
+	}
+	contracts, _, diags := compile(buf, name, opts)
+	for _, contract := range contracts {
+		diags = append(diags, contract.Warnings...)
+	}
+	diags.sort()
+	a := &Analysis{Contracts: contracts, Diagnostics: diags}
+	z := &analyzer{a: a, syms: make(map[*envEntry]*Symbol)}
+
+	globalEnv := newEnviron(nil)
+	for _, k := range keywords {
+		z.declare(globalEnv, k, nilType, roleKeyword, span{})
+	}
+	for _, b := range builtins {
+		sym := z.declare(globalEnv, b.name, b.result, roleBuiltin, span{})
+		var args []string
+		for _, t := range b.args {
+			if t == nilType {
+				t = "any"
+			}
+			args = append(args, string(t))
+		}
+		sym.Signature = fmt.Sprintf("%s(%s) %s", b.name, strings.Join(args, ", "), b.result)
+	}
+	for _, contract := range contracts {
+		sym := &Symbol{
+			Name:      contract.Name,
+			Role:      roleDesc[roleContract],
+			Type:      string(contractType),
+			Signature: contractSignature(contract),
+			Location:  contract.span.location(),
+		}
+		a.Symbols = append(a.Symbols, sym)
+		if globalEnv.addContract(contract) == nil {
+			z.syms[globalEnv.entries[contract.Name]] = sym
+		}
+	}
+
+	for _, contract := range contracts {
+		z.contract(contract, globalEnv)
+	}
+	return a
+}
+
+// Lookup returns the symbol declared or referenced at the given line
+// and column of the named file, and the range of the name there. It
+// returns nil if there is none.
+func (a *Analysis) Lookup(file string, line, col int) (*Symbol, *Location) {
+	for _, ref := range a.References {
+		if ref.File == file && ref.Contains(line, col) {
+			return ref.Symbol, &ref.Location
+		}
+	}
+	for _, sym := range a.Symbols {
+		if sym.Line > 0 && sym.File == file && sym.Contains(line, col) {
+			return sym, &sym.Location
+		}
+	}
+	return nil, nil
+}
+
+func contractSignature(contract *Contract) string {
+	var params []string
+	for _, p := range contract.Params {
+		params = append(params, fmt.Sprintf("%s: %s", p.Name, p.Type))
+	}
+	return fmt.Sprintf("contract %s(%s) locks %s of %s", contract.Name, strings.Join(params, ", "), contract.Value.Amount, contract.Value.Asset)
+}
+
+// analyzer resolves names in the same scopes that the compiler uses.
+type analyzer struct {
+	a    *Analysis
+	syms map[*envEntry]*Symbol
+}
+
+func (z *analyzer) declare(env *environ, name string, t typeDesc, r role, sp span) *Symbol {
+	sym := &Symbol{Name: name, Role: roleDesc[r], Type: string(t), Location: sp.location()}
+	z.a.Symbols = append(z.a.Symbols, sym)
+	if env.add(name, t, r) == nil {
+		z.syms[env.entries[name]] = sym
+	}
+	return sym
+}
+
+func (z *analyzer) refer(env *environ, name string, sp span) {
+	if entry := env.lookup(name); entry != nil {
+		if sym := z.syms[entry]; sym != nil {
+			z.a.References = append(z.a.References, &Reference{Symbol: sym, Location: sp.location()})
+		}
+	}
+}
+
+func (z *analyzer) contract(contract *Contract, globalEnv *environ) {
+	env := newEnviron(globalEnv)
+	for _, p := range contract.Params {
+		z.declare(env, p.Name, p.Type, roleContractParam, p.span)
+	}
+	z.declare(env, contract.Value.Amount, amountType, roleContractValue, contract.amountSpan)
+	z.declare(env, contract.Value.Asset, assetType, roleContractValue, contract.assetSpan)
+	for _, clause := range contract.Clauses {
+		z.declare(env, clause.Name, nilType, roleClause, clause.span)
+	}
+	for _, clause := range contract.Clauses {
+		clauseEnv := newEnviron(env)
+		for _, p := range clause.Params {
+			z.declare(clauseEnv, p.Name, p.Type, roleClauseParam, p.span)
+		}
+		z.statements(clause.statements, clauseEnv)
+	}
+}
+
+func (z *analyzer) statements(stmts []statement, env *environ) {
+	for _, s := range stmts {
+		switch stmt := s.(type) {
+		case *defineStatement:
+			z.declare(env, stmt.variable.Name, stmt.variable.Type, roleClauseVariable, stmt.variable.span)
+			z.expr(stmt.expr, env)
+
+		case *assignStatement:
+			z.refer(env, stmt.variable.Name, stmt.variable.span)
+			z.expr(stmt.expr, env)
+
+		case *ifStatement:
+			z.expr(stmt.condition, env)
+			z.statements(stmt.body.trueBody, env)
+			z.statements(stmt.body.falseBody, env)
+
+		case *verifyStatement:
+			z.expr(stmt.expr, env)
+
+		case *lockStatement:
+			z.expr(stmt.lockedAmount, env)
+			z.expr(stmt.lockedAsset, env)
+			z.expr(stmt.program, env)
+
+		case *unlockStatement:
+			z.expr(stmt.unlockedAmount, env)
+			z.expr(stmt.unlockedAsset, env)
+		}
+	}
+}
+
+func (z *analyzer) expr(e expression, env *environ) {
+	switch e := e.(type) {
+	case *binaryExpr:
+		z.expr(e.left, env)
+		z.expr(e.right, env)
+
+	case *unaryExpr:
+		z.expr(e.expr, env)
+
+	case *callExpr:
+		z.expr(e.fn, env)
+		for _, arg := range e.args {
+			z.expr(arg, env)
+		}
+
+	case varRef:
+		z.refer(env, e.name, e.span)
+
+	case listExpr:
+		for _, elt := range e.elts {
+			z.expr(elt, env)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package compiler
+
+import "testing"
+
+const analyzeSrc = `contract LockWithPublicKey(publicKey: PublicKey) locks valueAmount of valueAsset {
+  clause spend(sig: Signature) {
+    define ok: Boolean = checkTxSig(publicKey, sig)
+    verify ok
+    unlock valueAmount of valueAsset
+  }
+}
+`
+
+func TestAnalyzeLookup(t *testing.T) {
+	a := Analyze([]byte(analyzeSrc), "lock.equity")
+	if len(a.Diagnostics) != 0 {
+		t.Fatalf("unexpected diagnostics: %s", a.Diagnostics)
+	}
+
+	cases := []struct {
+		line, col int
+		want      Symbol
+		wantLoc   Location
+	}{
+		{
+			// declaration of a contract parameter
+			1, 28,
+			Symbol{Name: "publicKey", Role: "contract parameter", Type: "PublicKey", Location: Location{File: "lock.equity", Line: 1, Col: 27, EndLine: 1, EndCol: 36}},
+			Location{File: "lock.equity", Line: 1, Col: 27, EndLine: 1, EndCol: 36},
+		},
+		{
+			// reference to a contract parameter
+			3, 37,
+			Symbol{Name: "publicKey", Role: "contract parameter", Type: "PublicKey", Location: Location{File: "lock.equity", Line: 1, Col: 27, EndLine: 1, EndCol: 36}},
+			Location{File: "lock.equity", Line: 3, Col: 36, EndLine: 3, EndCol: 45},
+		},
+		{
+			// reference to a clause variable
+			4, 11,
+			Symbol{Name: "ok", Role: "clause variable", Type: "Boolean", Location: Location{File: "lock.equity", Line: 3, Col: 11, EndLine: 3, EndCol: 13}},
+			Location{File: "lock.equity", Line: 4, Col: 11, EndLine: 4, EndCol: 13},
+		},
+		{
+			// reference to the contract value
+			5, 28,
+			Symbol{Name: "valueAsset", Role: "contract value", Type: "Asset", Location: Location{File: "lock.equity", Line: 1, Col: 70, EndLine: 1, EndCol: 80}},
+			Location{File: "lock.equity", Line: 5, Col: 26, EndLine: 5, EndCol: 36},
+		},
+		{
+			// call of a built-in function
+			3, 25,
+			Symbol{Name: "checkTxSig", Role: "built-in function", Type: "Boolean", Signature: "checkTxSig(PublicKey, Signature) Boolean"},
+			Location{File: "lock.equity", Line: 3, Col: 25, EndLine: 3, EndCol: 35},
+		},
+	}
+	for _, c := range cases {
+		sym, loc := a.Lookup("lock.equity", c.line, c.col)
+		if sym == nil {
+			t.Errorf("Lookup(%d, %d) found nothing", c.line, c.col)
+			continue
+		}
+		if *sym != c.want || *loc != c.wantLoc {
+			t.Errorf("Lookup(%d, %d) = %+v at %+v, want %+v at %+v", c.line, c.col, *sym, *loc, c.want, c.wantLoc)
+		}
+	}
+
+	if sym, _ := a.Lookup("lock.equity", 6, 0); sym != nil {
+		t.Errorf("Lookup(6, 0) = %+v, want nothing", *sym)
+	}
+}
+
+func TestAnalyzeIncomplete(t *testing.T) {
+	src := `contract C(x: Integer) locks a of b {
+  clause f(y: Integer) {
+    verify x + y >
+  }
+}
+`
+	a := Analyze([]byte(src), "")
+	if len(a.Diagnostics) == 0 {
+		t.Fatal("expected diagnostics")
+	}
+	if len(a.Contracts) != 1 {
+		t.Fatalf("got %d contracts, want 1", len(a.Contracts))
+	}
+	if sym, _ := a.Lookup("", 2, 11); sym == nil || sym.Name != "y" {
+		t.Errorf("Lookup(2, 11) = %v, want clause parameter y", sym)
+	}
+}
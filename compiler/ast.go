@@ -36,8 +36,43 @@ type Contract struct {
 	// used to select between two possible instantiation options.)
 	Recursive bool `json:"recursive"`
 
+	// Dispatch is how Body selects the clause to run, if the contract
+	// has more than one.
+	Dispatch Dispatch `json:"dispatch,omitempty"`
+
+	// Warnings is the list of problems found in the contract that do
+	// not stop it compiling, such as clauses that come close to the
+	// limits of the virtual machine.
+	Warnings Diagnostics `json:"warnings,omitempty"`
+
+	// SourceMap relates each instruction of Body, in order, to the
+	// source it was compiled from.
+	SourceMap []SourceMapping `json:"source_map,omitempty"`
+
 	// Pre-optimized list of instruction steps, with stack snapshots.
 	Steps []Step `json:"-"`
+
+	// stepAt maps the offset of each instruction in Body to the index
+	// of the step it was compiled from.
+	stepAt map[uint32]int
+
+	// span of the contract name in its declaration.
+	span
+
+	// spans of the names of the contract value's amount and asset.
+	amountSpan, assetSpan span
+
+	// incomplete is set when the parser recovered from syntax errors
+	// inside the contract.
+	incomplete bool
+}
+
+// SourceMapping relates the instruction at an offset of a contract
+// body to the innermost statement or expression it was compiled from.
+// Location is zero for the instructions that select a clause.
+type SourceMapping struct {
+	Offset   uint32   `json:"offset"`
+	Location Location `json:"location"`
 }
 
 // Param is a contract or clause parameter.
@@ -51,6 +86,9 @@ type Param struct {
 	// InferredType, if available, is a more-specific type than Type,
 	// inferred from the logic of the contract.
 	InferredType typeDesc `json:"inferred_type,omitempty"`
+
+	// span of the parameter name in its declaration.
+	span
 }
 
 // Clause is a compiled contract clause.
@@ -79,6 +117,21 @@ type Clause struct {
 
 	// Contracts is the list of contracts called by this clause.
 	Contracts []string `json:"contracts,omitempty"`
+
+	// Gas is the gas used by a spend through this clause, or nil if it
+	// could not be estimated.
+	Gas *ClauseGas `json:"gas,omitempty"`
+
+	// Stack is the most that a spend through this clause holds on the
+	// stack, or nil if it could not be estimated.
+	Stack *StackUsage `json:"stack,omitempty"`
+
+	// span of the clause name in its declaration.
+	span
+
+	// incomplete is set when the parser recovered from syntax errors
+	// inside the clause.
+	incomplete bool
 }
 
 // ValueInfo describes how a blockchain value is used in a contract clause.
@@ -167,11 +220,13 @@ type ContractArg struct {
 
 type statement interface {
 	countVarRefs(map[string]int)
+	pos() span
 }
 
 type defineStatement struct {
 	variable *Param
 	expr     expression
+	span
 }
 
 func (s defineStatement) countVarRefs(counts map[string]int) {
@@ -181,6 +236,7 @@ func (s defineStatement) countVarRefs(counts map[string]int) {
 type assignStatement struct {
 	variable *Param
 	expr     expression
+	span
 }
 
 func (s assignStatement) countVarRefs(counts map[string]int) {
@@ -199,6 +255,7 @@ type IfStatmentBody struct {
 type ifStatement struct {
 	condition expression
 	body      *IfStatmentBody
+	span
 }
 
 func (s ifStatement) countVarRefs(counts map[string]int) {
@@ -207,6 +264,7 @@ func (s ifStatement) countVarRefs(counts map[string]int) {
 
 type verifyStatement struct {
 	expr expression
+	span
 }
 
 func (s verifyStatement) countVarRefs(counts map[string]int) {
@@ -220,6 +278,8 @@ type lockStatement struct {
 
 	// Added as a decoration, used by CHECKOUTPUT
 	index int64
+
+	span
 }
 
 func (s lockStatement) countVarRefs(counts map[string]int) {
@@ -231,6 +291,7 @@ func (s lockStatement) countVarRefs(counts map[string]int) {
 type unlockStatement struct {
 	unlockedAmount expression
 	unlockedAsset  expression
+	span
 }
 
 func (s unlockStatement) countVarRefs(counts map[string]int) {
@@ -242,11 +303,18 @@ type expression interface {
 	String() string
 	typ(*environ) typeDesc
 	countVarRefs(map[string]int)
+	pos() span
 }
 
 type binaryExpr struct {
 	left, right expression
 	op          *binaryOp
+
+	// swapped is whether the right operand is evaluated first, with
+	// the mirror of op applied to them.
+	swapped bool
+
+	span
 }
 
 func (e binaryExpr) String() string {
@@ -265,6 +333,7 @@ func (e binaryExpr) countVarRefs(counts map[string]int) {
 type unaryExpr struct {
 	op   *unaryOp
 	expr expression
+	span
 }
 
 func (e unaryExpr) String() string {
@@ -282,6 +351,7 @@ func (e unaryExpr) countVarRefs(counts map[string]int) {
 type callExpr struct {
 	fn   expression
 	args []expression
+	span
 }
 
 func (e callExpr) String() string {
@@ -334,27 +404,33 @@ func (e callExpr) countVarRefs(counts map[string]int) {
 	}
 }
 
-type varRef string
+type varRef struct {
+	name string
+	span
+}
 
 func (v varRef) String() string {
-	return string(v)
+	return v.name
 }
 
 func (v varRef) typ(env *environ) typeDesc {
-	if entry := env.lookup(string(v)); entry != nil {
+	if entry := env.lookup(v.name); entry != nil {
 		return entry.t
 	}
 	return nilType
 }
 
 func (v varRef) countVarRefs(counts map[string]int) {
-	counts[string(v)]++
+	counts[v.name]++
 }
 
-type bytesLiteral []byte
+type bytesLiteral struct {
+	value []byte
+	span
+}
 
 func (e bytesLiteral) String() string {
-	return "0x" + hex.EncodeToString([]byte(e))
+	return "0x" + hex.EncodeToString(e.value)
 }
 
 func (bytesLiteral) typ(*environ) typeDesc {
@@ -363,10 +439,13 @@ func (bytesLiteral) typ(*environ) typeDesc {
 
 func (bytesLiteral) countVarRefs(map[string]int) {}
 
-type integerLiteral int64
+type integerLiteral struct {
+	value int64
+	span
+}
 
 func (e integerLiteral) String() string {
-	return strconv.FormatInt(int64(e), 10)
+	return strconv.FormatInt(e.value, 10)
 }
 
 func (integerLiteral) typ(*environ) typeDesc {
@@ -375,10 +454,13 @@ func (integerLiteral) typ(*environ) typeDesc {
 
 func (integerLiteral) countVarRefs(map[string]int) {}
 
-type booleanLiteral bool
+type booleanLiteral struct {
+	value bool
+	span
+}
 
 func (e booleanLiteral) String() string {
-	if e {
+	if e.value {
 		return "true"
 	}
 	return "false"
@@ -390,11 +472,14 @@ func (booleanLiteral) typ(*environ) typeDesc {
 
 func (booleanLiteral) countVarRefs(map[string]int) {}
 
-type listExpr []expression
+type listExpr struct {
+	elts []expression
+	span
+}
 
 func (e listExpr) String() string {
 	var elts []string
-	for _, elt := range e {
+	for _, elt := range e.elts {
 		elts = append(elts, elt.String())
 	}
 	return fmt.Sprintf("[%s]", strings.Join(elts, ", "))
@@ -405,7 +490,7 @@ func (listExpr) typ(*environ) typeDesc {
 }
 
 func (e listExpr) countVarRefs(counts map[string]int) {
-	for _, elt := range e {
+	for _, elt := range e.elts {
 		elt.countVarRefs(counts)
 	}
 }
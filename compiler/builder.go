@@ -2,18 +2,52 @@ package compiler
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
+
+	"github.com/bytom/protocol/vm"
 )
 
 type builder struct {
 	items         []*builderItem
 	pendingVerify *builderItem
+
+	// opts are the options of the compilation.
+	opts Options
+
+	// stmt is the statement being compiled, and expr the innermost
+	// expression within it, if any.
+	stmt, expr span
+
+	// conditions maps the label to which each if statement jumps when
+	// its condition is false to the condition.
+	conditions map[string]string
 }
 
 type builderItem struct {
 	opcodes string
 	stk     stack
+
+	// stmt is the statement the item was compiled from, and src the
+	// innermost statement or expression.
+	stmt, src span
+}
+
+// setStatement records that the items added next are compiled from
+// the statement at sp, and returns the previous statement.
+func (b *builder) setStatement(sp span) span {
+	prev := b.stmt
+	b.stmt = sp
+	return prev
+}
+
+// setExpression records that the items added next are compiled from
+// the expression at sp, and returns the previous expression.
+func (b *builder) setExpression(sp span) span {
+	prev := b.expr
+	b.expr = sp
+	return prev
 }
 
 func (b *builder) add(opcodes string, newstack stack) stack {
@@ -21,7 +55,10 @@ func (b *builder) add(opcodes string, newstack stack) stack {
 		b.items = append(b.items, b.pendingVerify)
 		b.pendingVerify = nil
 	}
-	item := &builderItem{opcodes: opcodes, stk: newstack}
+	item := &builderItem{opcodes: opcodes, stk: newstack, stmt: b.stmt, src: b.stmt}
+	if b.expr.src != nil {
+		item.src = b.expr
+	}
 	if opcodes == "VERIFY" {
 		b.pendingVerify = item
 	} else {
@@ -30,6 +67,23 @@ func (b *builder) add(opcodes string, newstack stack) stack {
 	return newstack
 }
 
+// builderMark is the state of a builder at some point, to which it
+// may be reset.
+type builderMark struct {
+	items         int
+	pendingVerify *builderItem
+}
+
+func (b *builder) mark() builderMark {
+	return builderMark{items: len(b.items), pendingVerify: b.pendingVerify}
+}
+
+// reset removes the items added since m.
+func (b *builder) reset(m builderMark) {
+	b.items = b.items[:m.items]
+	b.pendingVerify = m.pendingVerify
+}
+
 func (b *builder) addRoll(stk stack, n int) stack {
 	b.addInt64(stk, int64(n))
 	return b.add("ROLL", stk.roll(n))
@@ -60,6 +114,15 @@ func (b *builder) addJumpIf(stk stack, label string) stack {
 	return b.add(fmt.Sprintf("JUMPIF:$%s", label), stk.drop())
 }
 
+// addCondition records that the if statement with condition cond
+// jumps to label when it is false.
+func (b *builder) addCondition(label, cond string) {
+	if b.conditions == nil {
+		b.conditions = make(map[string]string)
+	}
+	b.conditions[label] = cond
+}
+
 func (b *builder) addJumpTarget(stk stack, label string) stack {
 	return b.add("$"+label, stk)
 }
@@ -81,16 +144,65 @@ func (b *builder) addVerify(stk stack) stack {
 }
 
 func (b *builder) addData(stk stack, data []byte) stack {
-	var s string
+	s := dataOp(data)
+	return b.add(s, stk.add(s))
+}
+
+// dataOp returns the instruction that pushes data.
+func dataOp(data []byte) string {
 	switch len(data) {
 	case 0:
-		s = "0"
+		return "0"
 	case 1:
-		s = strconv.FormatInt(int64(data[0]), 10)
+		return strconv.FormatInt(int64(data[0]), 10)
 	default:
-		s = fmt.Sprintf("0x%x", data)
+		return fmt.Sprintf("0x%x", data)
 	}
-	return b.add(s, stk.add(s))
+}
+
+// addConstant adds the instruction that pushes the value of lit, as
+// the result of the expression described by desc.
+func (b *builder) addConstant(stk stack, lit expression, desc string) stack {
+	return b.add(constantOp(lit), stk.add(desc))
+}
+
+// constantOp returns the instruction that pushes the value of lit.
+func constantOp(lit expression) string {
+	switch l := lit.(type) {
+	case integerLiteral:
+		return l.String()
+	case booleanLiteral:
+		if l.value {
+			return "TRUE"
+		}
+		return "FALSE"
+	case bytesLiteral:
+		return dataOp(l.value)
+	}
+	return ""
+}
+
+// sizeSince returns the size in bytes of the instructions added since
+// m, or -1 if they do not assemble.
+func (b *builder) sizeSince(m builderMark) int {
+	var ops []string
+	for _, item := range b.items[m.items:] {
+		// a VERIFY pending at m is added with the first item after it
+		if item != m.pendingVerify {
+			ops = append(ops, item.opcodes)
+		}
+	}
+	return assembledSize(strings.Join(ops, " "))
+}
+
+// assembledSize returns the size in bytes of ops, or -1 if they do not
+// assemble.
+func assembledSize(ops string) int {
+	prog, err := vm.Assemble(ops)
+	if err != nil {
+		return -1
+	}
+	return len(prog)
 }
 
 func (b *builder) addAmount(stk stack, desc string) stack {
@@ -166,6 +278,51 @@ func (b *builder) opcodes() string {
 	return strings.Join(ops, " ")
 }
 
+// instructions returns the instructions of the items, each with the
+// index of the item that produced it as its origin, and the depth of
+// the stack before it.
+func (b *builder) instructions() []instruction {
+	var result []instruction
+	for i, item := range b.items {
+		ops := strings.Fields(item.opcodes)
+
+		// The stack before an item is the one after it less what its
+		// opcodes pushed. The compiler's stack holds only the items it
+		// knows of, so this is never deeper than the actual stack.
+		depth := item.stk.size()
+		for _, op := range ops {
+			n, ok := stackEffect(op)
+			if !ok {
+				depth = -1
+				break
+			}
+			depth -= n
+		}
+
+		for _, op := range ops {
+			result = append(result, instruction{op: op, origin: i, depth: depth})
+			depth = nextDepth(depth, op)
+		}
+	}
+	return result
+}
+
+// sourceMap returns the source map of a program whose instruction
+// offsets map to the items they were compiled from as in stepAt.
+func (b *builder) sourceMap(stepAt map[uint32]int) []SourceMapping {
+	var offsets []int
+	for pc := range stepAt {
+		offsets = append(offsets, int(pc))
+	}
+	sort.Ints(offsets)
+	var result []SourceMapping
+	for _, pc := range offsets {
+		loc := b.items[stepAt[uint32(pc)]].src.location()
+		result = append(result, SourceMapping{Offset: uint32(pc), Location: loc})
+	}
+	return result
+}
+
 // This is for producing listings like:
 // 5                 |  [... <clause selector> borrower lender deadline balanceAmount balanceAsset 5]
 // ROLL              |  [... borrower lender deadline balanceAmount balanceAsset <clause selector>]
@@ -210,13 +367,30 @@ type (
 	Step struct {
 		Opcodes string `json:"opcodes"`
 		Stack   string `json:"stack"`
+
+		// Statement is the statement the step was compiled from. It is
+		// unknown (with a zero Line) for the steps that select a clause.
+		Statement Location `json:"statement"`
+
+		stk stack
 	}
 )
 
+// Names returns the descriptions of the values on the stack after the
+// step, bottom first. They describe the top of the stack the virtual
+// machine has at that point.
+func (s Step) Names() []string {
+	var names []string
+	for stk := s.stk; !stk.isEmpty(); stk = stk.drop() {
+		names = append([]string{stk.top()}, names...)
+	}
+	return names
+}
+
 func (b *builder) steps() []Step {
 	var result []Step
 	for _, item := range b.items {
-		result = append(result, Step{item.opcodes, item.stk.String()})
+		result = append(result, Step{Opcodes: item.opcodes, Stack: item.stk.String(), Statement: item.stmt.location(), stk: item.stk})
 	}
 	return result
 }
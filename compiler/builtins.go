@@ -60,6 +60,39 @@ var binaryOps = []binaryOp{
 	{"/", 5, "DIV", "Integer", "Integer", "Integer"},
 }
 
+// mirrors maps each binary operator to the operator that gives the
+// same result with its operands the other way round, if there is one.
+var mirrors = map[string]string{
+	"||": "||",
+	"&&": "&&",
+	">":  "<",
+	"<":  ">",
+	">=": "<=",
+	"<=": ">=",
+	"==": "==",
+	"!=": "!=",
+	"^":  "^",
+	"|":  "|",
+	"+":  "+",
+	"&":  "&",
+	"*":  "*",
+}
+
+// mirror returns the operator that gives the same result as op with
+// its operands the other way round, or nil if there is none.
+func (op *binaryOp) mirror() *binaryOp {
+	m, ok := mirrors[op.op]
+	if !ok {
+		return nil
+	}
+	for i := range binaryOps {
+		if binaryOps[i].op == m {
+			return &binaryOps[i]
+		}
+	}
+	return nil
+}
+
 type unaryOp struct {
 	op      string
 	opcodes string
@@ -106,8 +106,8 @@ func calClauseValues(contract *Contract, env *environ, stmt statement, condValue
 		if res, ok := s.program.(*callExpr); ok {
 			if bi := referencedBuiltin(res.fn); bi == nil {
 				if v, ok := res.fn.(varRef); ok {
-					if entry := env.lookup(string(v)); entry != nil && entry.t == contractType {
-						programExpr = fmt.Sprintf("%s(", string(v))
+					if entry := env.lookup(v.name); entry != nil && entry.t == contractType {
+						programExpr = fmt.Sprintf("%s(", v.name)
 						for i := 0; i < len(res.args); i++ {
 							argExpr := res.args[i].String()
 							argCounts := make(map[string]int)
@@ -173,15 +173,17 @@ func checkParams(param *Param, params []*Param) bool {
 }
 
 func prohibitSigParams(contract *Contract) error {
+	var diags Diagnostics
 	for _, p := range contract.Params {
 		if p.Type == sigType {
-			return fmt.Errorf("contract parameter \"%s\" has type Signature, but contract parameters cannot have type Signature", p.Name)
+			diags.add(errorf(p.span, CodeSigParam, "contract parameter \"%s\" has type Signature, but contract parameters cannot have type Signature", p.Name))
 		}
 	}
-	return nil
+	return diags.err()
 }
 
 func requireAllParamsUsedInClauses(params []*Param, clauses []*Clause) error {
+	var diags Diagnostics
 	for _, p := range params {
 		used := false
 		for _, c := range clauses {
@@ -193,13 +195,14 @@ func requireAllParamsUsedInClauses(params []*Param, clauses []*Clause) error {
 		}
 
 		if !used {
-			return fmt.Errorf("parameter \"%s\" is unused", p.Name)
+			diags.add(errorf(p.span, CodeUnused, "parameter \"%s\" is unused", p.Name))
 		}
 	}
-	return nil
+	return diags.err()
 }
 
 func requireAllParamsUsedInClause(params []*Param, clause *Clause) error {
+	var diags Diagnostics
 	for _, p := range params {
 		used := false
 		for _, stmt := range clause.statements {
@@ -209,10 +212,10 @@ func requireAllParamsUsedInClause(params []*Param, clause *Clause) error {
 		}
 
 		if !used {
-			return fmt.Errorf("parameter \"%s\" is unused in clause \"%s\"", p.Name, clause.Name)
+			diags.add(errorf(p.span, CodeUnused, "parameter \"%s\" is unused in clause \"%s\"", p.Name, clause.Name))
 		}
 	}
-	return nil
+	return diags.err()
 }
 
 func checkParamUsedInStatement(param *Param, stmt statement) (used bool) {
@@ -268,9 +271,9 @@ func references(expr expression, name string) bool {
 		}
 		return false
 	case varRef:
-		return string(e) == name
+		return e.name == name
 	case listExpr:
-		for _, elt := range []expression(e) {
+		for _, elt := range e.elts {
 			if references(elt, name) {
 				return true
 			}
@@ -283,7 +286,7 @@ func references(expr expression, name string) bool {
 func referencedBuiltin(expr expression) *builtin {
 	if v, ok := expr.(varRef); ok {
 		for _, b := range builtins {
-			if string(v) == b.name {
+			if v.name == b.name {
 				return &b
 			}
 		}
@@ -318,7 +321,7 @@ func assignIndexes(clause *Clause) error {
 	var nextIndex int64
 	for i, stmt := range clause.statements {
 		if nextIndex = assignStatIndexes(stmt, nextIndex, i != len(clause.statements)-1); nextIndex < 0 {
-			return fmt.Errorf("Not support that the number of lock/unlock statement is not equal between ifbody and elsebody when the if-else is not the last statement in clause \"%s\"", clause.Name)
+			return errorf(stmt.pos(), CodeLockCount, "Not support that the number of lock/unlock statement is not equal between ifbody and elsebody when the if-else is not the last statement in clause \"%s\"", clause.Name)
 		}
 	}
 
@@ -355,13 +358,16 @@ func assignStatIndexes(stat statement, nextIndex int64, nonFinalFlag bool) int64
 	return nextIndex
 }
 
-func typeCheckClause(contract *Contract, clause *Clause, env *environ) error {
+// typeCheckClause checks the types of the clause's statements, except
+// those in skip, and reports every mismatch found.
+func typeCheckClause(contract *Contract, clause *Clause, env *environ, skip map[statement]bool) error {
+	var diags Diagnostics
 	for _, s := range clause.statements {
-		if err := typeCheckStatement(s, contract.Value, clause.Name, env); err != nil {
-			return err
+		if !skip[s] {
+			diags.add(typeCheckStatement(s, contract.Value, clause.Name, env))
 		}
 	}
-	return nil
+	return diags.err()
 }
 
 func typeCheckStatement(stat statement, contractValue ValueInfo, clauseName string, env *environ) error {
@@ -381,41 +387,41 @@ func typeCheckStatement(stat statement, contractValue ValueInfo, clauseName stri
 
 	case *defineStatement:
 		if stmt.expr != nil && stmt.expr.typ(env) != stmt.variable.Type && !(stmt.variable.Type == hashType && isHashSubtype(stmt.expr.typ(env))) {
-			return fmt.Errorf("expression in define statement in clause \"%s\" has type \"%s\", must be \"%s\"",
+			return errorf(stmt.expr.pos(), CodeTypeMismatch, "expression in define statement in clause \"%s\" has type \"%s\", must be \"%s\"",
 				clauseName, stmt.expr.typ(env), stmt.variable.Type)
 		}
 
 	case *assignStatement:
 		if stmt.expr.typ(env) != stmt.variable.Type && !(stmt.variable.Type == hashType && isHashSubtype(stmt.expr.typ(env))) {
-			return fmt.Errorf("expression in assign statement in clause \"%s\" has type \"%s\", must be \"%s\"",
+			return errorf(stmt.expr.pos(), CodeTypeMismatch, "expression in assign statement in clause \"%s\" has type \"%s\", must be \"%s\"",
 				clauseName, stmt.expr.typ(env), stmt.variable.Type)
 		}
 
 	case *verifyStatement:
 		if t := stmt.expr.typ(env); t != boolType {
-			return fmt.Errorf("expression in verify statement in clause \"%s\" has type \"%s\", must be Boolean", clauseName, t)
+			return errorf(stmt.expr.pos(), CodeTypeMismatch, "expression in verify statement in clause \"%s\" has type \"%s\", must be Boolean", clauseName, t)
 		}
 
 	case *lockStatement:
 		if t := stmt.lockedAmount.typ(env); !(t == intType || t == amountType) {
-			return fmt.Errorf("lockedAmount expression \"%s\" in lock statement in clause \"%s\" has type \"%s\", must be Integer", stmt.lockedAmount, clauseName, t)
+			return errorf(stmt.lockedAmount.pos(), CodeTypeMismatch, "lockedAmount expression \"%s\" in lock statement in clause \"%s\" has type \"%s\", must be Integer", stmt.lockedAmount, clauseName, t)
 		}
 		if t := stmt.lockedAsset.typ(env); t != assetType {
-			return fmt.Errorf("lockedAsset expression \"%s\" in lock statement in clause \"%s\" has type \"%s\", must be Asset", stmt.lockedAsset, clauseName, t)
+			return errorf(stmt.lockedAsset.pos(), CodeTypeMismatch, "lockedAsset expression \"%s\" in lock statement in clause \"%s\" has type \"%s\", must be Asset", stmt.lockedAsset, clauseName, t)
 		}
 		if t := stmt.program.typ(env); t != progType {
-			return fmt.Errorf("program in lock statement in clause \"%s\" has type \"%s\", must be Program", clauseName, t)
+			return errorf(stmt.program.pos(), CodeTypeMismatch, "program in lock statement in clause \"%s\" has type \"%s\", must be Program", clauseName, t)
 		}
 
 	case *unlockStatement:
 		if t := stmt.unlockedAmount.typ(env); !(t == intType || t == amountType) {
-			return fmt.Errorf("unlockedAmount expression \"%s\" in unlock statement of clause \"%s\" has type \"%s\", must be Integer", stmt.unlockedAmount, clauseName, t)
+			return errorf(stmt.unlockedAmount.pos(), CodeTypeMismatch, "unlockedAmount expression \"%s\" in unlock statement of clause \"%s\" has type \"%s\", must be Integer", stmt.unlockedAmount, clauseName, t)
 		}
 		if t := stmt.unlockedAsset.typ(env); t != assetType {
-			return fmt.Errorf("unlockedAsset expression \"%s\" in unlock statement of clause \"%s\" has type \"%s\", must be Asset", stmt.unlockedAsset, clauseName, t)
+			return errorf(stmt.unlockedAsset.pos(), CodeTypeMismatch, "unlockedAsset expression \"%s\" in unlock statement of clause \"%s\" has type \"%s\", must be Asset", stmt.unlockedAsset, clauseName, t)
 		}
 		if stmt.unlockedAsset.String() != contractValue.Asset {
-			return fmt.Errorf("amount \"%s\" of asset \"%s\" expression in unlock statement of clause \"%s\" must be the contract valueAmount \"%s\" of valueAsset \"%s\"",
+			return errorf(stmt.span, CodeTypeMismatch, "amount \"%s\" of asset \"%s\" expression in unlock statement of clause \"%s\" must be the contract valueAmount \"%s\" of valueAsset \"%s\"",
 				stmt.unlockedAmount.String(), stmt.unlockedAsset.String(), clauseName, contractValue.Amount, contractValue.Asset)
 		}
 	}
@@ -6,33 +6,33 @@ func TestRequireAllParamsUsedInClauses(t *testing.T) {
 	clauses := []*Clause{
 		&Clause{
 			statements: []statement{
-				&verifyStatement{expr: varRef("foo")},
+				&verifyStatement{expr: varRef{name: "foo"}},
 				&verifyStatement{
 					expr: &binaryExpr{
-						left:  varRef("foo"),
-						right: varRef("bar"),
+						left:  varRef{name: "foo"},
+						right: varRef{name: "bar"},
 					},
 				},
 				&lockStatement{
-					lockedAmount: varRef("10000"),
-					lockedAsset:  varRef("baz"),
-					program:      varRef("foo"),
+					lockedAmount: varRef{name: "10000"},
+					lockedAsset:  varRef{name: "baz"},
+					program:      varRef{name: "foo"},
 				},
 			},
 		},
 		&Clause{
 			statements: []statement{
-				&verifyStatement{expr: varRef("foo")},
+				&verifyStatement{expr: varRef{name: "foo"}},
 				&verifyStatement{
 					expr: &binaryExpr{
-						left:  varRef("foo"),
-						right: varRef("plugh"),
+						left:  varRef{name: "foo"},
+						right: varRef{name: "plugh"},
 					},
 				},
 				&lockStatement{
-					lockedAmount: varRef("20000"),
-					lockedAsset:  varRef("xyzzy"),
-					program:      varRef("foo"),
+					lockedAmount: varRef{name: "20000"},
+					lockedAsset:  varRef{name: "xyzzy"},
+					program:      varRef{name: "foo"},
 				},
 			},
 		},
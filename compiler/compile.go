@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"sort"
+	"strings"
 
 	chainjson "github.com/bytom/encoding/json"
 	"github.com/bytom/errors"
@@ -18,15 +20,41 @@ import (
 // lists of arguments with which to instantiate them as programs, with
 // the results placed in the contract's Program field. A contract
 // named in argMap but not found in the input is silently ignored.
+//
+// Problems in the source are returned as a Diagnostics error listing
+// every syntax and type error found. If r has a Name method (as
+// *os.File does), it names the file in them.
 func Compile(r io.Reader) ([]*Contract, error) {
+	return CompileWithOptions(r, Options{})
+}
+
+// CompileWithOptions is Compile with the given options.
+func CompileWithOptions(r io.Reader, opts Options) ([]*Contract, error) {
+	if err := opts.check(); err != nil {
+		return nil, err
+	}
 	inp, err := ioutil.ReadAll(r)
 	if err != nil {
 		return nil, errors.Wrap(err, "reading input")
 	}
-	contracts, err := parse(inp)
-	if err != nil {
-		return nil, errors.Wrap(err, "parse error")
+	var name string
+	if f, ok := r.(interface{ Name() string }); ok {
+		name = f.Name()
 	}
+	contracts, _, diags := compile(inp, name, opts)
+	if len(diags) > 0 {
+		return nil, diags
+	}
+	return contracts, nil
+}
+
+// compile does the work of Compile and CompileTests. It returns the
+// contracts even when there are problems, so that tools may inspect
+// whatever was parsed.
+func compile(inp []byte, name string, opts Options) ([]*Contract, []*Test, Diagnostics) {
+	var diags Diagnostics
+	contracts, tests, err := parse(inp, name, opts.Resolve)
+	diags.add(err)
 
 	globalEnv := newEnviron(nil)
 	for _, k := range keywords {
@@ -45,43 +73,55 @@ func Compile(r io.Reader) ([]*Contract, error) {
 	for _, contract := range contracts {
 		err = globalEnv.addContract(contract)
 		if err != nil {
-			return nil, err
+			diags.add(errorAt(contract.span, CodeRedeclared, err))
 		}
 	}
 
 	for _, contract := range contracts {
-		err = compileContract(contract, globalEnv)
-		if err != nil {
-			return nil, errors.Wrap(err, "compiling contract")
+		diags.add(compileContract(contract, globalEnv, opts))
+		if opts.WarningsAsErrors {
+			for _, w := range contract.Warnings {
+				d := *w
+				d.Severity = SeverityError
+				diags = append(diags, &d)
+			}
 		}
 	}
-
-	return contracts, nil
+	diags = append(diags, checkTests(tests, contracts)...)
+	diags.sort()
+	return contracts, tests, diags
 }
 
-func Instantiate(body []byte, params []*Param, recursive bool, args []ContractArg) ([]byte, error) {
-	if len(args) != len(params) {
-		return nil, fmt.Errorf("got %d argument(s), want %d", len(args), len(params))
-	}
-
-	// typecheck args against param types
+// checkArgs typechecks args against param types.
+func checkArgs(params []*Param, args []ContractArg) error {
 	for i, param := range params {
 		arg := args[i]
 		switch param.Type {
 		case amountType, intType:
 			if arg.I == nil {
-				return nil, fmt.Errorf("type mismatch in arg %d (want integer)", i)
+				return fmt.Errorf("type mismatch in arg %d (want integer)", i)
 			}
 		case assetType, hashType, progType, pubkeyType, sigType, signType, strType:
 			if arg.S == nil {
-				return nil, fmt.Errorf("type mismatch in arg %d (want string)", i)
+				return fmt.Errorf("type mismatch in arg %d (want string)", i)
 			}
 		case boolType:
 			if arg.B == nil {
-				return nil, fmt.Errorf("type mismatch in arg %d (want boolean)", i)
+				return fmt.Errorf("type mismatch in arg %d (want boolean)", i)
 			}
 		}
 	}
+	return nil
+}
+
+func Instantiate(body []byte, params []*Param, recursive bool, args []ContractArg) ([]byte, error) {
+	if len(args) != len(params) {
+		return nil, fmt.Errorf("got %d argument(s), want %d", len(args), len(params))
+	}
+
+	if err := checkArgs(params, args); err != nil {
+		return nil, err
+	}
 
 	b := vmutil.NewBuilder()
 
@@ -115,42 +155,48 @@ func Instantiate(body []byte, params []*Param, recursive bool, args []ContractAr
 	return b.Build()
 }
 
-func compileContract(contract *Contract, globalEnv *environ) error {
+func compileContract(contract *Contract, globalEnv *environ, opts Options) error {
 	var err error
 
 	if len(contract.Clauses) == 0 {
-		return fmt.Errorf("empty contract")
+		if contract.incomplete {
+			// the clauses were lost to syntax errors, already reported
+			return nil
+		}
+		return errorf(contract.span, CodeEmptyContract, "empty contract")
 	}
+
+	// Problems are collected so that every error in the contract is
+	// reported, not just the first.
+	var diags Diagnostics
+
 	env := newEnviron(globalEnv)
 	for _, p := range contract.Params {
 		err = env.add(p.Name, p.Type, roleContractParam)
 		if err != nil {
-			return err
+			diags.add(errorAt(p.span, CodeRedeclared, err))
 		}
 	}
 
 	// value is spilt with valueAmount and valueAsset
 	if err = env.add(contract.Value.Amount, amountType, roleContractValue); err != nil {
-		return err
+		diags.add(errorAt(contract.amountSpan, CodeRedeclared, err))
 	}
 	if err = env.add(contract.Value.Asset, assetType, roleContractValue); err != nil {
-		return err
+		diags.add(errorAt(contract.assetSpan, CodeRedeclared, err))
 	}
 
 	for _, c := range contract.Clauses {
 		err = env.add(c.Name, nilType, roleClause)
 		if err != nil {
-			return err
+			diags.add(errorAt(c.span, CodeRedeclared, err))
 		}
 	}
 
-	err = prohibitSigParams(contract)
-	if err != nil {
-		return err
-	}
-	err = requireAllParamsUsedInClauses(contract.Params, contract.Clauses)
-	if err != nil {
-		return err
+	diags.add(prohibitSigParams(contract))
+	if !contract.incomplete {
+		// a param may be used only in a clause lost to a syntax error
+		diags.add(requireAllParamsUsedInClauses(contract.Params, contract.Clauses))
 	}
 
 	var stk stack
@@ -168,14 +214,11 @@ func compileContract(contract *Contract, globalEnv *environ) error {
 		stk = stk.add(contract.Name)
 	}
 
-	b := &builder{}
+	b := &builder{opts: opts}
 	sequence := 0 // sequence is used to count the number of ifStatements
 
 	if len(contract.Clauses) == 1 {
-		err = compileClause(b, stk, contract, env, contract.Clauses[0], &sequence)
-		if err != nil {
-			return err
-		}
+		diags.add(compileClause(b, stk, contract, env, contract.Clauses[0], &sequence))
 	} else {
 		if len(contract.Params) > 0 {
 			// A clause selector is at the bottom of the stack. Roll it to the
@@ -187,39 +230,22 @@ func compileContract(contract *Contract, globalEnv *environ) error {
 			stk = b.addRoll(stk, n) // stack: [<clause params> <contract params> [<maybe contract body>] <clause selector>]
 		}
 
-		var stk2 stack
-
-		// clauses 2..N-1
-		for i := len(contract.Clauses) - 1; i >= 2; i-- {
-			stk = b.addDup(stk)                                                   // stack: [... <clause selector> <clause selector>]
-			stk = b.addInt64(stk, int64(i))                                       // stack: [... <clause selector> <clause selector> <i>]
-			stk = b.addNumEqual(stk, fmt.Sprintf("(<clause selector> == %d)", i)) // stack: [... <clause selector> <i == clause selector>]
-			stk = b.addJumpIf(stk, contract.Clauses[i].Name)                      // stack: [... <clause selector>]
-			stk2 = stk                                                            // stack starts here for clauses 2 through N-1
+		names := make([]string, len(contract.Clauses))
+		for i, clause := range contract.Clauses {
+			names[i] = clause.Name
 		}
-
-		// clause 1
-		stk = b.addJumpIf(stk, contract.Clauses[1].Name) // consumes the clause selector
-
-		// no jump needed for clause 0
+		contract.Dispatch = chooseDispatch(len(names), opts)
+		starts := compileDispatch(b, stk, names, contract.Dispatch)
 
 		for i, clause := range contract.Clauses {
-			if i > 1 {
-				// Clauses 0 and 1 have no clause selector on top of the
-				// stack. Clauses 2 and later do.
-				stk = stk2
-			}
-
+			stk = starts[i]
 			b.addJumpTarget(stk, clause.Name)
 
-			if i > 1 {
+			if stk.top() == "<clause selector>" {
 				stk = b.addDrop(stk)
 			}
 
-			err = compileClause(b, stk, contract, env, clause, &sequence)
-			if err != nil {
-				return errors.Wrapf(err, "compiling clause \"%s\"", clause.Name)
-			}
+			diags.add(compileClause(b, stk, contract, env, clause, &sequence))
 			b.forgetPendingVerify()
 			if i < len(contract.Clauses)-1 {
 				b.addJump(stk, "_end")
@@ -227,8 +253,25 @@ func compileContract(contract *Contract, globalEnv *environ) error {
 		}
 		b.addJumpTarget(stk, "_end")
 	}
+	if len(diags) > 0 {
+		return diags
+	}
 
-	opcodes := optimize(b.opcodes())
+	var (
+		ops     []string
+		origins []int
+	)
+	insts := b.instructions()
+	if opts.enabled(PassPeephole) {
+		insts = optimize(insts)
+	}
+	if opts.enabled(PassMerge) {
+		insts = mergeTails(insts, b.items)
+	}
+	for _, x := range insts {
+		ops, origins = append(ops, x.op), append(origins, x.origin)
+	}
+	opcodes := strings.Join(ops, " ")
 	prog, err := vm.Assemble(opcodes)
 	if err != nil {
 		return err
@@ -237,26 +280,120 @@ func compileContract(contract *Contract, globalEnv *environ) error {
 	contract.Body = prog
 	contract.Opcodes = opcodes
 
-	contract.Steps = b.steps()
+	stepAt := stepOffsets(prog, ops, origins)
+	if !opts.OmitSteps {
+		contract.Steps = b.steps()
+		contract.stepAt = stepAt
+	}
+	if !opts.OmitSourceMap {
+		contract.SourceMap = b.sourceMap(stepAt)
+	}
+	estimateUsage(contract, ops, b.conditions)
+	contract.Warnings = checkLimits(contract)
+
+	if contract.incomplete {
+		// a clause lost to a syntax error may leave no result
+		return nil
+	}
+	report, err := verifyBody(contract)
+	if err != nil {
+		return errorf(contract.span, CodeInternal, "compiled body of contract \"%s\" fails verification: %s", contract.Name, err)
+	}
+	for _, pc := range report.Unreachable {
+		w := warningf(contract.span, CodeUnreachable, "compiled body of contract \"%s\" has unreachable code at offset %d", contract.Name, pc)
+		if loc := contract.SourceAt(pc); loc.Line != 0 {
+			w.Location = loc
+		}
+		contract.Warnings = append(contract.Warnings, w)
+	}
 
 	return nil
 }
 
+// stepOffsets maps the offset of each instruction in prog, assembled
+// from ops, to the origin of the opcode it was assembled from. Labels
+// assemble to nothing and every other opcode to one instruction.
+func stepOffsets(prog []byte, ops []string, origins []int) map[uint32]int {
+	insts, err := vm.ParseProgram(prog)
+	if err != nil {
+		return nil
+	}
+	result := make(map[uint32]int)
+	var pc uint32
+	for i, op := range ops {
+		if strings.HasPrefix(op, "$") {
+			continue
+		}
+		if len(insts) == 0 {
+			return nil
+		}
+		result[pc] = origins[i]
+		pc += insts[0].Len
+		insts = insts[1:]
+	}
+	if len(insts) > 0 {
+		return nil
+	}
+	return result
+}
+
+// SourceAt returns the location of the statement or expression from
+// which the instruction containing offset pc of Body was compiled. It
+// is zero if the location is not known, as for the instructions that
+// select a clause.
+func (c *Contract) SourceAt(pc uint32) Location {
+	i := sort.Search(len(c.SourceMap), func(i int) bool { return c.SourceMap[i].Offset > pc })
+	if i == 0 || pc >= uint32(len(c.Body)) {
+		return Location{}
+	}
+	return c.SourceMap[i-1].Location
+}
+
+// StepAt returns the step from which the instruction at offset pc of
+// Body was compiled, or nil if it is not known.
+func (c *Contract) StepAt(pc uint32) *Step {
+	i, ok := c.stepAt[pc]
+	if !ok {
+		return nil
+	}
+	return &c.Steps[i]
+}
+
 func compileClause(b *builder, contractStk stack, contract *Contract, env *environ, clause *Clause, sequence *int) error {
-	var err error
+	if clause.incomplete {
+		// Statements were lost to syntax errors, already reported.
+		// Checking what remains would only produce spurious errors.
+		return nil
+	}
+
+	statements, reports := optimizeClause(b.opts, contractStk, contract, env, clause, *sequence)
+	err := compileStatements(b, contractStk, contract, env, clause, statements, sequence)
+	if err == nil && reports != nil {
+		// Report what the clause's own statements evaluate, not
+		// the variables sharing their subexpressions.
+		reports.restore(clause)
+	}
+	return err
+}
+
+// compileStatements compiles the statements of clause, which are
+// either clause.statements or statements equivalent to them.
+func compileStatements(b *builder, contractStk stack, contract *Contract, env *environ, clause *Clause, statements []statement, sequence *int) error {
+	var (
+		err   error
+		diags Diagnostics
+	)
 
 	// copy env to leave outerEnv unchanged
 	env = newEnviron(env)
 	for _, p := range clause.Params {
 		err = env.add(p.Name, p.Type, roleClauseParam)
 		if err != nil {
-			return err
+			diags.add(errorAt(p.span, CodeRedeclared, err))
 		}
 	}
 
-	if err = assignIndexes(clause); err != nil {
-		return err
-	}
+	diags.add(assignIndexes(clause))
 
 	var stk stack
 	for _, p := range clause.Params {
@@ -269,14 +406,31 @@ func compileClause(b *builder, contractStk stack, contract *Contract, env *envir
 
 	// a count of the number of times each variable is referenced
 	counts := make(map[string]int)
-	for _, stat := range clause.statements {
+	for _, stat := range statements {
 		counts = countsVarRef(stat, counts)
 	}
 
-	for _, stat := range clause.statements {
-		if stk, err = compileStatement(b, stk, contract, env, clause, counts, stat, sequence); err != nil {
-			return err
+	// statements that failed to compile are not type-checked
+	failed := make(map[statement]bool)
+	for _, stat := range statements {
+		stk2, err := compileStatement(b, stk, contract, env, clause, counts, stat, sequence)
+		if err != nil {
+			diags.add(errors.Wrapf(err, "compiling clause \"%s\"", clause.Name))
+			failed[stat] = true
+			if d, ok := stat.(*defineStatement); ok && stk.find(d.variable.Name) < 0 {
+				// stand in for the variable so later references to it
+				// are not reported as undefined
+				stk = stk.add(d.variable.Name)
+			}
+			continue
 		}
+		stk = stk2
+	}
+
+	diags.add(typeCheckClause(contract, clause, env, failed))
+	diags.add(requireAllParamsUsedInClause(clause.Params, clause))
+	if len(diags) > 0 {
+		return diags
 	}
 
 	var condValues []CondValueInfo
@@ -290,19 +444,12 @@ func compileClause(b *builder, contractStk stack, contract *Contract, env *envir
 		}
 	}
 
-	err = typeCheckClause(contract, clause, env)
-	if err != nil {
-		return err
-	}
-	err = requireAllParamsUsedInClause(clause.Params, clause)
-	if err != nil {
-		return err
-	}
-
 	return nil
 }
 
 func compileStatement(b *builder, stk stack, contract *Contract, env *environ, clause *Clause, counts map[string]int, stat statement, sequence *int) (stack, error) {
+	defer b.setStatement(b.setStatement(stat.pos()))
+
 	var err error
 	switch stmt := stat.(type) {
 	case *ifStatement:
@@ -334,6 +481,7 @@ func compileStatement(b *builder, stk stack, contract *Contract, env *environ, c
 		} else {
 			label = "endif_" + strSequence
 		}
+		b.addCondition(label, stmt.condition.String())
 		stk = b.addJumpIf(stk, label)
 		b.addJumpTarget(stk, "if_"+strSequence)
 
@@ -383,12 +531,12 @@ func compileStatement(b *builder, stk stack, contract *Contract, env *environ, c
 	case *defineStatement:
 		// add environ for define variable
 		if err = env.add(stmt.variable.Name, stmt.variable.Type, roleClauseVariable); err != nil {
-			return stk, err
+			return stk, errorAt(stmt.variable.span, CodeRedeclared, err)
 		}
 
 		// check whether the variable is used or not
 		if counts[stmt.variable.Name] == 0 {
-			return stk, fmt.Errorf("the defined variable \"%s\" is unused in clause \"%s\"", stmt.variable.Name, clause.Name)
+			return stk, errorf(stmt.variable.span, CodeUnused, "the defined variable \"%s\" is unused in clause \"%s\"", stmt.variable.Name, clause.Name)
 		}
 
 		if stmt.expr != nil {
@@ -409,11 +557,11 @@ func compileStatement(b *builder, stk stack, contract *Contract, env *environ, c
 		// find variable from environ with roleClauseVariable
 		if entry := env.lookup(string(stmt.variable.Name)); entry != nil {
 			if entry.r != roleClauseVariable {
-				return stk, fmt.Errorf("the type of variable is not roleClauseVariable in assign statement in clause \"%s\"", clause.Name)
+				return stk, errorf(stmt.variable.span, CodeAssign, "the type of variable is not roleClauseVariable in assign statement in clause \"%s\"", clause.Name)
 			}
 			stmt.variable.Type = entry.t
 		} else {
-			return stk, fmt.Errorf("the variable \"%s\" is not defined before the assign statement in clause \"%s\"", stmt.variable.Name, clause.Name)
+			return stk, errorf(stmt.variable.span, CodeUndefined, "the variable \"%s\" is not defined before the assign statement in clause \"%s\"", stmt.variable.Name, clause.Name)
 		}
 
 		// temporary store the counts of defined variable
@@ -533,7 +681,7 @@ func compileStatement(b *builder, stk stack, contract *Contract, env *environ, c
 		}
 
 		// version
-		stk = b.addInt64(stk, 1)
+		stk = b.addInt64(stk, b.opts.vmVersion())
 
 		// prog
 		stk, err = compileExpr(b, stk, contract, clause, env, counts, stmt.program)
@@ -545,6 +693,17 @@ func compileStatement(b *builder, stk stack, contract *Contract, env *environ, c
 			stmt.lockedAmount.String(), stmt.lockedAsset.String(), stmt.program))
 		stk = b.addVerify(stk)
 
+	case *dropStatement:
+		stk = compileRoll(b, stk, stmt.name)
+		stk = b.addDrop(stk)
+
+	case *stashStatement:
+		stk = compileRoll(b, stk, stmt.name)
+		stk, _ = b.addToAltStack(stk)
+
+	case *unstashStatement:
+		stk = b.addFromAltStack(stk, stmt.name)
+
 	case *unlockStatement:
 		if len(clause.statements) == 1 {
 			// This is the only statement in the clause, make sure TRUE is
@@ -557,7 +716,10 @@ func compileStatement(b *builder, stk stack, contract *Contract, env *environ, c
 }
 
 func compileExpr(b *builder, stk stack, contract *Contract, clause *Clause, env *environ, counts map[string]int, expr expression) (stack, error) {
+	defer b.setExpression(b.setExpression(expr.pos()))
+
 	var err error
+	mark, stk0 := b.mark(), stk
 
 	switch e := expr.(type) {
 	case *binaryExpr:
@@ -565,25 +727,33 @@ func compileExpr(b *builder, stk stack, contract *Contract, clause *Clause, env
 		// compilation errors are more interesting than type mismatch
 		// errors).
 
+		if e.swapped {
+			stk, err = compileExpr(b, stk, contract, clause, env, counts, e.right)
+			if err != nil {
+				return stk, errors.Wrapf(err, "in right operand of \"%s\" expression", e.op.op)
+			}
+		}
 		stk, err = compileExpr(b, stk, contract, clause, env, counts, e.left)
 		if err != nil {
 			return stk, errors.Wrapf(err, "in left operand of \"%s\" expression", e.op.op)
 		}
-		stk, err = compileExpr(b, stk, contract, clause, env, counts, e.right)
-		if err != nil {
-			return stk, errors.Wrapf(err, "in right operand of \"%s\" expression", e.op.op)
+		if !e.swapped {
+			stk, err = compileExpr(b, stk, contract, clause, env, counts, e.right)
+			if err != nil {
+				return stk, errors.Wrapf(err, "in right operand of \"%s\" expression", e.op.op)
+			}
 		}
 
 		lType := e.left.typ(env)
 		if e.op.left != "" && ((e.op.left == intType && !(lType == amountType || lType == intType)) ||
 			(e.op.left == boolType && !(lType == boolType))) {
-			return stk, fmt.Errorf("in \"%s\", left operand has type \"%s\", must be \"%s\"", e, lType, e.op.left)
+			return stk, errorf(e.left.pos(), CodeTypeMismatch, "in \"%s\", left operand has type \"%s\", must be \"%s\"", e, lType, e.op.left)
 		}
 
 		rType := e.right.typ(env)
 		if e.op.right != "" && ((e.op.right == intType && !(rType == amountType || rType == intType)) ||
 			(e.op.right == boolType && !(rType == boolType))) {
-			return stk, fmt.Errorf("in \"%s\", right operand has type \"%s\", must be \"%s\"", e, rType, e.op.right)
+			return stk, errorf(e.right.pos(), CodeTypeMismatch, "in \"%s\", right operand has type \"%s\", must be \"%s\"", e, rType, e.op.right)
 		}
 
 		switch e.op.op {
@@ -596,15 +766,19 @@ func compileExpr(b *builder, stk stack, contract *Contract, clause *Clause, env
 				} else if rType == hashType && isHashSubtype(lType) {
 					propagateType(contract, clause, env, lType, e.right)
 				} else {
-					return stk, fmt.Errorf("type mismatch in \"%s\": left operand has type \"%s\", right operand has type \"%s\"", e, lType, rType)
+					return stk, errorf(e.span, CodeTypeMismatch, "type mismatch in \"%s\": left operand has type \"%s\", right operand has type \"%s\"", e, lType, rType)
 				}
 			}
 			if lType == "Boolean" {
-				return stk, fmt.Errorf("in \"%s\": using \"%s\" on Boolean values not allowed", e, e.op.op)
+				return stk, errorf(e.span, CodeTypeMismatch, "in \"%s\": using \"%s\" on Boolean values not allowed", e, e.op.op)
 			}
 		}
 
-		stk = b.addOps(stk.dropN(2), e.op.opcodes, e.String())
+		opcodes := e.op.opcodes
+		if e.swapped {
+			opcodes = e.op.mirror().opcodes
+		}
+		stk = b.addOps(stk.dropN(2), opcodes, e.String())
 
 	case *unaryExpr:
 		// Do typechecking after compiling subexpression (because other
@@ -618,7 +792,7 @@ func compileExpr(b *builder, stk stack, contract *Contract, clause *Clause, env
 		}
 
 		if e.op.operand != "" && e.expr.typ(env) != e.op.operand {
-			return stk, fmt.Errorf("in \"%s\", operand has type \"%s\", must be \"%s\"", e, e.expr.typ(env), e.op.operand)
+			return stk, errorf(e.expr.pos(), CodeTypeMismatch, "in \"%s\", operand has type \"%s\", must be \"%s\"", e, e.expr.typ(env), e.op.operand)
 		}
 		b.addOps(stk.drop(), e.op.opcodes, e.String())
 
@@ -626,21 +800,21 @@ func compileExpr(b *builder, stk stack, contract *Contract, clause *Clause, env
 		bi := referencedBuiltin(e.fn)
 		if bi == nil {
 			if v, ok := e.fn.(varRef); ok {
-				if entry := env.lookup(string(v)); entry != nil && entry.t == contractType {
+				if entry := env.lookup(v.name); entry != nil && entry.t == contractType {
 					clause.Contracts = append(clause.Contracts, entry.c.Name)
 
 					partialName := fmt.Sprintf("%s(...)", v)
 					stk = b.addData(stk, nil)
 
 					if len(e.args) != len(entry.c.Params) {
-						return stk, fmt.Errorf("contract \"%s\" expects %d argument(s), got %d", entry.c.Name, len(entry.c.Params), len(e.args))
+						return stk, errorf(e.span, CodeArgCount, "contract \"%s\" expects %d argument(s), got %d", entry.c.Name, len(entry.c.Params), len(e.args))
 					}
 
 					for i := len(e.args) - 1; i >= 0; i-- {
 						arg := e.args[i]
 						if entry.c.Params[i].Type != "" && arg.typ(env) != entry.c.Params[i].Type &&
 							!(arg.typ(env) == intType && entry.c.Params[i].Type == amountType) {
-							return stk, fmt.Errorf("argument %d to contract \"%s\" has type \"%s\", must be \"%s\"", i, entry.c.Name, arg.typ(env), entry.c.Params[i].Type)
+							return stk, errorf(arg.pos(), CodeTypeMismatch, "argument %d to contract \"%s\" has type \"%s\", must be \"%s\"", i, entry.c.Name, arg.typ(env), entry.c.Params[i].Type)
 						}
 						stk, err = compileExpr(b, stk, contract, clause, env, counts, arg)
 						if err != nil {
@@ -653,7 +827,7 @@ func compileExpr(b *builder, stk stack, contract *Contract, clause *Clause, env
 					case entry.c == contract:
 						// Recursive call - cannot use entry.c.Body
 						// <argN> <argN-1> ... <arg1> <body> DEPTH OVER 0 CHECKPREDICATE
-						stk, err = compileRef(b, stk, counts, varRef(contract.Name))
+						stk, err = compileRef(b, stk, counts, varRef{name: contract.Name, span: v.span})
 						if err != nil {
 							return stk, errors.Wrap(err, "compiling contract call")
 						}
@@ -666,7 +840,7 @@ func compileExpr(b *builder, stk stack, contract *Contract, clause *Clause, env
 						// <argN> <argN-1> ... <arg1> <body> DEPTH OVER 0 CHECKPREDICATE
 						if len(entry.c.Body) == 0 {
 							// TODO(bobg): sort input contracts topologically to permit forward calling
-							return stk, fmt.Errorf("contract \"%s\" not defined", entry.c.Name)
+							return stk, errorf(v.span, CodeUndefined, "contract \"%s\" not defined", entry.c.Name)
 						}
 						stk = b.addData(stk, entry.c.Body)
 						stk = b.addCatPushdata(stk, partialName)
@@ -680,7 +854,7 @@ func compileExpr(b *builder, stk stack, contract *Contract, clause *Clause, env
 						stk = b.addCat(stk, partialName)
 						if len(entry.c.Body) == 0 {
 							// TODO(bobg): sort input contracts topologically to permit forward calling
-							return stk, fmt.Errorf("contract \"%s\" not defined", entry.c.Name)
+							return stk, errorf(v.span, CodeUndefined, "contract \"%s\" not defined", entry.c.Name)
 						}
 						stk = b.addData(stk, entry.c.Body)
 						stk = b.addCatPushdata(stk, partialName)
@@ -693,11 +867,11 @@ func compileExpr(b *builder, stk stack, contract *Contract, clause *Clause, env
 					return stk, nil
 				}
 			}
-			return stk, fmt.Errorf("unknown function \"%s\"", e.fn)
+			return stk, errorf(e.fn.pos(), CodeUndefined, "unknown function \"%s\"", e.fn)
 		}
 
 		if len(e.args) != len(bi.args) {
-			return stk, fmt.Errorf("wrong number of args for \"%s\": have %d, want %d", bi.name, len(e.args), len(bi.args))
+			return stk, errorf(e.span, CodeArgCount, "wrong number of args for \"%s\": have %d, want %d", bi.name, len(e.args), len(bi.args))
 		}
 
 		// WARNING WARNING WOOP WOOP
@@ -705,10 +879,10 @@ func compileExpr(b *builder, stk stack, contract *Contract, clause *Clause, env
 		// WARNING WARNING WOOP WOOP
 		if bi.name == "checkTxMultiSig" {
 			if _, ok := e.args[0].(listExpr); !ok {
-				return stk, fmt.Errorf("checkTxMultiSig expects list literals, got %T for argument 0", e.args[0])
+				return stk, errorf(e.args[0].pos(), CodeTypeMismatch, "checkTxMultiSig expects list literals, got %T for argument 0", e.args[0])
 			}
 			if _, ok := e.args[1].(listExpr); !ok {
-				return stk, fmt.Errorf("checkTxMultiSig expects list literals, got %T for argument 1", e.args[1])
+				return stk, errorf(e.args[1].pos(), CodeTypeMismatch, "checkTxMultiSig expects list literals, got %T for argument 1", e.args[1])
 			}
 
 			var k1, k2 int
@@ -756,7 +930,7 @@ func compileExpr(b *builder, stk stack, contract *Contract, clause *Clause, env
 		// errors).
 		for i, actual := range e.args {
 			if bi.args[i] != "" && actual.typ(env) != bi.args[i] {
-				return stk, fmt.Errorf("argument %d to \"%s\" has type \"%s\", must be \"%s\"", i, bi.name, actual.typ(env), bi.args[i])
+				return stk, errorf(actual.pos(), CodeTypeMismatch, "argument %d to \"%s\" has type \"%s\", must be \"%s\"", i, bi.name, actual.typ(env), bi.args[i])
 			}
 		}
 
@@ -772,13 +946,13 @@ func compileExpr(b *builder, stk stack, contract *Contract, clause *Clause, env
 		return compileRef(b, stk, counts, e)
 
 	case integerLiteral:
-		stk = b.addInt64(stk, int64(e))
+		stk = b.addInt64(stk, e.value)
 
 	case bytesLiteral:
-		stk = b.addData(stk, []byte(e))
+		stk = b.addData(stk, e.value)
 
 	case booleanLiteral:
-		stk = b.addBoolean(stk, bool(e))
+		stk = b.addBoolean(stk, e.value)
 
 	case listExpr:
 		// Lists are excluded here because they disobey the invariant of
@@ -786,7 +960,24 @@ func compileExpr(b *builder, stk stack, contract *Contract, clause *Clause, env
 		// exactly one. (A list pushes its items and its length on the
 		// stack.) But they're OK as function-call arguments because the
 		// function (presumably) consumes all the stack items added.
-		return stk, fmt.Errorf("encountered list outside of function-call context")
+		return stk, errorf(e.span, CodeListContext, "encountered list outside of function-call context")
+	}
+
+	switch expr.(type) {
+	case *binaryExpr, *unaryExpr, *callExpr:
+		// An expression of literals is replaced by its value, which
+		// saves the gas of evaluating it. At OptSize that is only
+		// where the value is no larger than the code that evaluates
+		// it, as the hash of a short literal is not. The expression is
+		// compiled all the same for the checks made along the way.
+		value, err := constant(expr)
+		if err != nil {
+			return stk, err
+		}
+		if value != nil && b.opts.enabled(PassFold) && (b.opts.Optimize != OptSize || assembledSize(constantOp(value)) <= b.sizeSince(mark)) {
+			b.reset(mark)
+			stk = b.addConstant(stk0, value, expr.String())
+		}
 	}
 	return stk, nil
 }
@@ -794,8 +985,8 @@ func compileExpr(b *builder, stk stack, contract *Contract, clause *Clause, env
 func compileArg(b *builder, stk stack, contract *Contract, clause *Clause, env *environ, counts map[string]int, expr expression) (stack, int, error) {
 	var n int
 	if list, ok := expr.(listExpr); ok {
-		for i := 0; i < len(list); i++ {
-			elt := list[len(list)-i-1]
+		for i := 0; i < len(list.elts); i++ {
+			elt := list.elts[len(list.elts)-i-1]
 			var err error
 			stk, err = compileExpr(b, stk, contract, clause, env, counts, elt)
 			if err != nil {
@@ -803,7 +994,7 @@ func compileArg(b *builder, stk stack, contract *Contract, clause *Clause, env *
 			}
 			n++
 		}
-		stk = b.addInt64(stk, int64(len(list)))
+		stk = b.addInt64(stk, int64(len(list.elts)))
 		n++
 		return stk, n, nil
 	}
@@ -829,15 +1020,15 @@ func compileContractValue(b *builder, expr expression, contractValue ValueInfo,
 }
 
 func compileRef(b *builder, stk stack, counts map[string]int, ref varRef) (stack, error) {
-	depth := stk.find(string(ref))
+	depth := stk.find(ref.name)
 	if depth < 0 {
-		return stk, fmt.Errorf("undefined reference: \"%s\"", ref)
+		return stk, errorf(ref.span, CodeUndefined, "undefined reference: \"%s\"", ref)
 	}
 
 	var isFinal bool
-	if count, ok := counts[string(ref)]; ok && count > 0 {
+	if count, ok := counts[ref.name]; ok && count > 0 {
 		count--
-		counts[string(ref)] = count
+		counts[ref.name] = count
 		isFinal = count == 0
 	}
 
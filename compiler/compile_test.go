@@ -2,8 +2,11 @@ package compiler
 
 import (
 	"encoding/hex"
+	"encoding/json"
 	"strings"
 	"testing"
+
+	"github.com/bytom/protocol/vm"
 )
 
 const TrivialLock = `
@@ -311,7 +314,7 @@ func TestCompile(t *testing.T) {
 		{
 			"TestSigIf",
 			TestSigIf,
-			"53797b879169765379a09161641c00000052795279a0696321000000765279a069",
+			"53797b879169765379a09161641b00000052795279a0631f000000765279a069",
 		},
 		{
 			"TestIfAndMultiClause",
@@ -321,12 +324,12 @@ func TestCompile(t *testing.T) {
 		{
 			"TestIfNesting",
 			TestIfNesting,
-			"7b644400000054795279879169765579a09161643500000052795479a091616429000000765379a06952795579879169633a000000765479a06953797b8791635c0000007654798791695279a091616459000000527978a0697d8791",
+			"7b644300000054795279879169765579a09161643400000052795479a091616429000000765379a0695279557987916338000000765479a06953797b8791635b0000007654798791695279a091616458000000527978a0697d8791",
 		},
 		{
 			"TestConstantMath",
 			TestConstantMath,
-			"765779577a935a93887c0431323330aa887c06737472696e67aa887c91697b011493879a",
+			"765779577a935a93887c20e413d68bba47f4cc1791767fb3c6f6b2c0e85202e94e713b989ab305d50f602c887c2022e829107201c6b975b1dc60b928117916285ceb4aa5c6d7b4b8cc48038083e0887c91697b011493879a",
 		},
 		{
 			"VerifySignature",
@@ -357,3 +360,229 @@ func TestCompile(t *testing.T) {
 		})
 	}
 }
+
+func TestCompileDiagnostics(t *testing.T) {
+	cases := []struct {
+		name     string
+		contract string
+		want     Diagnostic
+	}{
+		{
+			"syntax error",
+			"contract Foo() locks amount of asset {\n  clause bar() {\n    unlock amount\n  }\n}\n",
+			Diagnostic{Location: Location{Line: 4, Col: 2, EndLine: 4, EndCol: 3}, Severity: SeverityError, Code: CodeSyntax, Message: "expected keyword of"},
+		},
+		{
+			"undefined reference",
+			"contract Foo() locks amount of asset {\n  clause bar() {\n    verify baz > 3\n    unlock amount of asset\n  }\n}\n",
+			Diagnostic{Location: Location{Line: 3, Col: 11, EndLine: 3, EndCol: 14}, Severity: SeverityError, Code: CodeUndefined, Message: "undefined reference: \"baz\""},
+		},
+		{
+			"type mismatch",
+			"contract Foo(x: Integer) locks amount of asset {\n  clause bar() {\n    verify x\n    unlock amount of asset\n  }\n}\n",
+			Diagnostic{Location: Location{Line: 3, Col: 11, EndLine: 3, EndCol: 12}, Severity: SeverityError, Code: CodeTypeMismatch, Message: "expression in verify statement in clause \"bar\" has type \"Integer\", must be Boolean"},
+		},
+		{
+			"unused parameter",
+			"contract Foo(x: Integer,\n             y: Integer) locks amount of asset {\n  clause bar() {\n    verify x > 3\n    unlock amount of asset\n  }\n}\n",
+			Diagnostic{Location: Location{Line: 2, Col: 13, EndLine: 2, EndCol: 14}, Severity: SeverityError, Code: CodeUnused, Message: "parameter \"y\" is unused"},
+		},
+		{
+			"wrong argument count",
+			"contract Foo(x: Integer) locks amount of asset {\n  clause bar() {\n    verify min(x) > 3\n    unlock amount of asset\n  }\n}\n",
+			Diagnostic{Location: Location{Line: 3, Col: 11, EndLine: 3, EndCol: 17}, Severity: SeverityError, Code: CodeArgCount, Message: "wrong number of args for \"min\": have 1, want 2"},
+		},
+		{
+			"division by zero",
+			"contract Foo(x: Integer) locks amount of asset {\n  clause bar() {\n    verify x / 0 > 3\n    unlock amount of asset\n  }\n}\n",
+			Diagnostic{Location: Location{Line: 3, Col: 11, EndLine: 3, EndCol: 16}, Severity: SeverityError, Code: CodeFault, Message: "division by zero in \"(x / 0)\""},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := Compile(strings.NewReader(c.contract))
+			diags, ok := err.(Diagnostics)
+			if !ok {
+				t.Fatalf("got error %v (%T), want Diagnostics", err, err)
+			}
+			if len(diags) != 1 {
+				t.Fatalf("got %d diagnostics, want 1: %v", len(diags), diags)
+			}
+			if *diags[0] != c.want {
+				t.Errorf("got %+v\nwant %+v", *diags[0], c.want)
+			}
+		})
+	}
+}
+
+func TestDiagnosticError(t *testing.T) {
+	loc := Location{Line: 3, Col: 11, EndLine: 3, EndCol: 14}
+	cases := []struct {
+		file string
+		want string
+	}{
+		{"", "line 3, col 11: undefined reference: \"baz\""},
+		{"foo.equity", "foo.equity:3:12: undefined reference: \"baz\""},
+	}
+	for _, c := range cases {
+		loc.File = c.file
+		d := &Diagnostic{Location: loc, Severity: SeverityError, Code: CodeUndefined, Message: "undefined reference: \"baz\""}
+		if got := d.Error(); got != c.want {
+			t.Errorf("got %q, want %q", got, c.want)
+		}
+	}
+
+	// JSON has the column as it is, counted from 0.
+	b, err := json.Marshal(&Diagnostic{Location: loc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"col":11,`) {
+		t.Errorf("got %s, want col 11", b)
+	}
+}
+
+func TestCompileReportsAllErrors(t *testing.T) {
+	const src = `
+contract A(x: Integer, y: Integer) locks amount of asset {
+  clause one() {
+    verify x > 3 &&
+  }
+  clause two() {
+    verify y
+    verify z > 2
+    unlock amount of asset
+  }
+}
+
+contract B(k: PublicKey locks amount of asset {
+  clause c(s: Signature) {
+    verify checkTxSig(k, s)
+    unlock amount of asset
+  }
+}
+
+contract C(p: Program) locks amount of asset {
+  clause c() {
+    define v: Integer = 3 * true
+    verify v > 1
+    lock amount of asset with p
+  }
+}
+`
+	want := []struct {
+		line int
+		code string
+	}{
+		{5, CodeSyntax},
+		{7, CodeTypeMismatch},
+		{8, CodeUndefined},
+		{13, CodeSyntax},
+		{22, CodeTypeMismatch},
+	}
+
+	_, err := Compile(strings.NewReader(src))
+	diags, ok := err.(Diagnostics)
+	if !ok {
+		t.Fatalf("got error %v (%T), want Diagnostics", err, err)
+	}
+	if len(diags) != len(want) {
+		t.Fatalf("got %d diagnostics, want %d:\n%v", len(diags), len(want), diags)
+	}
+	for i, w := range want {
+		if diags[i].Line != w.line || diags[i].Code != w.code {
+			t.Errorf("diagnostic %d: got line %d code %s (%s), want line %d code %s", i, diags[i].Line, diags[i].Code, diags[i].Message, w.line, w.code)
+		}
+	}
+}
+
+func TestStepAt(t *testing.T) {
+	const src = `
+contract LockWithDeadline(publicKey: PublicKey, deadline: Integer) locks value of asset {
+  clause spend(sig: Signature) {
+    verify checkTxSig(publicKey, sig)
+    unlock value of asset
+  }
+  clause expire() {
+    verify above(deadline)
+    unlock value of asset
+  }
+}
+`
+	contract := mustCompile(t, src)
+	insts, err := vm.ParseProgram(contract.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := make(map[vm.Op]int)
+	var pc uint32
+	for _, inst := range insts {
+		step := contract.StepAt(pc)
+		if step == nil {
+			t.Fatalf("no step for pc %d %s", pc, inst.Op)
+		}
+		if inst.Op == vm.OP_CHECKSIG || inst.Op == vm.OP_BLOCKHEIGHT {
+			lines[inst.Op] = step.Statement.Line
+		}
+		pc += inst.Len
+	}
+	if lines[vm.OP_CHECKSIG] != 4 || lines[vm.OP_BLOCKHEIGHT] != 8 {
+		t.Errorf("got CHECKSIG on line %d and BLOCKHEIGHT on line %d, want 4 and 8", lines[vm.OP_CHECKSIG], lines[vm.OP_BLOCKHEIGHT])
+	}
+	if contract.StepAt(pc) != nil {
+		t.Errorf("got a step for pc %d past the end", pc)
+	}
+}
+
+func TestSourceMap(t *testing.T) {
+	const src = `
+contract LockWithDeadline(publicKey: PublicKey, deadline: Integer) locks value of asset {
+  clause spend(sig: Signature) {
+    verify checkTxSig(publicKey, sig)
+    unlock value of asset
+  }
+  clause expire() {
+    verify above(deadline + 1099511627775)
+    unlock value of asset
+  }
+}
+`
+	contract := mustCompile(t, src)
+	insts, err := vm.ParseProgram(contract.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(contract.SourceMap) != len(insts) {
+		t.Fatalf("got %d source mappings for %d instructions", len(contract.SourceMap), len(insts))
+	}
+
+	var pc uint32
+	for i, inst := range insts {
+		if contract.SourceMap[i].Offset != pc {
+			t.Fatalf("mapping %d: got offset %d, want %d", i, contract.SourceMap[i].Offset, pc)
+		}
+		var want Location
+		switch inst.Op {
+		case vm.OP_CHECKSIG:
+			want = Location{Line: 4, Col: 11, EndLine: 4, EndCol: 37}
+		case vm.OP_DATA_5:
+			want = Location{Line: 8, Col: 28, EndLine: 8, EndCol: 41}
+		case vm.OP_ADD:
+			want = Location{Line: 8, Col: 17, EndLine: 8, EndCol: 41}
+		default:
+			pc += inst.Len
+			continue
+		}
+		// every offset within the instruction maps to it
+		for off := pc; off < pc+inst.Len; off++ {
+			if got := contract.SourceAt(off); got != want {
+				t.Errorf("%s at offset %d: got %+v, want %+v", inst.Op, off, got, want)
+			}
+		}
+		pc += inst.Len
+	}
+	if got := contract.SourceAt(pc); got != (Location{}) {
+		t.Errorf("got %+v past the end", got)
+	}
+}
@@ -0,0 +1,513 @@
+package compiler
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/bytom/protocol/vm"
+)
+
+// optimizeClause returns statements equivalent to those of clause
+// that compile to a smaller program, or clause.statements if it finds
+// none. It shares subexpressions and schedules the stack, as opts
+// enable. If it returns other statements, it also returns what
+// compiling the clause's own statements reports of it.
+func optimizeClause(opts Options, contractStk stack, contract *Contract, env *environ, clause *Clause, sequence int) ([]statement, *clauseReports) {
+	if !opts.enabled(PassShare) && !opts.enabled(PassSchedule) {
+		return clause.statements, nil
+	}
+
+	s := &clauseSearch{
+		opts:        opts,
+		contractStk: contractStk,
+		contract:    contract,
+		outerEnv:    env,
+		env:         newEnviron(env),
+		clause:      clause,
+		sequence:    sequence,
+		assigned:    make(map[string]bool),
+	}
+	defined := make(map[string]bool)
+	if contract.Recursive {
+		defined[contract.Name] = true
+	}
+	for _, p := range contract.Params {
+		defined[p.Name] = true
+	}
+	for _, p := range clause.Params {
+		defined[p.Name] = true
+		s.env.add(p.Name, p.Type, roleClauseParam)
+	}
+	for _, stat := range clause.statements {
+		s.declare(stat)
+	}
+
+	saved := saveReports(clause)
+	defer saved.restore(clause)
+
+	best, reports := s.size(clause.statements)
+	if best < 0 {
+		return clause.statements, nil
+	}
+
+	statements := clause.statements
+	if opts.enabled(PassShare) {
+		statements = s.share(statements, defined, func(stmts []statement) []statement { return stmts })
+	}
+	if opts.enabled(PassSchedule) {
+		statements = s.schedule(statements)
+	}
+	if n, _ := s.size(statements); n >= best {
+		return clause.statements, nil
+	}
+	return statements, &reports
+}
+
+// clauseSearch searches for statements equivalent to those of a
+// clause that compile to a smaller program.
+type clauseSearch struct {
+	opts        Options
+	contractStk stack
+	contract    *Contract
+	outerEnv    *environ
+	clause      *Clause
+	sequence    int
+
+	// env holds the parameters and variables of the clause, for the
+	// types of the variables sharing subexpressions.
+	env *environ
+
+	// assigned holds the variables that assign statements change,
+	// whose values differ from one point of the clause to another.
+	assigned map[string]bool
+}
+
+// declare adds the variables that stat defines to s.env, and notes
+// those it assigns.
+func (s *clauseSearch) declare(stat statement) {
+	switch stmt := stat.(type) {
+	case *defineStatement:
+		s.env.add(stmt.variable.Name, stmt.variable.Type, roleClauseVariable)
+	case *assignStatement:
+		s.assigned[stmt.variable.Name] = true
+	case *ifStatement:
+		for _, st := range stmt.body.trueBody {
+			s.declare(st)
+		}
+		for _, st := range stmt.body.falseBody {
+			s.declare(st)
+		}
+	}
+}
+
+// share returns stmts, a list of statements before which the names
+// in defined are on the stack, with its subexpressions shared where
+// that makes the clause smaller. The clause's statements with stmts
+// in place of the list are whole(stmts).
+//
+// A subexpression that stmts evaluates more than once is evaluated
+// once, into a variable, and referred to after. It is shared only at
+// a point where it is to be evaluated on every path through the
+// clause, so that no spend fails that would not have. The variable is
+// named for the subexpression, so that the statements read as before.
+func (s *clauseSearch) share(stmts []statement, defined map[string]bool, whole func([]statement) []statement) []statement {
+	best, _ := s.size(whole(stmts))
+	if best < 0 {
+		return stmts
+	}
+
+	rejected := make(map[string]bool)
+	for improved := true; improved; {
+		improved = false
+		for _, c := range s.candidates(stmts, defined, rejected) {
+			try := c.apply(stmts, s.env)
+			if n, _ := s.size(whole(try)); n >= 0 && n < best {
+				stmts, best, improved = try, n, true
+				break
+			}
+			rejected[c.key] = true
+		}
+	}
+
+	// Then share what is evaluated more than once in the body of an
+	// if statement, though not on every path through the clause.
+	stmts = append([]statement(nil), stmts...)
+	defined = copyDefined(defined)
+	for i, stat := range stmts {
+		switch stmt := stat.(type) {
+		case *defineStatement:
+			defined[stmt.variable.Name] = true
+
+		case *ifStatement:
+			i, stmt := i, stmt
+			withBody := func(trueBody, falseBody []statement) []statement {
+				result := append([]statement(nil), stmts...)
+				result[i] = &ifStatement{
+					condition: stmt.condition,
+					body:      &IfStatmentBody{trueBody: trueBody, falseBody: falseBody},
+					span:      stmt.span,
+				}
+				return result
+			}
+			falseBody := stmt.body.falseBody
+			trueBody := s.share(stmt.body.trueBody, defined, func(body []statement) []statement {
+				return whole(withBody(body, falseBody))
+			})
+			falseBody = s.share(falseBody, defined, func(body []statement) []statement {
+				return whole(withBody(trueBody, body))
+			})
+			stmts = withBody(trueBody, falseBody)
+		}
+	}
+	return stmts
+}
+
+func copyDefined(defined map[string]bool) map[string]bool {
+	result := make(map[string]bool)
+	for k, v := range defined {
+		result[k] = v
+	}
+	return result
+}
+
+// size returns the size of the compiled clause with statements stmts,
+// or -1 if it does not compile, and what compiling it reports.
+func (s *clauseSearch) size(stmts []statement) (int, clauseReports) {
+	defer saveReports(s.clause).restore(s.clause)
+
+	b := &builder{opts: s.opts}
+	sequence := s.sequence
+	if err := compileStatements(b, s.contractStk, s.contract, s.outerEnv, s.clause, stmts, &sequence); err != nil {
+		return -1, clauseReports{}
+	}
+	insts := b.instructions()
+	if s.opts.enabled(PassPeephole) {
+		insts = optimize(insts)
+	}
+	if s.opts.enabled(PassMerge) {
+		insts = mergeTails(insts, b.items)
+	}
+	var ops []string
+	for _, x := range insts {
+		ops = append(ops, x.op)
+	}
+	prog, err := vm.Assemble(strings.Join(ops, " "))
+	if err != nil {
+		return -1, clauseReports{}
+	}
+	return len(prog), saveReports(s.clause)
+}
+
+// subexpression is a subexpression that may be shared.
+type subexpression struct {
+	key  string
+	expr expression
+
+	// at is the index of the first statement evaluating it.
+	at int
+}
+
+// candidates returns the subexpressions that stmts evaluates more
+// than once and that may be evaluated before the first statement to
+// use them, largest first.
+func (s *clauseSearch) candidates(stmts []statement, defined, rejected map[string]bool) []subexpression {
+	var (
+		result []subexpression
+		found  = make(map[string]bool)
+		counts = make(map[string]int)
+		always = make(map[string]bool)
+	)
+	defined = copyDefined(defined)
+	for i, stat := range stmts {
+		for _, e := range statementExprs(stat, true) {
+			walkExpr(e, func(e expression) {
+				switch e.(type) {
+				case *binaryExpr, *unaryExpr, *callExpr:
+				default:
+					return
+				}
+				key := e.String()
+				counts[key]++
+				if !found[key] && !rejected[key] && s.shareable(e, defined) {
+					result = append(result, subexpression{key: key, expr: e, at: i})
+					found[key] = true
+				}
+			})
+		}
+		for key := range evaluated(stat) {
+			always[key] = true
+		}
+		if d, ok := stat.(*defineStatement); ok {
+			defined[d.variable.Name] = true
+		}
+	}
+
+	var shared []subexpression
+	for _, c := range result {
+		if counts[c.key] > 1 && always[c.key] {
+			shared = append(shared, c)
+		}
+	}
+	sort.SliceStable(shared, func(i, j int) bool { return len(shared[i].key) > len(shared[j].key) })
+	return shared
+}
+
+// shareable tells whether e may be evaluated into a variable at a
+// point where the names in defined are on the stack.
+func (s *clauseSearch) shareable(e expression, defined map[string]bool) bool {
+	switch e.(type) {
+	case *binaryExpr, *unaryExpr, *callExpr:
+	default:
+		return false
+	}
+	if v, err := constant(e); v != nil || err != nil {
+		return false
+	}
+
+	ok := true
+	walkExpr(e, func(e expression) {
+		if _, isList := e.(listExpr); isList {
+			ok = false
+		}
+	})
+	refs := make(map[string]int)
+	e.countVarRefs(refs)
+	for name := range refs {
+		switch {
+		case s.assigned[name]:
+			ok = false
+		case defined[name], referencedBuiltin(varRef{name: name}) != nil:
+		case name == s.contract.Value.Amount, name == s.contract.Value.Asset:
+			// These are pushed as they are needed.
+		default:
+			if entry := s.env.lookup(name); entry == nil || entry.t != contractType {
+				ok = false
+			}
+		}
+	}
+	return ok
+}
+
+// apply returns stmts with c evaluated into a variable before the
+// first statement to use it, and referred to after.
+func (c subexpression) apply(stmts []statement, env *environ) []statement {
+	define := &defineStatement{
+		variable: &Param{Name: c.key, Type: c.expr.typ(env), span: c.expr.pos()},
+		expr:     c.expr,
+		span:     c.expr.pos(),
+	}
+	result := append([]statement(nil), stmts[:c.at]...)
+	result = append(result, define)
+	for _, stat := range stmts[c.at:] {
+		result = append(result, mapStatement(stat, referTo(c.key)))
+	}
+	return result
+}
+
+// evaluated returns the subexpressions that stat evaluates on every
+// path through it.
+func evaluated(stat statement) map[string]bool {
+	result := make(map[string]bool)
+	for _, e := range statementExprs(stat, false) {
+		walkExpr(e, func(e expression) { result[e.String()] = true })
+	}
+	if stmt, ok := stat.(*ifStatement); ok && len(stmt.body.falseBody) > 0 {
+		inTrue := make(map[string]bool)
+		for _, st := range stmt.body.trueBody {
+			for key := range evaluated(st) {
+				inTrue[key] = true
+			}
+		}
+		for _, st := range stmt.body.falseBody {
+			for key := range evaluated(st) {
+				if inTrue[key] {
+					result[key] = true
+				}
+			}
+		}
+	}
+	return result
+}
+
+// statementExprs returns the expressions that stat evaluates, and
+// with bodies, those that the statements of its bodies do.
+func statementExprs(stat statement, bodies bool) []expression {
+	switch stmt := stat.(type) {
+	case *defineStatement:
+		if stmt.expr != nil {
+			return []expression{stmt.expr}
+		}
+	case *assignStatement:
+		return []expression{stmt.expr}
+	case *verifyStatement:
+		return []expression{stmt.expr}
+	case *lockStatement:
+		return []expression{stmt.lockedAmount, stmt.lockedAsset, stmt.program}
+	case *ifStatement:
+		result := []expression{stmt.condition}
+		if bodies {
+			for _, st := range stmt.body.trueBody {
+				result = append(result, statementExprs(st, true)...)
+			}
+			for _, st := range stmt.body.falseBody {
+				result = append(result, statementExprs(st, true)...)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
+// walkExpr calls f on e and each of its subexpressions, outermost
+// first.
+func walkExpr(e expression, f func(expression)) {
+	f(e)
+	switch e := e.(type) {
+	case *binaryExpr:
+		walkExpr(e.left, f)
+		walkExpr(e.right, f)
+	case *unaryExpr:
+		walkExpr(e.expr, f)
+	case *callExpr:
+		for _, a := range e.args {
+			walkExpr(a, f)
+		}
+	case listExpr:
+		for _, elt := range e.elts {
+			walkExpr(elt, f)
+		}
+	}
+}
+
+// mapStatement returns stat with f applied to each expression it
+// evaluates, outermost first, or stat itself if f changes none. f
+// returns the expression to replace its argument, and whether that
+// is a change; a replaced expression is not searched further.
+func mapStatement(stat statement, f func(expression) (expression, bool)) statement {
+	switch stmt := stat.(type) {
+	case *defineStatement:
+		if stmt.expr == nil {
+			break
+		}
+		if e, ok := mapExpr(stmt.expr, f); ok {
+			return &defineStatement{variable: stmt.variable, expr: e, span: stmt.span}
+		}
+	case *assignStatement:
+		if e, ok := mapExpr(stmt.expr, f); ok {
+			return &assignStatement{variable: stmt.variable, expr: e, span: stmt.span}
+		}
+	case *verifyStatement:
+		if e, ok := mapExpr(stmt.expr, f); ok {
+			return &verifyStatement{expr: e, span: stmt.span}
+		}
+	case *lockStatement:
+		amount, ok1 := mapExpr(stmt.lockedAmount, f)
+		asset, ok2 := mapExpr(stmt.lockedAsset, f)
+		program, ok3 := mapExpr(stmt.program, f)
+		if ok1 || ok2 || ok3 {
+			return &lockStatement{lockedAmount: amount, lockedAsset: asset, program: program, index: stmt.index, span: stmt.span}
+		}
+	case *ifStatement:
+		cond, changed := mapExpr(stmt.condition, f)
+		body := &IfStatmentBody{}
+		for _, st := range stmt.body.trueBody {
+			st2 := mapStatement(st, f)
+			changed = changed || st2 != st
+			body.trueBody = append(body.trueBody, st2)
+		}
+		for _, st := range stmt.body.falseBody {
+			st2 := mapStatement(st, f)
+			changed = changed || st2 != st
+			body.falseBody = append(body.falseBody, st2)
+		}
+		if changed {
+			return &ifStatement{condition: cond, body: body, span: stmt.span}
+		}
+	}
+	return stat
+}
+
+// mapExpr returns e with f applied to it and its subexpressions as
+// in mapStatement, and whether f changed any.
+func mapExpr(e expression, f func(expression) (expression, bool)) (expression, bool) {
+	if e2, ok := f(e); ok {
+		return e2, true
+	}
+	switch e := e.(type) {
+	case *binaryExpr:
+		left, ok1 := mapExpr(e.left, f)
+		right, ok2 := mapExpr(e.right, f)
+		if ok1 || ok2 {
+			return &binaryExpr{left: left, right: right, op: e.op, swapped: e.swapped, span: e.span}, true
+		}
+	case *unaryExpr:
+		if operand, ok := mapExpr(e.expr, f); ok {
+			return &unaryExpr{op: e.op, expr: operand, span: e.span}, true
+		}
+	case *callExpr:
+		var (
+			args    []expression
+			changed bool
+		)
+		for _, a := range e.args {
+			a2, ok := mapExpr(a, f)
+			changed = changed || ok
+			args = append(args, a2)
+		}
+		if changed {
+			return &callExpr{fn: e.fn, args: args, span: e.span}, true
+		}
+	case listExpr:
+		var (
+			elts    []expression
+			changed bool
+		)
+		for _, elt := range e.elts {
+			elt2, ok := mapExpr(elt, f)
+			changed = changed || ok
+			elts = append(elts, elt2)
+		}
+		if changed {
+			return listExpr{elts: elts, span: e.span}, true
+		}
+	}
+	return e, false
+}
+
+// referTo returns a function for mapStatement that replaces each
+// expression reading as key with a reference to the variable key.
+func referTo(key string) func(expression) (expression, bool) {
+	return func(e expression) (expression, bool) {
+		if _, ok := e.(varRef); !ok && e.String() == key {
+			return varRef{name: key, span: e.pos()}, true
+		}
+		return e, false
+	}
+}
+
+// clauseReports is what compiling a clause reports of it.
+type clauseReports struct {
+	blockHeight []string
+	hashCalls   []HashCall
+	values      []ValueInfo
+	condValues  []CondValueInfo
+	contracts   []string
+}
+
+func saveReports(clause *Clause) clauseReports {
+	return clauseReports{
+		blockHeight: clause.BlockHeight,
+		hashCalls:   clause.HashCalls,
+		values:      clause.Values,
+		condValues:  clause.CondValues,
+		contracts:   clause.Contracts,
+	}
+}
+
+func (r clauseReports) restore(clause *Clause) {
+	clause.BlockHeight = r.blockHeight
+	clause.HashCalls = r.hashCalls
+	clause.Values = r.values
+	clause.CondValues = r.condValues
+	clause.Contracts = r.contracts
+}
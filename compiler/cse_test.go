@@ -0,0 +1,50 @@
+package compiler
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const branches = `
+contract Inner(n: Integer) locks value of asset {
+  clause spend() {
+    verify n > 0
+    unlock value of asset
+  }
+}
+
+contract Outer(k: Integer, m: Integer) locks value of asset {
+  clause spend() {
+    if k > m {
+      lock value of asset with Inner(k)
+    } else {
+      lock value of asset with Inner(k)
+    }
+  }
+}
+`
+
+func TestShareSubexpressions(t *testing.T) {
+	contracts, err := Compile(strings.NewReader(branches))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inner, outer := contracts[0], contracts[1]
+
+	// Both branches lock with the same program, computed once before
+	// the if statement.
+	if n := bytes.Count(outer.Body, inner.Body); n != 1 {
+		t.Errorf("got %d copies of the inner contract in %s", n, outer.Opcodes)
+	}
+
+	// What the clause reports is as if nothing were shared.
+	clause := outer.Clauses[0]
+	if want := []string{"Inner", "Inner"}; !reflect.DeepEqual(clause.Contracts, want) {
+		t.Errorf("got contracts %v, want %v", clause.Contracts, want)
+	}
+	if len(clause.CondValues) != 1 || len(clause.CondValues[0].TrueBodyValues) != 1 || clause.CondValues[0].TrueBodyValues[0].Program != "Inner(k)" {
+		t.Errorf("got values %+v, want a lock with Inner(k) in each branch", clause.CondValues)
+	}
+}
@@ -0,0 +1,228 @@
+package compiler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bytom/errors"
+)
+
+// Severity is the seriousness of a Diagnostic.
+type Severity string
+
+const (
+	SeverityError   = Severity("error")
+	SeverityWarning = Severity("warning")
+)
+
+// Diagnostic codes. These are stable across releases, so tools may
+// match on them rather than on the message text.
+const (
+	CodeSyntax        = "E001"
+	CodeImport        = "E002"
+	CodeEmptyContract = "E003"
+	CodeRedeclared    = "E004"
+	CodeUndefined     = "E005"
+	CodeUnused        = "E006"
+	CodeTypeMismatch  = "E007"
+	CodeArgCount      = "E008"
+	CodeSigParam      = "E009"
+	CodeLockCount     = "E010"
+	CodeAssign        = "E011"
+	CodeListContext   = "E012"
+	CodeTest          = "E013"
+	CodeFault         = "E014"
+	CodeInternal      = "E999"
+
+	CodeNearLimit   = "W001"
+	CodeUnreachable = "W002"
+)
+
+// Location is a range of source text.
+//
+// Lines start at 1. Columns are byte offsets into the line, starting
+// at 0, as in parser errors and in JSON; only the file:line:col form
+// of Diagnostic.Error counts them from 1. A Location with a zero Line
+// is unknown.
+type Location struct {
+	// File is the name of the source file, if known.
+	File string `json:"file,omitempty"`
+
+	// Line and Col are the start of the range, Col counting bytes
+	// from 0.
+	Line int `json:"line"`
+	Col  int `json:"col"`
+
+	// EndLine and EndCol are the end (exclusive) of the range.
+	EndLine int `json:"end_line"`
+	EndCol  int `json:"end_col"`
+}
+
+// Contains tells whether the position at line and col lies within
+// the range, or immediately after it.
+func (l Location) Contains(line, col int) bool {
+	if line < l.Line || line > l.EndLine {
+		return false
+	}
+	if line == l.Line && col < l.Col {
+		return false
+	}
+	if line == l.EndLine && col > l.EndCol {
+		return false
+	}
+	return true
+}
+
+// Diagnostic is an error or warning produced while compiling Equity
+// source, located at the offending range of the input.
+type Diagnostic struct {
+	Location
+
+	// Severity is "error" or "warning".
+	Severity Severity `json:"severity"`
+
+	// Code is a stable identifier for the kind of problem.
+	Code string `json:"code"`
+
+	// Message is the human-readable description of the problem.
+	Message string `json:"message"`
+}
+
+// Error formats d as file:line:col, with the column counted from 1 as
+// tools that read that form expect, if its file is known, and as
+// "line N, col N" with the column counted from 0 otherwise.
+func (d *Diagnostic) Error() string {
+	switch {
+	case d.Line == 0:
+		return d.Message
+	case d.File != "":
+		return fmt.Sprintf("%s:%d:%d: %s", d.File, d.Line, d.Col+1, d.Message)
+	}
+	return fmt.Sprintf("line %d, col %d: %s", d.Line, d.Col, d.Message)
+}
+
+// Diagnostics is the list of problems found in a compilation. Compile
+// returns a Diagnostics value as its error.
+type Diagnostics []*Diagnostic
+
+func (ds Diagnostics) Error() string {
+	var strs []string
+	for _, d := range ds {
+		strs = append(strs, d.Error())
+	}
+	return strings.Join(strs, "\n")
+}
+
+// add appends the problems described by err, if any.
+func (ds *Diagnostics) add(err error) {
+	if err != nil {
+		*ds = append(*ds, toDiagnostics(err)...)
+	}
+}
+
+// sort orders ds by position, keeping files in the order in which
+// they first appear.
+func (ds Diagnostics) sort() {
+	files := make(map[string]int)
+	for _, d := range ds {
+		if _, ok := files[d.File]; !ok {
+			files[d.File] = len(files)
+		}
+	}
+	sort.SliceStable(ds, func(i, j int) bool {
+		a, b := ds[i], ds[j]
+		if a.File != b.File {
+			return files[a.File] < files[b.File]
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Col < b.Col
+	})
+}
+
+// err returns ds as an error, or nil if it is empty.
+func (ds Diagnostics) err() error {
+	if len(ds) == 0 {
+		return nil
+	}
+	return ds
+}
+
+// source is a named buffer of Equity source text.
+type source struct {
+	name string
+	buf  []byte
+}
+
+// position converts a byte offset in the source to a line and column.
+func (s *source) position(offset int) (line, col int) {
+	line = 1
+	for i := 0; i < offset && i < len(s.buf); i++ {
+		if s.buf[i] == '\n' {
+			line++
+			col = 0
+		} else {
+			col++
+		}
+	}
+	return line, col
+}
+
+// span is the range of source text from which a syntax tree node was
+// parsed.
+type span struct {
+	src        *source
+	start, end int
+}
+
+func (s span) pos() span {
+	return s
+}
+
+func (s span) location() Location {
+	var l Location
+	if s.src != nil {
+		l.File = s.src.name
+		l.Line, l.Col = s.src.position(s.start)
+		l.EndLine, l.EndCol = s.src.position(s.end)
+	}
+	return l
+}
+
+func newDiagnostic(sp span, code string, msg string) *Diagnostic {
+	return &Diagnostic{Location: sp.location(), Severity: SeverityError, Code: code, Message: msg}
+}
+
+// errorf returns a *Diagnostic error located at sp.
+func errorf(sp span, code string, format string, args ...interface{}) error {
+	return newDiagnostic(sp, code, fmt.Sprintf(format, args...))
+}
+
+// warningf returns a warning located at sp.
+func warningf(sp span, code string, format string, args ...interface{}) *Diagnostic {
+	d := newDiagnostic(sp, code, fmt.Sprintf(format, args...))
+	d.Severity = SeverityWarning
+	return d
+}
+
+// errorAt locates err at sp, unless it already carries a location.
+func errorAt(sp span, code string, err error) error {
+	if _, ok := errors.Root(err).(*Diagnostic); ok {
+		return err
+	}
+	return newDiagnostic(sp, code, err.Error())
+}
+
+// toDiagnostics converts an error from the parser or the compiler
+// into Diagnostics.
+func toDiagnostics(err error) Diagnostics {
+	switch e := errors.Root(err).(type) {
+	case Diagnostics:
+		return e
+	case *Diagnostic:
+		return Diagnostics{e}
+	}
+	return Diagnostics{{Severity: SeverityError, Code: CodeInternal, Message: err.Error()}}
+}
@@ -0,0 +1,263 @@
+package compiler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bytom/protocol/vm"
+)
+
+// Dispatch is how the body of a contract with more than one clause
+// selects the clause to run from the clause selector, the last
+// argument of a spend.
+//
+// The virtual machine takes the target of a jump from the program,
+// not from the stack, so a body cannot jump to an offset computed
+// from the selector.
+type Dispatch string
+
+const (
+	// DispatchChain compares the selector with the index of each
+	// clause from the last down to the third in turn, then runs the
+	// second clause for any other true selector and the first for a
+	// false one. It is the zero value, as contracts compiled before
+	// there was a choice have it.
+	DispatchChain Dispatch = ""
+
+	// DispatchSearch finds the clause by a binary search on the
+	// selector, which must be an integer. A selector below zero runs
+	// the first clause, and one past the last clause the last.
+	DispatchSearch Dispatch = "search"
+)
+
+// dispatches are the ways a body may select its clause, in order of
+// preference when they are as good as each other.
+var dispatches = []Dispatch{DispatchChain, DispatchSearch}
+
+// chooseDispatch returns the dispatch for a contract with n clauses:
+// the one that uses the least gas to select the clause of any spend,
+// or at OptSize, the smallest.
+func chooseDispatch(n int, opts Options) Dispatch {
+	if !opts.enabled(PassDispatch) {
+		return DispatchChain
+	}
+
+	var (
+		best     Dispatch
+		bestSize = -1
+		bestGas  int64
+	)
+	for _, d := range dispatches {
+		size, gas := measureDispatch(n, d)
+		if bestSize >= 0 {
+			if opts.Optimize == OptSize && (size > bestSize || size == bestSize && gas >= bestGas) {
+				continue
+			}
+			if opts.Optimize != OptSize && (gas > bestGas || gas == bestGas && size >= bestSize) {
+				continue
+			}
+		}
+		best, bestSize, bestGas = d, size, gas
+	}
+	return best
+}
+
+// compileDispatch compiles the code with which a body selects one of
+// the clauses with the given names by d, starting with the clause
+// selector on top of stk. The clauses follow it in order, each at a
+// label with its name. It returns the stack with which each clause
+// begins, with the selector still on top for those that must drop it.
+func compileDispatch(b *builder, stk stack, names []string, d Dispatch) []stack {
+	starts := make([]stack, len(names))
+	if d == DispatchSearch {
+		compileSearch(b, stk, names, 0, len(names), names[0])
+		for i := range starts {
+			starts[i] = stk.drop()
+		}
+		return starts
+	}
+
+	var stk2 stack
+
+	// clauses 2..N-1
+	for i := len(names) - 1; i >= 2; i-- {
+		stk = b.addDup(stk)                                                   // stack: [... <clause selector> <clause selector>]
+		stk = b.addInt64(stk, int64(i))                                       // stack: [... <clause selector> <clause selector> <i>]
+		stk = b.addNumEqual(stk, fmt.Sprintf("(<clause selector> == %d)", i)) // stack: [... <clause selector> <i == clause selector>]
+		stk = b.addJumpIf(stk, names[i])                                      // stack: [... <clause selector>]
+		stk2 = stk                                                            // stack starts here for clauses 2 through N-1
+	}
+
+	// clause 1
+	stk = b.addJumpIf(stk, names[1]) // consumes the clause selector
+
+	// no jump needed for clause 0
+
+	for i := range starts {
+		starts[i] = stk
+		if i > 1 {
+			// Clauses 0 and 1 have no clause selector on top of the
+			// stack. Clauses 2 and later do.
+			starts[i] = stk2
+		}
+	}
+	return starts
+}
+
+// compileSearch compiles the part of a binary search that selects one
+// of the clauses from lo up to hi, consuming the selector on top of
+// stk. next is the label of the code that follows.
+func compileSearch(b *builder, stk stack, names []string, lo, hi int, next string) {
+	switch hi - lo {
+	case 1:
+		stk = b.addDrop(stk)
+	case 2:
+		stk = b.addInt64(stk, int64(lo+1))
+		stk = b.addOps(stk.dropN(2), "GREATERTHANOREQUAL", fmt.Sprintf("(<clause selector> >= %d)", lo+1))
+		stk = b.addJumpIf(stk, names[lo+1])
+	default:
+		mid := (lo + hi) / 2
+		left := fmt.Sprintf("_clauses_%d_%d", lo, mid)
+		stk = b.addDup(stk)
+		stk = b.addInt64(stk, int64(mid))
+		stk = b.addOps(stk.dropN(2), "LESSTHAN", fmt.Sprintf("(<clause selector> < %d)", mid))
+		stk = b.addJumpIf(stk, left)
+		compileSearch(b, stk, names, mid, hi, left)
+		b.addJumpTarget(stk, left)
+		compileSearch(b, stk, names, lo, mid, next)
+		return
+	}
+	if next != names[lo] {
+		b.addJump(stk, names[lo])
+	}
+}
+
+// measureDispatch returns the size of the code with which a body
+// selects one of n clauses by d, and the most gas it uses to select
+// one.
+func measureDispatch(n int, d Dispatch) (int, int64) {
+	b := &builder{}
+	var names []string
+	for i := 0; i < n; i++ {
+		names = append(names, fmt.Sprintf("clause%d", i))
+	}
+	starts := compileDispatch(b, stack{}.add("<clause selector>"), names, d)
+	var drops int
+	for i, name := range names {
+		b.addJumpTarget(starts[i], name)
+		if !starts[i].isEmpty() {
+			drops++
+		}
+	}
+
+	var ops []string
+	for _, x := range b.instructions() {
+		ops = append(ops, x.op)
+	}
+	prog, err := vm.Assemble(strings.Join(ops, " "))
+	if err != nil {
+		return -1, 0
+	}
+
+	var most int64
+	for i := range names {
+		clause, gas := runDispatch(ops, names, int64(i))
+		if clause != i {
+			return -1, 0
+		}
+		if !starts[i].isEmpty() {
+			gas++ // DROP
+		}
+		if gas > most {
+			most = gas
+		}
+	}
+	return len(prog) + drops, most
+}
+
+// runDispatch runs the code ops, as compiled by compileDispatch for
+// the clauses with the given names and followed by their labels, for
+// selector. It returns the index of the clause it selects, or -1, and
+// the gas it uses, as the virtual machine charges it.
+func runDispatch(ops, names []string, selector int64) (int, int64) {
+	var (
+		stk = []int64{selector}
+		gas int64
+	)
+	pop := func() int64 {
+		x := stk[len(stk)-1]
+		stk = stk[:len(stk)-1]
+		return x
+	}
+	jump := func(label string) int {
+		for i, op := range ops {
+			if op == "$"+label {
+				return i
+			}
+		}
+		return len(ops)
+	}
+	boolInt := func(b bool) int64 {
+		if b {
+			return 1
+		}
+		return 0
+	}
+	for pc := 0; pc < len(ops); pc++ {
+		op := ops[pc]
+		switch {
+		case strings.HasPrefix(op, "$"):
+			for i, name := range names {
+				if op[1:] == name {
+					return i, gas
+				}
+			}
+		case strings.HasPrefix(op, "JUMPIF:$"):
+			gas++
+			if pop() != 0 {
+				pc = jump(op[len("JUMPIF:$"):]) - 1
+			}
+		case strings.HasPrefix(op, "JUMP:$"):
+			gas++
+			pc = jump(op[len("JUMP:$"):]) - 1
+		case op == "DUP":
+			gas++
+			stk = append(stk, stk[len(stk)-1])
+		case op == "DROP":
+			gas++
+			pop()
+		case op == "NUMEQUAL", op == "LESSTHAN", op == "GREATERTHANOREQUAL":
+			gas += 2
+			y, x := pop(), pop()
+			switch op {
+			case "NUMEQUAL":
+				stk = append(stk, boolInt(x == y))
+			case "LESSTHAN":
+				stk = append(stk, boolInt(x < y))
+			default:
+				stk = append(stk, boolInt(x >= y))
+			}
+		default:
+			n, err := strconv.ParseInt(op, 10, 64)
+			if err != nil {
+				return -1, 0
+			}
+			gas++
+			stk = append(stk, n)
+		}
+	}
+	return -1, 0
+}
+
+// searchClause returns the index of the clause of n that the binary
+// search of DispatchSearch selects for selector.
+func searchClause(n int, selector int64) int {
+	switch {
+	case selector < 0:
+		return 0
+	case selector >= int64(n):
+		return n - 1
+	}
+	return int(selector)
+}
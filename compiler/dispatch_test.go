@@ -0,0 +1,92 @@
+package compiler
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDispatch(t *testing.T) {
+	for _, d := range dispatches {
+		for n := 2; n <= 20; n++ {
+			if size, _ := measureDispatch(n, d); size < 0 {
+				t.Errorf("dispatch %q of %d clauses does not select every clause", d, n)
+			}
+		}
+	}
+
+	cases := []struct {
+		n    int
+		opts Options
+		want Dispatch
+	}{
+		{2, Options{}, DispatchChain},
+		{3, Options{}, DispatchChain},
+		{8, Options{}, DispatchSearch},
+		{8, Options{Optimize: OptSize}, DispatchChain},
+		{8, Options{Optimize: OptNone}, DispatchChain},
+		{8, Options{Passes: []string{PassFold}}, DispatchChain},
+	}
+	for _, c := range cases {
+		if got := chooseDispatch(c.n, c.opts); got != c.want {
+			t.Errorf("%d clauses, %+v: got %q, want %q", c.n, c.opts, got, c.want)
+		}
+	}
+}
+
+func TestSearchClause(t *testing.T) {
+	cases := []struct {
+		selector int64
+		want     int
+	}{
+		{-1, 0},
+		{0, 0},
+		{3, 3},
+		{4, 4},
+		{5, 4},
+		{1 << 40, 4},
+	}
+	for _, c := range cases {
+		if got := searchClause(5, c.selector); got != c.want {
+			t.Errorf("searchClause(5, %d) = %d, want %d", c.selector, got, c.want)
+		}
+	}
+}
+
+func TestDecodeSearchWitness(t *testing.T) {
+	contracts, err := Compile(strings.NewReader(manyClauses(6)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	contract := contracts[0]
+	if contract.Dispatch != DispatchSearch {
+		t.Fatalf("got dispatch %q, want %q", contract.Dispatch, DispatchSearch)
+	}
+	for i, clause := range contract.Clauses {
+		n := int64(i)
+		witness, err := WitnessFor(contract, clause.Name, []ContractArg{{I: &n}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		spend, err := DecodeWitness(contract, nil, witness)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if spend.Clause != clause {
+			t.Errorf("got clause %s, want %s", spend.Clause.Name, clause.Name)
+		}
+	}
+	if _, err := DecodeWitness(contract, nil, [][]byte{{1}, make([]byte, 9)}); err == nil {
+		t.Error("got no error for a selector that is not an integer")
+	}
+}
+
+// manyClauses returns a contract with n clauses, the ith of which
+// takes i.
+func manyClauses(n int) string {
+	var clauses []string
+	for i := 0; i < n; i++ {
+		clauses = append(clauses, fmt.Sprintf("  clause c%d(x: Integer) {\n    verify x == %d\n    unlock value of asset\n  }\n", i, i))
+	}
+	return "contract Many() locks value of asset {\n" + strings.Join(clauses, "") + "}\n"
+}
@@ -7,7 +7,10 @@ import (
 	"path/filepath"
 	"testing"
 
+	"github.com/bytom/errors"
+
 	"github.com/equity/compiler"
+	"github.com/equity/simulate"
 )
 
 func TestCompileContract(t *testing.T) {
@@ -29,11 +32,11 @@ func TestCompileContract(t *testing.T) {
 		},
 		{
 			"./FixedLimitCollect",
-			"597a642f0200005479cda069c35b797ca153795579a19a695a790400e1f5059653790400e1f505967c00a07c00a09a69c35b797c9f9161644d010000005b79c2547951005e79895d79895c79895b7989597989587989537a894caa587a649e0000005479cd9f6959790400e1f5059653790400e1f505967800a07800a09a5c7956799f9a6955797b957c96c37800a052797ba19a69c3787c9f91616487000000005b795479515b79c1695178c2515d79c16952c3527994c251005d79895c79895b79895a79895979895879895779895679890274787e008901c07ec1696399000000005b795479515b79c16951c3c2515d79c16963aa000000557acd9f69577a577aae7cac890274787e008901c07ec169515b79c2515d79c16952c35c7994c251005d79895c79895b79895a79895979895879895779895679895579890274787e008901c07ec169632a020000005b79c2547951005e79895d79895c79895b7989597989587989537a894caa587a649e0000005479cd9f6959790400e1f5059653790400e1f505967800a07800a09a5c7956799f9a6955797b957c96c37800a052797ba19a69c3787c9f91616487000000005b795479515b79c1695178c2515d79c16952c3527994c251005d79895c79895b79895a79895979895879895779895679890274787e008901c07ec1696399000000005b795479515b79c16951c3c2515d79c16963aa000000557acd9f69577a577aae7cac890274787e008901c07ec16951c3c2515d79c169633b020000547acd9f69587a587aae7cac",
+			"597a64630100005479cda069c35b797ca153795579a19a695a790400e1f5059653790400e1f505967c00a07c00a09a69c2005a79895979895879895779895579895479897c894ca9587a649d0000005479cd9f6959790400e1f5059653790400e1f505967800a07800a09a5c7956799f9a6955797b957c96c37800a052797ba19a69c3787c9f91616486000000005b795479515b79c1695178c2515d79c16952c3527994c251005d79895c79895b79895a79895979895879895779895679890274787e008901c07ec16397000000005b795479515b79c16951c3c2515d79c16963a9000000557acd9f69577a577aae7cac890274787e008901c07ec35c797c9f9161644c010000005c795479515479c169515c79c2515e79c16952c35d7994c251005e79895d79895c79895b79895a79895979895879895779895679890274787e008901c07ec1635d010000005c795479515479c16951c3c2515e79c169636f010000547acd9f69587a587aae7cac",
 		},
 		{
 			"./FixedLimitProfit",
-			"587a649e0000005479cd9f6959790400e1f5059653790400e1f505967800a07800a09a5c7956799f9a6955797b957c96c37800a052797ba19a69c3787c9f91616487000000005b795479515b79c1695178c2515d79c16952c3527994c251005d79895c79895b79895a79895979895879895779895679890274787e008901c07ec1696399000000005b795479515b79c16951c3c2515d79c16963aa000000557acd9f69577a577aae7cac",
+			"587a649d0000005479cd9f6959790400e1f5059653790400e1f505967800a07800a09a5c7956799f9a6955797b957c96c37800a052797ba19a69c3787c9f91616486000000005b795479515b79c1695178c2515d79c16952c3527994c251005d79895c79895b79895a79895979895879895779895679890274787e008901c07ec16397000000005b795479515b79c16951c3c2515d79c16963a9000000557acd9f69577a577aae7cac",
 		},
 	}
 
@@ -69,3 +72,38 @@ func TestCompileContract(t *testing.T) {
 		})
 	}
 }
+
+// TestEquityTests runs the test blocks in the *_test.equity files.
+func TestEquityTests(t *testing.T) {
+	files, err := filepath.Glob("*_test.equity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no test files")
+	}
+
+	for _, file := range files {
+		inputFile, err := os.Open(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contracts, tests, err := compiler.CompileTests(inputFile)
+		inputFile.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+
+		for _, test := range tests {
+			t.Run(file+"/"+test.Name, func(t *testing.T) {
+				res := simulate.RunTest(contracts, test)
+				if res.Err != nil {
+					t.Fatal(res.Err)
+				}
+				if !res.Pass {
+					t.Errorf("clause passed: %v, want %v (error %v)", res.Run.Pass, test.ExpectPass, errors.Root(res.Run.Err))
+				}
+			})
+		}
+	}
+}
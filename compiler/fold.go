@@ -0,0 +1,277 @@
+package compiler
+
+import (
+	"bytes"
+	"crypto/sha256"
+
+	"golang.org/x/crypto/sha3"
+
+	"github.com/bytom/math/checked"
+	"github.com/bytom/protocol/vm"
+)
+
+// constant evaluates expr at compile time as the VM would evaluate it
+// at run time, and returns its value as a literal. It returns nil if
+// the value depends on anything but literals, and an error if
+// evaluating it is certain to fail.
+//
+// Only operands of the types their operators expect are evaluated,
+// leaving others to be reported by the type checks.
+func constant(expr expression) (expression, error) {
+	switch e := expr.(type) {
+	case integerLiteral, bytesLiteral, booleanLiteral:
+		return e, nil
+
+	case *binaryExpr:
+		left, err := constant(e.left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := constant(e.right)
+		if err != nil {
+			return nil, err
+		}
+		if y, ok := right.(integerLiteral); ok {
+			// These fail whatever the left operand is.
+			switch {
+			case y.value == 0 && (e.op.op == "/" || e.op.op == "%"):
+				return nil, errorf(e.span, CodeFault, "division by zero in \"%s\"", e)
+			case y.value < 0 && (e.op.op == "<<" || e.op.op == ">>"):
+				return nil, errorf(e.span, CodeFault, "negative shift count in \"%s\"", e)
+			}
+		}
+		if left == nil || right == nil {
+			return nil, nil
+		}
+		return foldBinary(e, left, right)
+
+	case *unaryExpr:
+		operand, err := constant(e.expr)
+		if err != nil || operand == nil {
+			return nil, err
+		}
+		return foldUnary(e, operand)
+
+	case *callExpr:
+		bi := referencedBuiltin(e.fn)
+		if bi == nil || len(e.args) != len(bi.args) {
+			return nil, nil
+		}
+		var args []expression
+		for _, a := range e.args {
+			arg, err := constant(a)
+			if err != nil || arg == nil {
+				return nil, err
+			}
+			args = append(args, arg)
+		}
+		return foldCall(e, bi, args)
+	}
+	return nil, nil
+}
+
+func foldBinary(e *binaryExpr, left, right expression) (expression, error) {
+	switch e.op.op {
+	case "||", "&&":
+		x, ok1 := left.(booleanLiteral)
+		y, ok2 := right.(booleanLiteral)
+		if !ok1 || !ok2 {
+			return nil, nil
+		}
+		if e.op.op == "||" {
+			return booleanLiteral{value: x.value || y.value, span: e.span}, nil
+		}
+		return booleanLiteral{value: x.value && y.value, span: e.span}, nil
+
+	case "==", "!=":
+		var equal bool
+		switch x := left.(type) {
+		case integerLiteral:
+			y, ok := right.(integerLiteral)
+			if !ok {
+				return nil, nil
+			}
+			equal = x.value == y.value
+		case bytesLiteral:
+			y, ok := right.(bytesLiteral)
+			if !ok {
+				return nil, nil
+			}
+			equal = bytes.Equal(x.value, y.value)
+		default:
+			return nil, nil
+		}
+		return booleanLiteral{value: equal == (e.op.op == "=="), span: e.span}, nil
+
+	case "^", "|", "&":
+		x, y := literalBytes(left), literalBytes(right)
+		if len(x) < len(y) {
+			x, y = y, x
+		}
+		var res []byte
+		switch e.op.op {
+		case "&":
+			for i := range y {
+				res = append(res, x[i]&y[i])
+			}
+		case "|":
+			res = append(res, x...)
+			for i := range y {
+				res[i] |= y[i]
+			}
+		case "^":
+			res = append(res, x...)
+			for i := range y {
+				res[i] ^= y[i]
+			}
+		}
+		return bytesLiteral{value: res, span: e.span}, nil
+	}
+
+	x, ok1 := left.(integerLiteral)
+	y, ok2 := right.(integerLiteral)
+	if !ok1 || !ok2 {
+		return nil, nil
+	}
+
+	var (
+		n  int64
+		ok = true
+	)
+	switch e.op.op {
+	case ">":
+		return booleanLiteral{value: x.value > y.value, span: e.span}, nil
+	case "<":
+		return booleanLiteral{value: x.value < y.value, span: e.span}, nil
+	case ">=":
+		return booleanLiteral{value: x.value >= y.value, span: e.span}, nil
+	case "<=":
+		return booleanLiteral{value: x.value <= y.value, span: e.span}, nil
+	case "+":
+		n, ok = checked.AddInt64(x.value, y.value)
+	case "-":
+		n, ok = checked.SubInt64(x.value, y.value)
+	case "*":
+		n, ok = checked.MulInt64(x.value, y.value)
+	case "/", "%":
+		if e.op.op == "/" {
+			n, ok = checked.DivInt64(x.value, y.value)
+			break
+		}
+		n, ok = checked.ModInt64(x.value, y.value)
+
+		// The VM's modulus takes the sign of the divisor.
+		if n != 0 && (x.value >= 0) != (y.value >= 0) {
+			n += y.value
+		}
+	case "<<", ">>":
+		if e.op.op == ">>" {
+			n = x.value >> uint64(y.value)
+			break
+		}
+		n = x.value
+		if x.value != 0 && y.value != 0 {
+			n, ok = checked.LshiftInt64(x.value, y.value)
+		}
+	default:
+		return nil, nil
+	}
+	if !ok {
+		return nil, errorf(e.span, CodeFault, "integer overflow in \"%s\"", e)
+	}
+	return integerLiteral{value: n, span: e.span}, nil
+}
+
+func foldUnary(e *unaryExpr, operand expression) (expression, error) {
+	switch e.op.op {
+	case "-":
+		x, ok := operand.(integerLiteral)
+		if !ok {
+			return nil, nil
+		}
+		n, ok := checked.NegateInt64(x.value)
+		if !ok {
+			return nil, errorf(e.span, CodeFault, "integer overflow in \"%s\"", e)
+		}
+		return integerLiteral{value: n, span: e.span}, nil
+
+	case "!":
+		x, ok := operand.(booleanLiteral)
+		if !ok {
+			return nil, nil
+		}
+		return booleanLiteral{value: !x.value, span: e.span}, nil
+
+	case "~":
+		var res []byte
+		for _, c := range literalBytes(operand) {
+			res = append(res, ^c)
+		}
+		return bytesLiteral{value: res, span: e.span}, nil
+	}
+	return nil, nil
+}
+
+func foldCall(e *callExpr, bi *builtin, args []expression) (expression, error) {
+	switch bi.name {
+	case "sha3":
+		h := sha3.Sum256(literalBytes(args[0]))
+		return bytesLiteral{value: h[:], span: e.span}, nil
+
+	case "sha256":
+		h := sha256.Sum256(literalBytes(args[0]))
+		return bytesLiteral{value: h[:], span: e.span}, nil
+
+	case "size":
+		return integerLiteral{value: int64(len(literalBytes(args[0]))), span: e.span}, nil
+
+	case "concat", "concatpush":
+		x, y := literalBytes(args[0]), literalBytes(args[1])
+		if bi.name == "concatpush" {
+			y = vm.PushdataBytes(y)
+		}
+		return bytesLiteral{value: append(append([]byte{}, x...), y...), span: e.span}, nil
+	}
+
+	var ints []int64
+	for _, a := range args {
+		x, ok := a.(integerLiteral)
+		if !ok {
+			return nil, nil
+		}
+		ints = append(ints, x.value)
+	}
+	switch bi.name {
+	case "abs":
+		n, ok := checked.NegateInt64(ints[0])
+		if !ok {
+			return nil, errorf(e.span, CodeFault, "integer overflow in \"%s\"", e)
+		}
+		if ints[0] > 0 {
+			n = ints[0]
+		}
+		return integerLiteral{value: n, span: e.span}, nil
+
+	case "min", "max":
+		n := ints[0]
+		if (bi.name == "min") == (ints[1] < n) {
+			n = ints[1]
+		}
+		return integerLiteral{value: n, span: e.span}, nil
+	}
+	return nil, nil
+}
+
+// literalBytes returns the value of a literal as the VM holds it on
+// the stack.
+func literalBytes(lit expression) []byte {
+	switch l := lit.(type) {
+	case integerLiteral:
+		return vm.Int64Bytes(l.value)
+	case booleanLiteral:
+		return vm.BoolBytes(l.value)
+	case bytesLiteral:
+		return l.value
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package compiler
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConstant(t *testing.T) {
+	cases := []struct {
+		expr, want string
+	}{
+		{"1 + 2 * 3", "7"},
+		{"-7 / 2", "-3"},
+		{"-7 % 2", "1"},
+		{"7 % -2", "-1"},
+		{"1 << 8", "256"},
+		{"-256 >> 4", "-16"},
+		{"min(3, 5) + max(3, 5)", "8"},
+		{"abs(-4)", "4"},
+		{"3 > 2 && !(1 == 2)", "true"},
+		{"false || 'a' != 'b'", "true"},
+		{"0x0f | 0xf000", "0xff00"},
+		{"0x0f & 0xf000", "0x00"},
+		{"~0x00ff", "0xff00"},
+		{"size(concat('ab', 'cd'))", "4"},
+		{"concatpush('a', 'b')", "0x610162"},
+		{"sha3('string')", "0x22e829107201c6b975b1dc60b928117916285ceb4aa5c6d7b4b8cc48038083e0"},
+		{"sha256('')", "0xe3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+
+		// Not constant.
+		{"x + 1", ""},
+		{"sha3(x)", ""},
+		{"above(1)", ""},
+
+		// Left to the type checks.
+		{"1 == 'a'", ""},
+		{"true + 1", ""},
+	}
+	for _, c := range cases {
+		got, err := constant(parseTestExpr(t, c.expr))
+		if err != nil {
+			t.Errorf("%s: %s", c.expr, err)
+			continue
+		}
+		var gotStr string
+		if got != nil {
+			gotStr = got.String()
+		}
+		if gotStr != c.want {
+			t.Errorf("%s: got %q, want %q", c.expr, gotStr, c.want)
+		}
+	}
+}
+
+func TestConstantFaults(t *testing.T) {
+	cases := []struct {
+		expr, want string
+	}{
+		{"1 / 0", "division by zero"},
+		{"x % (2 - 2)", "division by zero"},
+		{"x << -1", "negative shift count"},
+		{"9223372036854775807 + 1", "integer overflow"},
+		{"-(-9223372036854775807 - 1)", "integer overflow"},
+		{"1 << 63", "integer overflow"},
+	}
+	for _, c := range cases {
+		_, err := constant(parseTestExpr(t, c.expr))
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: got error %v, want %q", c.expr, err, c.want)
+		}
+		if d, ok := err.(*Diagnostic); !ok || d.Code != CodeFault {
+			t.Errorf("%s: got %#v, want a diagnostic with code %s", c.expr, err, CodeFault)
+		}
+	}
+}
+
+func TestCompileConstant(t *testing.T) {
+	const folded = `
+contract Folded(x: Integer) locks value of asset {
+  clause spend() {
+    verify x == (1 << 8) - 6 / 2
+    unlock value of asset
+  }
+}
+`
+	const literal = `
+contract Folded(x: Integer) locks value of asset {
+  clause spend() {
+    verify x == 253
+    unlock value of asset
+  }
+}
+`
+	got, err := Compile(strings.NewReader(folded))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := Compile(strings.NewReader(literal))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[0].Opcodes != want[0].Opcodes {
+		t.Errorf("got %s, want %s", got[0].Opcodes, want[0].Opcodes)
+	}
+}
+
+func TestCompileConstantSize(t *testing.T) {
+	long := "0x" + strings.Repeat("ab", 40)
+	cases := []struct {
+		params, cond, def, size string
+	}{
+		// At OptSize, the hash is not folded where it is larger than
+		// the literal and SHA3,
+		{"h: Hash", "h == sha3('string')", "0x" + hashOf(t, "sha3('string')"), "0x737472696e67 SHA3"},
+
+		// but is where the literal is longer.
+		{"h: Hash", "h == sha3(" + long + ")", "0x" + hashOf(t, "sha3("+long+")"), "0x" + hashOf(t, "sha3("+long+")")},
+
+		{"x: Integer", "x == 1 << 62", "4611686018427387904", "1 62 LSHIFT"},
+		{"x: Integer", "x == 1 << 8", "256", "256"},
+	}
+	for _, c := range cases {
+		src := "contract C(" + c.params + ") locks value of asset {\n  clause c() {\n    verify " + c.cond + "\n    unlock value of asset\n  }\n}\n"
+		for _, level := range []OptLevel{OptDefault, OptSize} {
+			want := c.def
+			if level == OptSize {
+				want = c.size
+			}
+			contracts, err := CompileWithOptions(strings.NewReader(src), Options{Optimize: level})
+			if err != nil {
+				t.Fatalf("%s: %s", c.cond, err)
+			}
+			if got := contracts[0].Opcodes; !strings.Contains(got, want) {
+				t.Errorf("%s at level %d: got %s, want it to push %s", c.cond, level, got, want)
+			}
+		}
+	}
+}
+
+func hashOf(t *testing.T, expr string) string {
+	value, err := constant(parseTestExpr(t, expr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimPrefix(value.String(), "0x")
+}
+
+func parseTestExpr(t *testing.T, expr string) expression {
+	src := "contract C(x: Integer) locks value of asset {\n  clause c() {\n    verify " + expr + "\n    unlock value of asset\n  }\n}\n"
+	contracts, _, err := parse([]byte(src), "", nil)
+	if err != nil {
+		t.Fatalf("parsing %s: %s", expr, err)
+	}
+	return contracts[0].Clauses[0].statements[0].(*verifyStatement).expr
+}
@@ -0,0 +1,632 @@
+package compiler
+
+import (
+	"bytes"
+	"strings"
+)
+
+// indentWidth is the number of spaces per level of indentation.
+const indentWidth = 2
+
+// Format returns the canonical formatting of the Equity source in
+// buf. Clauses and statements are reindented, operators are spaced
+// the way gofmt spaces Go's, and comments are kept in place. A
+// contract parameter list written over several lines is printed with
+// one parameter group per line, aligned after the opening
+// parenthesis.
+//
+// Comments inside an expression are moved to the lines before its
+// statement. Source with syntax errors is returned as a Diagnostics
+// error.
+func Format(buf []byte) (result []byte, err error) {
+	toks, err := lex(&source{buf: buf})
+	if err != nil {
+		return nil, Diagnostics{err.(*Diagnostic)}
+	}
+
+	defer func() {
+		if val := recover(); val != nil {
+			d, ok := val.(*Diagnostic)
+			if !ok {
+				panic(val)
+			}
+			result, err = nil, Diagnostics{d}
+		}
+	}()
+
+	f := &formatter{toks: toks}
+	f.file()
+	return f.out.Bytes(), nil
+}
+
+// formatter prints a token stream, recognizing just enough of the
+// grammar to lay it out.
+type formatter struct {
+	toks []*token
+	pos  int
+	last *token // the most recently consumed token
+
+	out        bytes.Buffer
+	blockStart bool // whether the last line written opened a block
+	lastBlank  bool // whether the last line written was empty
+
+	// comments found inside the line being built, to be written
+	// before it
+	hoisted []string
+}
+
+func (f *formatter) peek() *token {
+	return f.toks[f.pos]
+}
+
+func (f *formatter) is(text string) bool {
+	tok := f.peek()
+	return tok.kind != tokEOF && tok.kind != tokString && tok.text == text
+}
+
+// next consumes a token in the middle of a line. Comments around it
+// are hoisted above the line.
+func (f *formatter) next() *token {
+	tok := f.peek()
+	if tok.kind == tokEOF {
+		f.errorf("unexpected end of file")
+	}
+	if f.last != nil && f.last.trailing != "" {
+		f.hoisted = append(f.hoisted, f.last.trailing)
+		f.last.trailing = ""
+	}
+	for _, c := range tok.leading {
+		f.hoisted = append(f.hoisted, c.text)
+	}
+	tok.leading = nil
+	f.pos++
+	f.last = tok
+	return tok
+}
+
+func (f *formatter) expect(text string) *token {
+	if !f.is(text) {
+		f.errorf("expected %s", text)
+	}
+	return f.next()
+}
+
+func (f *formatter) ident() string {
+	if f.peek().kind != tokIdent {
+		f.errorf("expected identifier")
+	}
+	return f.next().text
+}
+
+func (f *formatter) errorf(format string, args ...interface{}) {
+	panic(errorf(f.peek().span, CodeSyntax, format, args...))
+}
+
+// startLine writes the comments before the next token, which begins
+// a line at column col, and any empty line before it.
+func (f *formatter) startLine(col int) {
+	tok := f.peek()
+	f.comments(col, tok)
+	if tok.blank {
+		f.blank()
+	}
+}
+
+// comments writes the comments on lines of their own before tok.
+func (f *formatter) comments(col int, tok *token) {
+	for _, c := range tok.leading {
+		if c.blank {
+			f.blank()
+		}
+		f.writeLine(col, c.text)
+	}
+	tok.leading = nil
+}
+
+// emit writes a line at column col, preceded by any hoisted comments
+// and followed by the trailing comment of its last token.
+func (f *formatter) emit(col int, text string) {
+	for _, c := range f.hoisted {
+		f.writeLine(col, c)
+	}
+	f.hoisted = nil
+	opens := strings.HasSuffix(text, "{")
+	if f.last != nil && f.last.trailing != "" {
+		text += " " + f.last.trailing
+		f.last.trailing = ""
+	}
+	f.writeLine(col, text)
+	f.blockStart = opens
+}
+
+func (f *formatter) writeLine(col int, text string) {
+	f.out.WriteString(strings.Repeat(" ", col))
+	f.out.WriteString(text)
+	f.out.WriteByte('\n')
+	f.blockStart, f.lastBlank = false, false
+}
+
+// blank writes an empty line, except at the start of the file or of
+// a block, or after another empty line.
+func (f *formatter) blank() {
+	if f.out.Len() > 0 && !f.blockStart && !f.lastBlank {
+		f.out.WriteByte('\n')
+		f.lastBlank = true
+	}
+}
+
+func (f *formatter) file() {
+	for f.is("import") {
+		f.startLine(0)
+		f.next()
+		path := f.peek()
+		if path.kind != tokString {
+			f.errorf("expected import path")
+		}
+		f.next()
+		f.emit(0, "import "+path.text)
+	}
+	for f.peek().kind != tokEOF {
+		if f.is("test") {
+			f.test()
+		} else {
+			f.contract()
+		}
+	}
+	f.comments(0, f.peek())
+}
+
+// contract name(p1, p2: t1, p3: t2) locks amount of asset { ... }
+func (f *formatter) contract() {
+	f.startLine(0)
+	f.expect("contract")
+	col, line := f.params(0, "contract "+f.ident())
+	f.expect("locks")
+	amount := f.ident()
+	f.expect("of")
+	asset := f.ident()
+	f.expect("{")
+	f.emit(col, line+" locks "+amount+" of "+asset+" {")
+	for !f.is("}") {
+		f.clause()
+	}
+	f.closeBlock(0)
+}
+
+// clause name(p1, p2: t1, p3: t2) { ... }
+func (f *formatter) clause() {
+	col := indentWidth
+	f.startLine(col)
+	f.expect("clause")
+	col, line := f.params(col, "clause "+f.ident())
+	f.expect("{")
+	f.emit(col, line+" {")
+	f.statements(indentWidth + indentWidth)
+	f.closeBlock(indentWidth)
+}
+
+// test "name" { ... }
+func (f *formatter) test() {
+	f.startLine(0)
+	f.expect("test")
+	name := f.peek()
+	if name.kind != tokString {
+		f.errorf("expected test name")
+	}
+	f.next()
+	f.expect("{")
+	f.emit(0, "test "+name.text+" {")
+	col := indentWidth
+	for !f.is("}") {
+		f.startLine(col)
+		tok := f.peek()
+		switch tok.text {
+		case "contract":
+			f.next()
+			line := "contract " + f.expr()
+			if f.is("locks") {
+				f.next()
+				amount := f.expr()
+				f.expect("of")
+				line += " locks " + amount + " of " + f.expr()
+			}
+			f.emit(col, line)
+
+		case "height", "clause":
+			f.next()
+			f.emit(col, tok.text+" "+f.expr())
+
+		case "output":
+			f.next()
+			amount := f.expr()
+			f.expect("of")
+			asset := f.expr()
+			f.expect("with")
+			f.emit(col, "output "+amount+" of "+asset+" with "+f.expr())
+
+		case "expect":
+			f.next()
+			f.emit(col, "expect "+f.ident())
+
+		default:
+			f.errorf("unknown test statement \"%s\"", tok.text)
+		}
+	}
+	f.closeBlock(0)
+}
+
+// params formats a parameter list following prefix, on a line at
+// column col. If the list is written over several lines, each group
+// of parameters is put on a line of its own, aligned after the
+// opening parenthesis. The column and text of the unfinished last
+// line are returned.
+func (f *formatter) params(col int, prefix string) (int, string) {
+	f.expect("(")
+	multiline := false
+	for i := f.pos; i < len(f.toks) && f.toks[i].kind != tokEOF; i++ {
+		if f.toks[i].newline {
+			multiline = true
+		}
+		if f.toks[i].text == ")" {
+			break
+		}
+	}
+
+	line := prefix + "("
+	alignCol := col + len(line)
+	first := true
+	for !f.is(")") {
+		if !first {
+			f.expect(",")
+			if multiline {
+				f.emit(col, line+",")
+				col, line = alignCol, ""
+				f.startLine(col)
+			} else {
+				line += ", "
+			}
+		}
+		first = false
+
+		// p1, p2: t
+		line += f.ident()
+		for f.is(",") {
+			f.next()
+			line += ", " + f.ident()
+		}
+		f.expect(":")
+		line += ": " + f.ident()
+	}
+	f.expect(")")
+	return col, line + ")"
+}
+
+// statements formats the statements of a block at column col.
+func (f *formatter) statements(col int) {
+	for !f.is("}") {
+		f.startLine(col)
+		tok := f.peek()
+		switch tok.text {
+		case "verify":
+			f.next()
+			f.emit(col, "verify "+f.expr())
+
+		case "lock":
+			f.next()
+			amount := f.expr()
+			f.expect("of")
+			asset := f.expr()
+			f.expect("with")
+			f.emit(col, "lock "+amount+" of "+asset+" with "+f.expr())
+
+		case "unlock":
+			f.next()
+			amount := f.expr()
+			f.expect("of")
+			f.emit(col, "unlock "+amount+" of "+f.expr())
+
+		case "define":
+			f.next()
+			line := "define " + f.ident()
+			f.expect(":")
+			line += ": " + f.ident()
+			if f.is("=") {
+				f.next()
+				line += " = " + f.expr()
+			}
+			f.emit(col, line)
+
+		case "assign":
+			f.next()
+			line := "assign " + f.ident()
+			f.expect("=")
+			f.emit(col, line+" = "+f.expr())
+
+		case "if":
+			f.next()
+			cond := f.expr()
+			f.expect("{")
+			f.emit(col, "if "+cond+" {")
+			f.statements(col + indentWidth)
+			f.closeBrace(col + indentWidth)
+			if f.is("else") {
+				f.next()
+				f.expect("{")
+				f.emit(col, "} else {")
+				f.statements(col + indentWidth)
+				f.closeBrace(col + indentWidth)
+			}
+			f.emit(col, "}")
+
+		default:
+			if tok.kind != tokIdent {
+				f.errorf("expected statement")
+			}
+			f.errorf("unknown keyword \"%s\"", tok.text)
+		}
+	}
+}
+
+// closeBrace consumes the "}" ending a block whose contents are at
+// column col, writing the comments before it.
+func (f *formatter) closeBrace(col int) {
+	if !f.is("}") {
+		f.errorf("expected }")
+	}
+	f.comments(col, f.peek())
+	f.next()
+}
+
+// closeBlock consumes and writes the "}" ending a block opened at
+// column col.
+func (f *formatter) closeBlock(col int) {
+	f.closeBrace(col + indentWidth)
+	f.emit(col, "}")
+}
+
+// expr parses an expression and returns its formatted text.
+func (f *formatter) expr() string {
+	return f.parseExpr().format(1)
+}
+
+// fexpr is an expression as the formatter sees it: unlike the parser's
+// expressions, it keeps parentheses and the text of literals.
+type fexpr struct {
+	kind fexprKind
+	text string // the operator, or the text of a leaf
+	prec int    // the precedence of a binary operator
+
+	x, y *fexpr   // operands; x is also the function called or the parenthesized expression
+	args []*fexpr // call arguments or list elements
+}
+
+type fexprKind int
+
+const (
+	fexprLeaf fexprKind = iota
+	fexprUnary
+	fexprBinary
+	fexprParen
+	fexprCall
+	fexprList
+)
+
+// parseExpr parses an expression by precedence climbing, as the
+// parser does.
+func (f *formatter) parseExpr() *fexpr {
+	return f.parseExprCont(f.parseUnaryExpr(), 0)
+}
+
+func (f *formatter) binaryOp() *binaryOp {
+	tok := f.peek()
+	if tok.kind != tokPunct {
+		return nil
+	}
+	for i, op := range binaryOps {
+		if op.op == tok.text {
+			return &binaryOps[i]
+		}
+	}
+	return nil
+}
+
+func (f *formatter) parseExprCont(lhs *fexpr, minPrecedence int) *fexpr {
+	for {
+		op := f.binaryOp()
+		if op == nil || op.precedence < minPrecedence {
+			return lhs
+		}
+		f.next()
+		rhs := f.parseUnaryExpr()
+		for {
+			op2 := f.binaryOp()
+			if op2 == nil || op2.precedence <= op.precedence {
+				break
+			}
+			rhs = f.parseExprCont(rhs, op2.precedence)
+		}
+		lhs = &fexpr{kind: fexprBinary, text: op.op, prec: op.precedence, x: lhs, y: rhs}
+	}
+}
+
+func (f *formatter) parseUnaryExpr() *fexpr {
+	tok := f.peek()
+	if tok.kind != tokPunct {
+		return f.parsePrimaryExpr()
+	}
+	for _, op := range unaryOps {
+		if op.op != tok.text {
+			continue
+		}
+		f.next()
+		if next := f.peek(); op.op == "-" && next.kind == tokInt && next.start == tok.end {
+			// a negative integer literal
+			f.next()
+			return &fexpr{kind: fexprLeaf, text: "-" + next.text}
+		}
+		return &fexpr{kind: fexprUnary, text: op.op, x: f.parseUnaryExpr()}
+	}
+	return f.parsePrimaryExpr()
+}
+
+func (f *formatter) parsePrimaryExpr() *fexpr {
+	tok := f.peek()
+	switch {
+	case tok.kind == tokInt || tok.kind == tokString || tok.kind == tokBytes || tok.text == "true" || tok.text == "false":
+		f.next()
+		return &fexpr{kind: fexprLeaf, text: tok.text}
+
+	case f.is("("):
+		f.next()
+		e := &fexpr{kind: fexprParen, x: f.parseExpr()}
+		f.expect(")")
+		return f.parseCall(e)
+
+	case f.is("["):
+		f.next()
+		e := &fexpr{kind: fexprList, args: f.parseExprList("]")}
+		return f.parseCall(e)
+
+	case tok.kind == tokIdent:
+		f.next()
+		return f.parseCall(&fexpr{kind: fexprLeaf, text: tok.text})
+	}
+	f.errorf("expected expression")
+	return nil
+}
+
+func (f *formatter) parseCall(fn *fexpr) *fexpr {
+	if !f.is("(") {
+		return fn
+	}
+	f.next()
+	return &fexpr{kind: fexprCall, x: fn, args: f.parseExprList(")")}
+}
+
+// parseExprList parses comma-separated expressions up to and
+// including the closing token.
+func (f *formatter) parseExprList(closing string) []*fexpr {
+	var exprs []*fexpr
+	for !f.is(closing) {
+		if len(exprs) > 0 {
+			f.expect(",")
+		}
+		exprs = append(exprs, f.parseExpr())
+	}
+	f.next()
+	return exprs
+}
+
+// format returns the text of e. Binary operators are spaced as gofmt
+// spaces Go's, which have the same precedences: depth is the nesting
+// depth of e, and in nested expressions or expressions mixing
+// additive and multiplicative operators, the tighter-binding operators
+// lose their spaces, as in "a*b + c" and "min(a+b, c)".
+func (e *fexpr) format(depth int) string {
+	switch e.kind {
+	case fexprBinary:
+		return e.formatBinary(e.cutoff(depth), depth)
+
+	case fexprUnary:
+		x := e.x.format(depth)
+		if e.text == "-" && e.x.kind == fexprLeaf && x[0] >= '0' && x[0] <= '9' {
+			// keep it from reading as a negative literal
+			return "- " + x
+		}
+		return e.text + x
+
+	case fexprParen:
+		return "(" + e.x.format(reduceDepth(depth)) + ")"
+
+	case fexprCall:
+		if len(e.args) > 1 {
+			depth++
+		}
+		return e.x.format(depth) + "(" + formatList(e.args, depth) + ")"
+
+	case fexprList:
+		return "[" + formatList(e.args, 1) + "]"
+	}
+	return e.text
+}
+
+func formatList(exprs []*fexpr, depth int) string {
+	var strs []string
+	for _, e := range exprs {
+		strs = append(strs, e.format(depth))
+	}
+	return strings.Join(strs, ", ")
+}
+
+func (e *fexpr) formatBinary(cutoff, depth int) string {
+	x := e.x.format(depth + diffPrec(e.x, e.prec))
+	y := e.y.format(depth + 1)
+	if e.prec < cutoff {
+		return x + " " + e.text + " " + y
+	}
+	return x + e.text + y
+}
+
+// cutoff returns the precedence at and above which the operators of
+// binary expression e are printed without spaces.
+func (e *fexpr) cutoff(depth int) int {
+	has4, has5, maxProblem := e.walkBinary()
+	if maxProblem > 0 {
+		return maxProblem + 1
+	}
+	if has4 && has5 {
+		if depth == 1 {
+			return 5
+		}
+		return 4
+	}
+	if depth == 1 {
+		return 6
+	}
+	return 4
+}
+
+func (e *fexpr) walkBinary() (has4, has5 bool, maxProblem int) {
+	switch e.prec {
+	case 4:
+		has4 = true
+	case 5:
+		has5 = true
+	}
+
+	if e.x.kind == fexprBinary && e.x.prec >= e.prec {
+		h4, h5, mp := e.x.walkBinary()
+		has4, has5, maxProblem = has4 || h4, has5 || h5, mp
+	}
+
+	switch {
+	case e.y.kind == fexprBinary && e.y.prec > e.prec:
+		h4, h5, mp := e.y.walkBinary()
+		has4, has5 = has4 || h4, has5 || h5
+		if mp > maxProblem {
+			maxProblem = mp
+		}
+
+	case e.text == "-" && (e.y.kind == fexprUnary && e.y.text == "-" || e.y.kind == fexprLeaf && strings.HasPrefix(e.y.text, "-")):
+		// "a - -b" must keep its spaces
+		if maxProblem < 4 {
+			maxProblem = 4
+		}
+	}
+	return has4, has5, maxProblem
+}
+
+func diffPrec(e *fexpr, prec int) int {
+	if e.kind != fexprBinary || e.prec != prec {
+		return 1
+	}
+	return 0
+}
+
+func reduceDepth(depth int) int {
+	depth--
+	if depth < 1 {
+		depth = 1
+	}
+	return depth
+}
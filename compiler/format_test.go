@@ -0,0 +1,255 @@
+package compiler
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	cases := []struct {
+		name, src, want string
+	}{
+		{
+			"reindent",
+			`contract   C( x:Integer )locks v of a{
+clause spend(){
+verify x>0
+    unlock v   of a
+}}
+`,
+			`contract C(x: Integer) locks v of a {
+  clause spend() {
+    verify x > 0
+    unlock v of a
+  }
+}
+`,
+		},
+		{
+			"aligned params",
+			`contract C(x: Integer,
+  y, z: Hash) locks v of a {
+  clause spend() {
+    verify x > 0 && y == z
+    unlock v of a
+  }
+}
+`,
+			`contract C(x: Integer,
+           y, z: Hash) locks v of a {
+  clause spend() {
+    verify x > 0 && y == z
+    unlock v of a
+  }
+}
+`,
+		},
+		{
+			"test block",
+			`contract C(x: Integer) locks v of a {
+  clause spend() {
+    verify x > 0
+    unlock v of a
+  }
+}
+
+test "spend" {
+contract C(1+0)  locks 10 of 0x0101
+    height 5
+  clause spend( )
+output 10 of 0x0101 with C( 2 )
+  expect   pass }
+`,
+			`contract C(x: Integer) locks v of a {
+  clause spend() {
+    verify x > 0
+    unlock v of a
+  }
+}
+
+test "spend" {
+  contract C(1 + 0) locks 10 of 0x0101
+  height 5
+  clause spend()
+  output 10 of 0x0101 with C(2)
+  expect pass
+}
+`,
+		},
+		{
+			"operator spacing",
+			`contract C(x, y: Integer) locks v of a {
+  clause spend() {
+    define s: Integer = x*y/2
+    define t: Integer = x * y+1
+    verify max(x + 1, y)>=s-t && -x < - 3 && x - -1 > y
+    unlock v of a
+  }
+}
+`,
+			`contract C(x, y: Integer) locks v of a {
+  clause spend() {
+    define s: Integer = x * y / 2
+    define t: Integer = x*y + 1
+    verify max(x+1, y) >= s-t && -x < - 3 && x - -1 > y
+    unlock v of a
+  }
+}
+`,
+		},
+		{
+			"comments",
+			`// Package comment.
+
+contract C(x: Integer, // the x
+           y: Integer) locks v of a { // the value
+  // spend it
+
+  clause spend() {
+    verify x > y && // inner
+      y > 0
+    if x > 1 { unlock v of a } else {
+      // otherwise
+      unlock v of a // at once
+    }
+  }
+}
+// trailer
+`,
+			`// Package comment.
+
+contract C(x: Integer, // the x
+           y: Integer) locks v of a { // the value
+  // spend it
+
+  clause spend() {
+    // inner
+    verify x > y && y > 0
+    if x > 1 {
+      unlock v of a
+    } else {
+      // otherwise
+      unlock v of a // at once
+    }
+  }
+}
+// trailer
+`,
+		},
+		{
+			"imports and blank lines",
+			`import "./A"
+import './B'
+
+
+contract C() locks v of a {
+
+  clause spend() {
+    unlock v of a
+
+  }
+}
+
+contract D() locks v of a { clause spend() { verify [1, 0x01 ,"s"] == 2 unlock v of a } }
+`,
+			`import "./A"
+import './B'
+
+contract C() locks v of a {
+  clause spend() {
+    unlock v of a
+  }
+}
+
+contract D() locks v of a {
+  clause spend() {
+    verify [1, 0x01, "s"] == 2
+    unlock v of a
+  }
+}
+`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := Format([]byte(c.src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != c.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, c.want)
+			}
+			again, err := Format(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(again, got) {
+				t.Errorf("formatting is not idempotent, got:\n%s", again)
+			}
+		})
+	}
+}
+
+func TestFormatErrors(t *testing.T) {
+	cases := []struct {
+		src  string
+		want string
+	}{
+		{"contract C() locks v of a {\n  clause spend() {\n    unlock v of\n  }\n}\n", "line 4, col 2: expected expression"},
+		{"contract C() locks v of a {\n  clause spend() {\n    frob v\n  }\n}\n", "line 3, col 4: unknown keyword \"frob\""},
+		{"contract C() locks v of a {\n  clause spend() {\n    verify 'x\n", "line 3, col 11: unterminated string literal"},
+	}
+	for _, c := range cases {
+		_, err := Format([]byte(c.src))
+		if err == nil || err.Error() != c.want {
+			t.Errorf("Format(%q) error = %v, want %s", c.src, err, c.want)
+		}
+	}
+}
+
+// TestFormatFixtures checks that formatting the equitytest contracts
+// does not change what they compile to.
+func TestFormatFixtures(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir("equitytest"); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	files, err := filepath.Glob("[A-Z]*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		formatted, err := Format(src)
+		if err != nil {
+			t.Fatalf("%s: %s", file, err)
+		}
+		want, err := Compile(bytes.NewReader(src))
+		if err != nil {
+			t.Fatalf("%s: %s", file, err)
+		}
+		got, err := Compile(bytes.NewReader(formatted))
+		if err != nil {
+			t.Fatalf("%s formatted: %s", file, err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("%s: got %d contracts, want %d", file, len(got), len(want))
+		}
+		for i := range want {
+			if !bytes.Equal(got[i].Body, want[i].Body) {
+				t.Errorf("%s: contract %s compiles to %x after formatting, want %x", file, want[i].Name, got[i].Body, want[i].Body)
+			}
+		}
+	}
+}
@@ -0,0 +1,812 @@
+package compiler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/bytom/consensus"
+	"github.com/bytom/errors"
+	"github.com/bytom/protocol/vm"
+)
+
+// Gas is the range of gas, or runlimit, that a spend of a contract
+// uses: the whole instantiated program run with the witness, as the
+// virtual machine charges it.
+type Gas struct {
+	Best  int64 `json:"best"`
+	Worst int64 `json:"worst"`
+
+	// Unbounded tells whether the worst case depends on the length of
+	// an argument whose type does not bound it, such as a String or a
+	// Program. Such an argument is taken to be vm.ConfigLength bytes
+	// long.
+	Unbounded bool `json:"unbounded,omitempty"`
+}
+
+// PathGas is the gas used by one path through the if-else statements
+// of a clause.
+type PathGas struct {
+	// Conditions is the outcome of each if-else statement on the path,
+	// in the order they run: the condition if it holds, and the
+	// condition prefixed with "!" if it does not.
+	Conditions []string `json:"conditions"`
+
+	Gas
+}
+
+// ClauseGas is the gas used by a spend through a clause.
+type ClauseGas struct {
+	Gas
+
+	// Paths is the gas used by each path through the clause's if-else
+	// statements, if it has any.
+	Paths []PathGas `json:"paths,omitempty"`
+}
+
+// StackUsage is the most that a spend through a clause holds on the
+// stacks of the virtual machine while it runs the contract body,
+// starting with the clause arguments, the clause selector and the
+// contract arguments.
+type StackUsage struct {
+	// Depth is the greatest number of items on the data and alt stacks
+	// together.
+	Depth int `json:"depth"`
+
+	// Bytes is the greatest total length of those items.
+	Bytes int64 `json:"bytes"`
+
+	// Unbounded tells whether Bytes counts items whose length is not
+	// bounded, such as String arguments, which are taken to be
+	// vm.ConfigLength bytes long.
+	Unbounded bool `json:"unbounded,omitempty"`
+}
+
+// The instantiated program, less the body, costs one for each
+// instruction (the contract arguments, DEPTH, the body, 0, and OVER if
+// the contract is recursive), 64 kept by CHECKPREDICATE, and 9 for the
+// true it leaves on the stack. Everything else pushed is popped and
+// refunded, including the witness.
+const wrapperGas = 1 + 1 + 1 + 64 + 9
+
+// estimateUsage sets the Gas and Stack of each clause of contract,
+// from its body as assembled from ops. conditions maps the label that
+// each if statement jumps to when its condition is false to the
+// condition. They are left nil for a clause that cannot be estimated.
+func estimateUsage(contract *Contract, ops []string, conditions map[string]string) {
+	jumpLabels := make(map[uint32]string)
+	insts, err := vm.ParseProgram(contract.Body)
+	if err != nil {
+		return
+	}
+	var pc uint32
+	for _, op := range ops {
+		if strings.HasPrefix(op, "$") {
+			continue
+		}
+		if len(insts) == 0 {
+			return
+		}
+		if strings.HasPrefix(op, "JUMPIF:$") {
+			jumpLabels[pc] = op[len("JUMPIF:$"):]
+		}
+		pc += insts[0].Len
+		insts = insts[1:]
+	}
+
+	overhead := int64(wrapperGas + len(contract.Params))
+	if contract.Recursive {
+		overhead++
+	}
+
+	for i, clause := range contract.Clauses {
+		clause.Gas, clause.Stack = nil, nil
+		g := &gasEstimator{contract: contract, jumpLabels: jumpLabels, conditions: conditions}
+		if err := g.run(initialGasState(contract, i)); err != nil {
+			continue
+		}
+		if len(g.paths) == 0 {
+			// no path through the clause succeeds
+			continue
+		}
+
+		result := &ClauseGas{Gas: Gas{Best: -1}}
+		for j := range g.paths {
+			p := &g.paths[j]
+			p.Best += overhead
+			p.Worst += overhead
+			if result.Best < 0 || p.Best < result.Best {
+				result.Best = p.Best
+			}
+			if p.Worst > result.Worst {
+				result.Worst = p.Worst
+			}
+			result.Unbounded = result.Unbounded || p.Unbounded
+		}
+		if len(g.paths) > 1 || len(g.paths[0].Conditions) > 0 {
+			result.Paths = g.paths
+		}
+		clause.Gas = result
+		clause.Stack = &g.peak
+	}
+}
+
+// gasItem is what the estimator knows of an item on the stack.
+type gasItem struct {
+	// min and max bound the length of the item. max is negative if
+	// the length is unbounded.
+	min, max int64
+
+	// data is the item itself, if it is known.
+	data  []byte
+	known bool
+}
+
+func knownItem(data []byte) gasItem {
+	return gasItem{min: int64(len(data)), max: int64(len(data)), data: data, known: true}
+}
+
+// lengthOf returns the range of lengths of items of type t.
+func lengthOf(t typeDesc) gasItem {
+	switch t {
+	case boolType:
+		return gasItem{min: 0, max: 1}
+	case intType, amountType:
+		return gasItem{min: 0, max: 8}
+	case assetType, hashType, pubkeyType, sha3StrType, sha3PubkeyType, sha256StrType, sha256PubkeyType:
+		return gasItem{min: 32, max: 32}
+	case sigType, signType:
+		return gasItem{min: 64, max: 64}
+	}
+	return gasItem{min: 0, max: -1}
+}
+
+var (
+	intItem  = gasItem{min: 0, max: 8}
+	boolItem = gasItem{min: 0, max: 1}
+	hashItem = gasItem{min: 32, max: 32}
+)
+
+// hi returns the greatest length of item, taking an unbounded one to
+// be vm.ConfigLength bytes long, or as long as it must be if longer.
+func (item gasItem) hi() (int64, bool) {
+	if item.max < 0 {
+		return maxInt64(item.min, vm.ConfigLength), true
+	}
+	return item.max, false
+}
+
+// gasState is the state of the virtual machine on one path through
+// the body.
+type gasState struct {
+	pc         uint32
+	stack, alt []gasItem
+	conditions []string
+
+	best, worst int64
+	unbounded   bool
+}
+
+// initialGasState returns the state in which the body starts for a
+// spend through clause i of contract: the clause arguments, then the
+// clause selector, the contract arguments last first, and the body
+// for a recursive contract.
+func initialGasState(contract *Contract, i int) *gasState {
+	st := new(gasState)
+	for _, p := range contract.Clauses[i].Params {
+		st.stack = append(st.stack, lengthOf(p.Type))
+	}
+	if len(contract.Clauses) > 1 {
+		st.stack = append(st.stack, knownItem(vm.Int64Bytes(int64(i))))
+	}
+	for j := len(contract.Params) - 1; j >= 0; j-- {
+		st.stack = append(st.stack, lengthOf(contract.Params[j].Type))
+	}
+	if contract.Recursive {
+		st.stack = append(st.stack, knownItem(contract.Body))
+	}
+	return st
+}
+
+func (st *gasState) fork() *gasState {
+	return &gasState{
+		pc:         st.pc,
+		stack:      append([]gasItem{}, st.stack...),
+		alt:        append([]gasItem{}, st.alt...),
+		conditions: append([]string{}, st.conditions...),
+		best:       st.best,
+		worst:      st.worst,
+		unbounded:  st.unbounded,
+	}
+}
+
+func (st *gasState) charge(best, worst int64, unbounded bool) {
+	st.best += best
+	st.worst += worst
+	st.unbounded = st.unbounded || unbounded
+}
+
+func (st *gasState) push(items ...gasItem) {
+	st.stack = append(st.stack, items...)
+}
+
+// pop removes n items from the stack and returns them, bottom first.
+func (st *gasState) pop(n int) ([]gasItem, error) {
+	if n > len(st.stack) {
+		return nil, vm.ErrDataStackUnderflow
+	}
+	items := append([]gasItem{}, st.stack[len(st.stack)-n:]...)
+	st.stack = st.stack[:len(st.stack)-n]
+	return items, nil
+}
+
+// popInt64 pops an item that must be a known integer.
+func (st *gasState) popInt64() (int64, error) {
+	items, err := st.pop(1)
+	if err != nil {
+		return 0, err
+	}
+	if !items[0].known {
+		return 0, fmt.Errorf("unknown count at %d", st.pc)
+	}
+	return vm.AsInt64(items[0].data)
+}
+
+// errPathFails is returned by gasEstimator.step for an instruction
+// that always fails on the path being followed.
+var errPathFails = errors.New("path fails")
+
+type gasEstimator struct {
+	contract   *Contract
+	jumpLabels map[uint32]string
+	conditions map[string]string
+	paths      []PathGas
+	peak       StackUsage
+}
+
+// measure records the stacks of st in g.peak if they hold more than
+// any seen so far.
+func (g *gasEstimator) measure(st *gasState) {
+	depth := len(st.stack) + len(st.alt)
+	if depth > g.peak.Depth {
+		g.peak.Depth = depth
+	}
+	var (
+		bytes     int64
+		unbounded bool
+	)
+	for _, items := range [][]gasItem{st.stack, st.alt} {
+		for _, item := range items {
+			hi, unb := item.hi()
+			bytes += hi
+			unbounded = unbounded || unb
+		}
+	}
+	switch {
+	case bytes > g.peak.Bytes:
+		g.peak.Bytes, g.peak.Unbounded = bytes, unbounded
+	case bytes == g.peak.Bytes:
+		g.peak.Unbounded = g.peak.Unbounded || unbounded
+	}
+}
+
+// run follows every path from st to the end of the body that may
+// succeed, adding each to g.paths.
+func (g *gasEstimator) run(st *gasState) error {
+	body := g.contract.Body
+	g.measure(st)
+	for st.pc < uint32(len(body)) {
+		inst, err := vm.ParseOp(body, st.pc)
+		if err != nil {
+			return err
+		}
+		next := st.pc + inst.Len
+
+		switch inst.Op {
+		case vm.OP_JUMP, vm.OP_JUMPIF:
+			st.charge(1, 1, false)
+			target := binary.LittleEndian.Uint32(inst.Data)
+			if target <= st.pc {
+				return fmt.Errorf("backward jump at %d", st.pc)
+			}
+			if inst.Op == vm.OP_JUMP {
+				st.pc = target
+				continue
+			}
+			items, err := st.pop(1)
+			if err != nil {
+				return err
+			}
+			if items[0].known {
+				if vm.AsBool(items[0].data) {
+					next = target
+				}
+				break
+			}
+			// Both ways may be taken. An if statement jumps when its
+			// condition is false, so the way it falls through is
+			// followed first.
+			cond, isIf := g.conditions[g.jumpLabels[st.pc]]
+			fallThrough := st.fork()
+			fallThrough.pc = next
+			if isIf {
+				fallThrough.conditions = append(fallThrough.conditions, cond)
+				st.conditions = append(st.conditions, "!"+cond)
+			}
+			if err := g.run(fallThrough); err != nil {
+				return err
+			}
+			next = target
+
+		case vm.OP_VERIFY:
+			st.charge(1, 1, false)
+			items, err := st.pop(1)
+			if err != nil {
+				return err
+			}
+			if items[0].known && !vm.AsBool(items[0].data) {
+				return nil
+			}
+
+		case vm.OP_FAIL:
+			return nil
+
+		default:
+			if err := g.step(st, inst); err == errPathFails {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+		g.measure(st)
+		st.pc = next
+	}
+
+	g.paths = append(g.paths, PathGas{
+		Conditions: st.conditions,
+		Gas:        Gas{Best: st.best, Worst: st.worst, Unbounded: st.unbounded},
+	})
+	return nil
+}
+
+// step charges st for an instruction other than a jump and applies
+// its effect on the stack, as the virtual machine does. Only the cost
+// that an instruction applies for itself is charged: what the virtual
+// machine charges for pushing an item it refunds on popping it, and
+// what remains on the stack at the end of the body is refunded by
+// CHECKPREDICATE.
+func (g *gasEstimator) step(st *gasState, inst vm.Instruction) error {
+	op := inst.Op
+	switch {
+	case inst.IsPushdata():
+		st.charge(1, 1, false)
+		st.push(knownItem(inst.Data))
+		return nil
+
+	case op == vm.OP_1NEGATE:
+		st.charge(1, 1, false)
+		st.push(knownItem(vm.Int64Bytes(-1)))
+		return nil
+	}
+
+	switch op {
+	case vm.OP_NOP:
+		st.charge(1, 1, false)
+
+	case vm.OP_TOALTSTACK:
+		st.charge(2, 2, false)
+		items, err := st.pop(1)
+		if err != nil {
+			return err
+		}
+		st.alt = append(st.alt, items[0])
+
+	case vm.OP_FROMALTSTACK:
+		st.charge(2, 2, false)
+		if len(st.alt) == 0 {
+			return vm.ErrAltStackUnderflow
+		}
+		st.push(st.alt[len(st.alt)-1])
+		st.alt = st.alt[:len(st.alt)-1]
+
+	case vm.OP_DUP, vm.OP_2DUP, vm.OP_3DUP:
+		n := 1
+		switch op {
+		case vm.OP_2DUP:
+			n = 2
+		case vm.OP_3DUP:
+			n = 3
+		}
+		st.charge(int64(n), int64(n), false)
+		items, err := st.pop(n)
+		if err != nil {
+			return err
+		}
+		st.push(items...)
+		st.push(items...)
+
+	case vm.OP_OVER, vm.OP_2OVER:
+		n := 2
+		cost := int64(1)
+		if op == vm.OP_2OVER {
+			n, cost = 4, 2
+		}
+		st.charge(cost, cost, false)
+		items, err := st.pop(n)
+		if err != nil {
+			return err
+		}
+		st.push(items...)
+		st.push(items[:n/2]...)
+
+	case vm.OP_PICK, vm.OP_ROLL:
+		st.charge(2, 2, false)
+		n, err := st.popInt64()
+		if err != nil {
+			return err
+		}
+		if n < 0 || n >= int64(len(st.stack)) {
+			return vm.ErrDataStackUnderflow
+		}
+		i := len(st.stack) - 1 - int(n)
+		item := st.stack[i]
+		if op == vm.OP_ROLL {
+			st.stack = append(st.stack[:i], st.stack[i+1:]...)
+		}
+		st.push(item)
+
+	case vm.OP_DROP:
+		st.charge(1, 1, false)
+		if _, err := st.pop(1); err != nil {
+			return err
+		}
+
+	case vm.OP_2DROP:
+		st.charge(2, 2, false)
+		if _, err := st.pop(2); err != nil {
+			return err
+		}
+
+	case vm.OP_NIP:
+		st.charge(1, 1, false)
+		items, err := st.pop(2)
+		if err != nil {
+			return err
+		}
+		st.push(items[1])
+
+	case vm.OP_SWAP, vm.OP_TUCK, vm.OP_ROT, vm.OP_2ROT, vm.OP_2SWAP:
+		var (
+			n     int
+			cost  int64
+			order []int
+		)
+		switch op {
+		case vm.OP_SWAP:
+			n, cost, order = 2, 1, []int{1, 0}
+		case vm.OP_TUCK:
+			n, cost, order = 2, 1, []int{1, 0, 1}
+		case vm.OP_ROT:
+			n, cost, order = 3, 2, []int{1, 2, 0}
+		case vm.OP_2ROT:
+			n, cost, order = 6, 2, []int{2, 3, 4, 5, 0, 1}
+		case vm.OP_2SWAP:
+			n, cost, order = 4, 2, []int{2, 3, 0, 1}
+		}
+		st.charge(cost, cost, false)
+		items, err := st.pop(n)
+		if err != nil {
+			return err
+		}
+		for _, j := range order {
+			st.push(items[j])
+		}
+
+	case vm.OP_DEPTH:
+		st.charge(1, 1, false)
+		st.push(knownItem(vm.Int64Bytes(int64(len(st.stack)))))
+
+	case vm.OP_SIZE:
+		st.charge(1, 1, false)
+		if len(st.stack) == 0 {
+			return vm.ErrDataStackUnderflow
+		}
+		top := st.stack[len(st.stack)-1]
+		if top.min == top.max {
+			st.push(knownItem(vm.Int64Bytes(top.min)))
+		} else {
+			st.push(intItem)
+		}
+
+	case vm.OP_CAT, vm.OP_CATPUSHDATA:
+		// The lengths of the operands are charged and refunded.
+		st.charge(4, 4, false)
+		items, err := st.pop(2)
+		if err != nil {
+			return err
+		}
+		a, b := items[0], items[1]
+		if a.known && b.known {
+			if op == vm.OP_CAT {
+				st.push(knownItem(append(append([]byte{}, a.data...), b.data...)))
+			} else {
+				st.push(knownItem(append(append([]byte{}, a.data...), vm.PushdataBytes(b.data)...)))
+			}
+			break
+		}
+		result := gasItem{min: a.min + b.min, max: -1}
+		if op == vm.OP_CATPUSHDATA {
+			result.min = a.min + int64(len(vm.PushdataBytes(make([]byte, b.min))))
+		}
+		if a.max >= 0 && b.max >= 0 {
+			result.max = a.max + b.max
+			if op == vm.OP_CATPUSHDATA {
+				result.max = a.max + int64(len(vm.PushdataBytes(make([]byte, b.max))))
+			}
+		}
+		st.push(result)
+
+	case vm.OP_INVERT:
+		if len(st.stack) == 0 {
+			return vm.ErrDataStackUnderflow
+		}
+		top := st.stack[len(st.stack)-1]
+		hi, unbounded := top.hi()
+		st.charge(1+top.min, 1+hi, unbounded)
+		st.stack[len(st.stack)-1] = gasItem{min: top.min, max: top.max}
+
+	case vm.OP_AND, vm.OP_OR, vm.OP_XOR, vm.OP_EQUAL, vm.OP_EQUALVERIFY:
+		items, err := st.pop(2)
+		if err != nil {
+			return err
+		}
+		a, b := items[0], items[1]
+
+		// AND and EQUAL cost the length of the shorter operand, and
+		// OR and XOR that of the longer, which is the length of the
+		// result.
+		var result gasItem
+		if op == vm.OP_OR || op == vm.OP_XOR {
+			result = longer(a, b)
+		} else {
+			result = shorter(a, b)
+		}
+		hi, unbounded := result.hi()
+		st.charge(1+result.min, 1+hi, unbounded)
+
+		switch op {
+		case vm.OP_EQUAL:
+			if a.known && b.known {
+				st.push(knownItem(vm.BoolBytes(bytes.Equal(a.data, b.data))))
+			} else {
+				st.push(boolItem)
+			}
+		case vm.OP_EQUALVERIFY:
+			if a.known && b.known && !bytes.Equal(a.data, b.data) {
+				return errPathFails
+			}
+		default:
+			st.push(result)
+		}
+
+	case vm.OP_NOT:
+		st.charge(2, 2, false)
+		items, err := st.pop(1)
+		if err != nil {
+			return err
+		}
+		if items[0].known {
+			st.push(knownItem(vm.BoolBytes(!vm.AsBool(items[0].data))))
+		} else {
+			st.push(boolItem)
+		}
+
+	case vm.OP_0NOTEQUAL:
+		st.charge(2, 2, false)
+		if _, err := st.pop(1); err != nil {
+			return err
+		}
+		st.push(boolItem)
+
+	case vm.OP_1ADD, vm.OP_1SUB, vm.OP_2MUL, vm.OP_2DIV, vm.OP_NEGATE, vm.OP_ABS:
+		st.charge(2, 2, false)
+		if _, err := st.pop(1); err != nil {
+			return err
+		}
+		st.push(intItem)
+
+	case vm.OP_NUMEQUAL:
+		st.charge(2, 2, false)
+		items, err := st.pop(2)
+		if err != nil {
+			return err
+		}
+		if items[0].known && items[1].known {
+			x, errx := vm.AsInt64(items[0].data)
+			y, erry := vm.AsInt64(items[1].data)
+			if errx == nil && erry == nil {
+				st.push(knownItem(vm.BoolBytes(x == y)))
+				break
+			}
+		}
+		st.push(boolItem)
+
+	case vm.OP_LESSTHAN, vm.OP_GREATERTHAN, vm.OP_LESSTHANOREQUAL, vm.OP_GREATERTHANOREQUAL:
+		// A body may search for its clause by comparing the clause
+		// selector, which is known.
+		st.charge(2, 2, false)
+		items, err := st.pop(2)
+		if err != nil {
+			return err
+		}
+		if items[0].known && items[1].known {
+			x, errx := vm.AsInt64(items[0].data)
+			y, erry := vm.AsInt64(items[1].data)
+			if errx == nil && erry == nil {
+				var result bool
+				switch op {
+				case vm.OP_LESSTHAN:
+					result = x < y
+				case vm.OP_GREATERTHAN:
+					result = x > y
+				case vm.OP_LESSTHANOREQUAL:
+					result = x <= y
+				default:
+					result = x >= y
+				}
+				st.push(knownItem(vm.BoolBytes(result)))
+				break
+			}
+		}
+		st.push(boolItem)
+
+	case vm.OP_ADD, vm.OP_SUB, vm.OP_MIN, vm.OP_MAX,
+		vm.OP_BOOLAND, vm.OP_BOOLOR, vm.OP_NUMNOTEQUAL:
+		st.charge(2, 2, false)
+		if _, err := st.pop(2); err != nil {
+			return err
+		}
+		switch op {
+		case vm.OP_ADD, vm.OP_SUB, vm.OP_MIN, vm.OP_MAX:
+			st.push(intItem)
+		default:
+			st.push(boolItem)
+		}
+
+	case vm.OP_NUMEQUALVERIFY:
+		st.charge(2, 2, false)
+		if _, err := st.pop(2); err != nil {
+			return err
+		}
+
+	case vm.OP_MUL, vm.OP_DIV, vm.OP_MOD, vm.OP_LSHIFT, vm.OP_RSHIFT:
+		st.charge(8, 8, false)
+		if _, err := st.pop(2); err != nil {
+			return err
+		}
+		st.push(intItem)
+
+	case vm.OP_WITHIN:
+		st.charge(4, 4, false)
+		if _, err := st.pop(3); err != nil {
+			return err
+		}
+		st.push(boolItem)
+
+	case vm.OP_SHA256, vm.OP_SHA3:
+		// hashing costs the length of the input, but at least 64
+		items, err := st.pop(1)
+		if err != nil {
+			return err
+		}
+		hi, unbounded := items[0].hi()
+		st.charge(maxInt64(items[0].min, 64), maxInt64(hi, 64), unbounded)
+		st.push(hashItem)
+
+	case vm.OP_CHECKSIG:
+		st.charge(1024, 1024, false)
+		if _, err := st.pop(3); err != nil {
+			return err
+		}
+		st.push(boolItem)
+
+	case vm.OP_CHECKMULTISIG:
+		numPubkeys, err := st.popInt64()
+		if err != nil {
+			return err
+		}
+		st.charge(1024*numPubkeys, 1024*numPubkeys, false)
+		numSigs, err := st.popInt64()
+		if err != nil {
+			return err
+		}
+		if _, err := st.pop(int(numPubkeys + 1 + numSigs)); err != nil {
+			return err
+		}
+		st.push(boolItem)
+
+	case vm.OP_TXSIGHASH:
+		st.charge(256, 256, false)
+		st.push(hashItem)
+
+	case vm.OP_CHECKOUTPUT:
+		st.charge(16, 16, false)
+		if _, err := st.pop(5); err != nil {
+			return err
+		}
+		st.push(boolItem)
+
+	case vm.OP_ASSET, vm.OP_ENTRYID, vm.OP_OUTPUTID:
+		st.charge(1, 1, false)
+		st.push(hashItem)
+
+	case vm.OP_AMOUNT, vm.OP_INDEX, vm.OP_BLOCKHEIGHT:
+		st.charge(1, 1, false)
+		st.push(intItem)
+
+	case vm.OP_PROGRAM:
+		st.charge(1, 1, false)
+		st.push(gasItem{min: 0, max: -1})
+
+	default:
+		return fmt.Errorf("cannot estimate the gas of %s at %d", op, st.pc)
+	}
+	return nil
+}
+
+// shorter returns the range of the lesser of the lengths of a and b.
+func shorter(a, b gasItem) gasItem {
+	result := gasItem{min: minInt64(a.min, b.min), max: a.max}
+	if a.max < 0 || (b.max >= 0 && b.max < a.max) {
+		result.max = b.max
+	}
+	return result
+}
+
+// longer returns the range of the greater of the lengths of a and b.
+func longer(a, b gasItem) gasItem {
+	result := gasItem{min: maxInt64(a.min, b.min), max: maxInt64(a.max, b.max)}
+	if a.max < 0 || b.max < 0 {
+		result.max = -1
+	}
+	return result
+}
+
+// nearLimit is how much of the gas that a transaction may use a spend
+// may need before it is warned about.
+const nearLimit = consensus.MaxGasAmount / 5 * 4
+
+// checkLimits returns warnings for the clauses of contract that may
+// come close to the gas that a transaction may use, either in running
+// or in what the virtual machine charges for the items on the stack,
+// 8 for each item and 1 for each byte, while they are there.
+func checkLimits(contract *Contract) Diagnostics {
+	var warnings Diagnostics
+	for _, clause := range contract.Clauses {
+		if clause.Gas != nil && clause.Gas.Worst >= nearLimit {
+			warnings = append(warnings, warningf(clause.span, CodeNearLimit, "clause \"%s\" may use %d gas, close to the limit of %d for a transaction", clause.Name, clause.Gas.Worst, consensus.MaxGasAmount))
+		}
+		if clause.Stack != nil {
+			cost := 8*int64(clause.Stack.Depth) + clause.Stack.Bytes
+			if cost >= nearLimit {
+				warnings = append(warnings, warningf(clause.span, CodeNearLimit, "clause \"%s\" may hold %d items of %d bytes on the stack, costing %d gas, close to the limit of %d for a transaction", clause.Name, clause.Stack.Depth, clause.Stack.Bytes, cost, consensus.MaxGasAmount))
+			}
+		}
+	}
+	return warnings
+}
+
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,105 @@
+package compiler
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const gated = `
+contract Gated(hash: Hash, publicKey: PublicKey, deadline: Integer) locks value of asset {
+  clause reveal(preimage: String) {
+    verify sha256(preimage) == hash
+    unlock value of asset
+  }
+  clause expire(sig: Signature) {
+    if above(deadline) {
+      verify checkTxSig(publicKey, sig)
+    }
+    unlock value of asset
+  }
+}
+`
+
+func TestEstimateGas(t *testing.T) {
+	contracts, err := Compile(strings.NewReader(gated))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reveal, expire := contracts[0].Clauses[0].Gas, contracts[0].Clauses[1].Gas
+	if reveal == nil || expire == nil {
+		t.Fatalf("got gas %+v and %+v, want estimates", reveal, expire)
+	}
+
+	// The preimage may be of any length.
+	if !reveal.Unbounded || reveal.Best > reveal.Worst || reveal.Paths != nil {
+		t.Errorf("got reveal gas %+v, want unbounded without paths", reveal)
+	}
+
+	if expire.Unbounded || len(expire.Paths) != 2 {
+		t.Fatalf("got expire gas %+v, want two bounded paths", expire)
+	}
+	signed, unsigned := expire.Paths[0], expire.Paths[1]
+	if want := []string{"above(deadline)"}; !reflect.DeepEqual(signed.Conditions, want) {
+		t.Errorf("got conditions %q, want %q", signed.Conditions, want)
+	}
+	if want := []string{"!above(deadline)"}; !reflect.DeepEqual(unsigned.Conditions, want) {
+		t.Errorf("got conditions %q, want %q", unsigned.Conditions, want)
+	}
+	// checking the signature costs 256 for TXSIGHASH and 1024 for CHECKSIG
+	if signed.Best != signed.Worst || unsigned.Best != unsigned.Worst || signed.Best-unsigned.Best < 1280 {
+		t.Errorf("got path gas %+v and %+v", signed.Gas, unsigned.Gas)
+	}
+	if expire.Best != unsigned.Best || expire.Worst != signed.Worst {
+		t.Errorf("got clause gas %+v, want %d to %d", expire.Gas, unsigned.Best, signed.Worst)
+	}
+}
+
+func TestStackUsage(t *testing.T) {
+	contracts, err := Compile(strings.NewReader(gated))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reveal, expire := contracts[0].Clauses[0].Stack, contracts[0].Clauses[1].Stack
+	if reveal == nil || expire == nil {
+		t.Fatalf("got stack %+v and %+v, want estimates", reveal, expire)
+	}
+	// preimage, selector, deadline, publicKey and hash to begin with
+	if reveal.Depth < 5 || !reveal.Unbounded {
+		t.Errorf("got reveal stack %+v, want at least 5 items, unbounded", reveal)
+	}
+	// sig, selector, deadline, publicKey and hash
+	if expire.Depth < 5 || expire.Bytes < 64+1+32+32 || expire.Unbounded {
+		t.Errorf("got expire stack %+v, want at least 5 items of 129 bytes", expire)
+	}
+	if len(contracts[0].Warnings) != 0 {
+		t.Errorf("got warnings %v", contracts[0].Warnings)
+	}
+
+	// 6 literals of 30000 bytes, each on a line of its own
+	data := "s"
+	for i := 0; i < 6; i++ {
+		data = "concat(" + data + ",\n      0x" + strings.Repeat("ab", 30000) + ")"
+	}
+	big := `
+contract Big(hash: Hash) locks value of asset {
+  clause spend(s: String) {
+    verify sha3(` + data + `) == hash
+    unlock value of asset
+  }
+}
+`
+	contracts, err = Compile(strings.NewReader(big))
+	if err != nil {
+		t.Fatal(err)
+	}
+	warnings := contracts[0].Warnings
+	if len(warnings) != 2 {
+		t.Fatalf("got warnings %v, want 2", warnings)
+	}
+	for _, w := range warnings {
+		if w.Severity != SeverityWarning || w.Code != CodeNearLimit || w.Line != 3 {
+			t.Errorf("got warning %+v", w)
+		}
+	}
+}
@@ -1,12 +1,32 @@
 package compiler
 
 import (
-	"bufio"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 )
 
+// ImportResolver finds the file that an import directive names by
+// path, returning its name, for diagnostics, and its source.
+type ImportResolver func(path string) (name string, src []byte, err error)
+
+// DirResolver returns an ImportResolver that looks for a relative path
+// in each of dirs in turn before resolving it as the compiler does by
+// default, relative to the working directory.
+func DirResolver(dirs ...string) ImportResolver {
+	return func(path string) (string, []byte, error) {
+		if !filepath.IsAbs(path) {
+			for _, dir := range dirs {
+				if _, err := absolutePath(filepath.Join(dir, path)); err == nil {
+					return resolveFile(filepath.Join(dir, path))
+				}
+			}
+		}
+		return resolveFile(path)
+	}
+}
+
 func parseImportDirectives(p *parser) []*Contract {
 	var result []*Contract
 	for peekKeyword(p) == "import" {
@@ -21,31 +41,19 @@ func parseImportDirectives(p *parser) []*Contract {
 func parseImportDirective(p *parser) []*Contract {
 	pathFile := parseImport(p)
 	if len(pathFile) == 0 {
-		p.errorf("Import path is empty")
-	}
-
-	// acquire absolute path and check the file status
-	importFile, err := absolutePath(string(pathFile))
-	if err != nil {
-		p.errorf("Check absolute path error: %v", err)
+		p.errorCodef(CodeImport, "Import path is empty")
 	}
 
-	inputFile, err := os.Open(importFile)
-	if err != nil {
-		p.errorf("Open the import contract file \"%s\" error: %v", importFile, err)
-	}
-	defer inputFile.Close()
-
-	inputReader := bufio.NewReader(inputFile)
-	importContract, err := ioutil.ReadAll(inputReader)
+	importFile, importContract, err := p.resolve(string(pathFile))
 	if err != nil {
-		p.errorf("Read the import contract file \"%s\" error: %v", inputFile.Name(), err)
+		p.errorCodef(CodeImport, "%s", err)
 	}
 
-	// parse the import contract
-	contracts, err := parse(importContract)
+	// parse the import contract, reporting its errors in its own file;
+	// its tests are not imported
+	contracts, _, err := parse(importContract, importFile, p.resolve)
 	if err != nil {
-		p.errorf("Parse the import contract file \"%s\" error: %v", inputFile.Name(), err)
+		panic(err.(Diagnostics))
 	}
 	return contracts
 }
@@ -54,11 +62,33 @@ func parseImport(p *parser) []byte {
 	consumeKeyword(p, "import")
 	importPathFile, newOffset := scanStrLiteral(p.buf, p.pos)
 	if newOffset < 0 {
-		p.errorf("Invalid import character format")
+		p.errorCodef(CodeImport, "Invalid import character format")
 	}
 	p.pos = newOffset
 
-	return importPathFile
+	return importPathFile.value
+}
+
+// resolveFile is the default ImportResolver, which takes a path to be
+// relative to the working directory.
+func resolveFile(path string) (string, []byte, error) {
+	// acquire absolute path and check the file status
+	importFile, err := absolutePath(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("Check absolute path error: %v", err)
+	}
+
+	inputFile, err := os.Open(importFile)
+	if err != nil {
+		return "", nil, fmt.Errorf("Open the import contract file \"%s\" error: %v", importFile, err)
+	}
+	defer inputFile.Close()
+
+	src, err := ioutil.ReadAll(inputFile)
+	if err != nil {
+		return "", nil, fmt.Errorf("Read the import contract file \"%s\" error: %v", importFile, err)
+	}
+	return importFile, src, nil
 }
 
 func absolutePath(pathFile string) (string, error) {
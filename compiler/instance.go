@@ -0,0 +1,136 @@
+package compiler
+
+import (
+	"bytes"
+	"fmt"
+
+	chainjson "github.com/bytom/encoding/json"
+	"github.com/bytom/errors"
+	"github.com/bytom/protocol/vm"
+)
+
+// ErrNotInstance is returned for a program that does not have the
+// layout of an instantiated contract.
+var ErrNotInstance = errors.New("program is not an instantiated contract")
+
+// ErrBodyMismatch is returned by ParseInstance for an instantiated
+// contract whose body is not that of the expected contract.
+var ErrBodyMismatch = errors.New("program instantiates another contract")
+
+// Instance is a control program split into the parts Instantiate
+// builds it from.
+type Instance struct {
+	// Args is the data pushed for the contract arguments, the first
+	// argument first. Integers and booleans are in the encoding of
+	// vm.Int64Bytes.
+	Args [][]byte
+
+	// Body is the contract body the program runs.
+	Body []byte
+
+	// Recursive tells which of the two layouts the program has.
+	Recursive bool
+
+	// Insts is the instructions of the program, and Start the index in
+	// Insts of the first instruction after the arguments.
+	Insts []vm.Instruction
+	Start int
+}
+
+// SplitInstance recognizes a program built by Instantiate, in either
+// of its layouts:
+//
+//	<argN> ... <arg1> DEPTH <body> 0 CHECKPREDICATE
+//	<argN> ... <arg1> <body> DEPTH OVER 0 CHECKPREDICATE
+//
+// and splits it into its parts. It returns ErrNotInstance if the
+// program has another layout.
+func SplitInstance(prog []byte) (*Instance, error) {
+	insts, err := vm.ParseProgram(prog)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing program")
+	}
+
+	n := len(insts)
+	if n < 4 || insts[n-1].Op != vm.OP_CHECKPREDICATE || insts[n-2].Op != vm.OP_0 {
+		return nil, ErrNotInstance
+	}
+	inst := &Instance{Insts: insts}
+	switch {
+	case insts[n-4].Op == vm.OP_DEPTH && insts[n-3].IsPushdata():
+		inst.Start = n - 4
+		inst.Body = insts[n-3].Data
+	case n >= 5 && insts[n-3].Op == vm.OP_OVER && insts[n-4].Op == vm.OP_DEPTH && insts[n-5].IsPushdata():
+		inst.Start = n - 5
+		inst.Body = insts[n-5].Data
+		inst.Recursive = true
+	default:
+		return nil, ErrNotInstance
+	}
+
+	for i := inst.Start - 1; i >= 0; i-- {
+		if !insts[i].IsPushdata() {
+			return nil, ErrNotInstance
+		}
+		inst.Args = append(inst.Args, insts[i].Data)
+	}
+	return inst, nil
+}
+
+// ParseInstance recovers the arguments with which contract was
+// instantiated to make prog, keyed by parameter name. It is the
+// inverse of Instantiate. It returns ErrNotInstance if prog is not an
+// instantiated contract and ErrBodyMismatch if it instantiates a
+// contract other than contract.
+func ParseInstance(contract *Contract, prog []byte) (map[string]ContractArg, error) {
+	inst, err := SplitInstance(prog)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(inst.Body, contract.Body) {
+		return nil, ErrBodyMismatch
+	}
+	if inst.Recursive != contract.Recursive {
+		layout := "non-recursive"
+		if inst.Recursive {
+			layout = "recursive"
+		}
+		return nil, errors.Wrapf(ErrNotInstance, "program has the %s layout", layout)
+	}
+	if len(inst.Args) != len(contract.Params) {
+		return nil, fmt.Errorf("got %d argument(s), want %d", len(inst.Args), len(contract.Params))
+	}
+
+	result := make(map[string]ContractArg)
+	for i, param := range contract.Params {
+		arg, err := decodeArg(param, inst.Args[i], true)
+		if err != nil {
+			return nil, err
+		}
+		result[param.Name] = arg
+	}
+	return result, nil
+}
+
+// decodeArg decodes the data for an argument of param. If strict is
+// set, integers and booleans must be encoded as Instantiate and
+// WitnessFor encode them. Otherwise any data the virtual machine
+// accepts as an integer or boolean is decoded.
+func decodeArg(param *Param, data []byte, strict bool) (ContractArg, error) {
+	switch param.Type {
+	case amountType, intType:
+		n, err := vm.AsInt64(data)
+		if err != nil || (strict && !bytes.Equal(vm.Int64Bytes(n), data)) {
+			return ContractArg{}, fmt.Errorf("bad integer %x for \"%s\"", data, param.Name)
+		}
+		return ContractArg{I: &n}, nil
+	case boolType:
+		if strict && (len(data) > 1 || (len(data) == 1 && data[0] != 1)) {
+			return ContractArg{}, fmt.Errorf("bad boolean %x for \"%s\"", data, param.Name)
+		}
+		b := vm.AsBool(data)
+		return ContractArg{B: &b}, nil
+	}
+	s := chainjson.HexBytes(append([]byte{}, data...))
+	return ContractArg{S: &s}, nil
+}
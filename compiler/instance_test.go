@@ -0,0 +1,134 @@
+package compiler
+
+import (
+	"bytes"
+	"testing"
+
+	chainjson "github.com/bytom/encoding/json"
+	"github.com/bytom/errors"
+)
+
+func TestSplitInstance(t *testing.T) {
+	key := chainjson.HexBytes(bytes.Repeat([]byte{0xaa}, 32))
+	dest := chainjson.HexBytes{0x00, 0x14, 0xba}
+	deadline, zero := int64(100), int64(0)
+	yes := true
+
+	cases := []struct {
+		name      string
+		recursive bool
+		args      []ContractArg
+		want      [][]byte
+	}{
+		{"no args", false, nil, nil},
+		{"args", false, []ContractArg{{S: &key}, {I: &deadline}, {S: &dest}}, [][]byte{key, {100}, dest}},
+		{"small ints", false, []ContractArg{{I: &zero}, {B: &yes}}, [][]byte{nil, {1}}},
+		{"recursive", true, []ContractArg{{S: &key}, {I: &deadline}}, [][]byte{key, {100}}},
+	}
+	body := []byte{0xae, 0x7c, 0xac}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			params := make([]*Param, len(c.args))
+			for i, arg := range c.args {
+				params[i] = &Param{Type: strType}
+				if arg.I != nil {
+					params[i].Type = intType
+				} else if arg.B != nil {
+					params[i].Type = boolType
+				}
+			}
+			prog, err := Instantiate(body, params, c.recursive, c.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			inst, err := SplitInstance(prog)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(inst.Body, body) || inst.Recursive != c.recursive {
+				t.Errorf("got body %x recursive %v", inst.Body, inst.Recursive)
+			}
+			if len(inst.Args) != len(c.want) {
+				t.Fatalf("got %d args, want %d", len(inst.Args), len(c.want))
+			}
+			for i, want := range c.want {
+				if !bytes.Equal(inst.Args[i], want) {
+					t.Errorf("arg %d: got %x, want %x", i, inst.Args[i], want)
+				}
+			}
+		})
+	}
+
+	for _, prog := range [][]byte{
+		{0x00, 0x14},                         // truncated
+		{0x51, 0x52},                         // no CHECKPREDICATE
+		{0x74, 0x01, 0xae, 0x51, 0xc0},       // 1 CHECKPREDICATE
+		{0x93, 0x74, 0x01, 0xae, 0x00, 0xc0}, // ADD before DEPTH
+	} {
+		if _, err := SplitInstance(prog); err == nil {
+			t.Errorf("SplitInstance(%x) succeeded", prog)
+		}
+	}
+}
+
+const relock = `
+contract Relock(owner: PublicKey, count: Integer, active: Boolean, note: String) locks value of asset {
+  clause split(sig: Signature, a: Amount) {
+    verify checkTxSig(owner, sig)
+    verify active
+    lock a of asset with Relock(owner, count, active, note)
+    unlock value of asset
+  }
+}
+`
+
+func TestParseInstance(t *testing.T) {
+	key := chainjson.HexBytes(bytes.Repeat([]byte{0xaa}, 32))
+	note := chainjson.HexBytes("memo")
+	count, yes := int64(-7), true
+	args := []ContractArg{{S: &key}, {I: &count}, {B: &yes}, {S: &note}}
+
+	relock := mustCompile(t, relock)
+	if !relock.Recursive {
+		t.Fatal("Relock is not recursive")
+	}
+	lock := mustCompile(t, lockWithDeadline)
+
+	prog, err := Instantiate(relock.Body, relock.Params, relock.Recursive, args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ParseInstance(relock, prog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 4 || !bytes.Equal(*got["owner"].S, key) || *got["count"].I != count || !*got["active"].B || string(*got["note"].S) != "memo" {
+		t.Errorf("got args %+v", got)
+	}
+
+	if _, err := ParseInstance(lock, prog); err != ErrBodyMismatch {
+		t.Errorf("got error %v for another contract, want ErrBodyMismatch", err)
+	}
+	if _, err := ParseInstance(relock, []byte{0x51}); errors.Root(err) != ErrNotInstance {
+		t.Errorf("got error %v for a non-instance, want ErrNotInstance", err)
+	}
+
+	// the right body in the non-recursive layout
+	prog, err = Instantiate(relock.Body, relock.Params, false, args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseInstance(relock, prog); errors.Root(err) != ErrNotInstance {
+		t.Errorf("got error %v for the wrong layout, want ErrNotInstance", err)
+	}
+
+	// a boolean pushed as 2
+	two := int64(2)
+	prog, err = Instantiate(relock.Body, []*Param{relock.Params[0], relock.Params[1], {Name: "active", Type: intType}, relock.Params[3]}, true, []ContractArg{args[0], args[1], {I: &two}, args[3]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseInstance(relock, prog); err == nil {
+		t.Error("got no error for a bad boolean")
+	}
+}
@@ -1,64 +1,198 @@
 package compiler
 
-import "strings"
-
-var optimizations = []struct {
-	before, after string
-}{
-	{"0 ROLL", ""},
-	{"0 PICK", "DUP"},
-	{"1 ROLL", "SWAP"},
-	{"1 PICK", "OVER"},
-	{"2 ROLL", "ROT"},
-	{"TRUE VERIFY", ""},
-	{"SWAP SWAP", ""},
-	{"OVER OVER", "2DUP"},
-	{"SWAP OVER", "TUCK"},
-	{"DROP DROP", "2DROP"},
-	{"SWAP DROP", "NIP"},
-	{"5 ROLL 5 ROLL", "2ROT"},
-	{"3 PICK 3 PICK", "2OVER"},
-	{"3 ROLL 3 ROLL", "2SWAP"},
-	{"2 PICK 2 PICK 2 PICK", "3DUP"},
-	{"1 ADD", "1ADD"},
-	{"1 SUB", "1SUB"},
-	{"EQUAL VERIFY", "EQUALVERIFY"},
-	{"SWAP TXSIGHASH ROT", "TXSIGHASH SWAP"},
-	{"SWAP EQUAL", "EQUAL"},
-	{"SWAP EQUALVERIFY", "EQUALVERIFY"},
-	{"SWAP ADD", "ADD"},
-	{"SWAP BOOLAND", "BOOLAND"},
-	{"SWAP BOOLOR", "BOOLOR"},
-	{"SWAP MIN", "MIN"},
-	{"SWAP MAX", "MAX"},
-	{"DUP 2 PICK EQUAL", "2DUP EQUAL"},
-	{"DUP 2 PICK EQUALVERIFY", "2DUP EQUALVERIFY"},
-	{"DUP 2 PICK ADD", "2DUP ADD"},
-	{"DUP 2 PICK BOOLAND", "2DUP BOOLAND"},
-	{"DUP 2 PICK BOOLOR", "2DUP BOOLOR"},
-	{"DUP 2 PICK MIN", "2DUP MIN"},
-	{"DUP 2 PICK MAX", "2DUP MAX"},
+import (
+	"strconv"
+	"strings"
+)
+
+// instruction is an instruction of a contract body being optimized,
+// or a label marking a jump target.
+type instruction struct {
+	// op is the instruction as it is assembled, such as "ROLL", "5",
+	// "0x00ab" or "JUMPIF:$label", or the label, such as "$label".
+	op string
+
+	// origin is where the instruction came from, such as the index of
+	// the builder item that produced it.
+	origin int
+
+	// depth is the depth of the stack before the instruction, as far
+	// as the compiler knows it, or -1 if it is not known.
+	depth int
+}
+
+func (x instruction) isLabel() bool {
+	return strings.HasPrefix(x.op, "$")
+}
+
+// peephole is a rewrite of a sequence of instructions into a shorter
+// one with the same effect. The rewrite must have fewer instructions,
+// which bounds how often optimize applies rules.
+type peephole struct {
+	before, after []string
+
+	// reads is how many items at the top of the stack before and after
+	// read or move. Neither touches the items below them, and they
+	// leave the same items in their place. A rule applies only where
+	// the stack is known to be at least this deep.
+	reads int
+}
+
+func rule(before, after string, reads int) peephole {
+	return peephole{before: strings.Fields(before), after: strings.Fields(after), reads: reads}
+}
+
+var peepholes = []peephole{
+	rule("0 ROLL", "", 1),
+	rule("0 PICK", "DUP", 1),
+	rule("1 ROLL", "SWAP", 2),
+	rule("1 PICK", "OVER", 2),
+	rule("2 ROLL", "ROT", 3),
+	rule("TRUE VERIFY", "", 0),
+	rule("SWAP SWAP", "", 2),
+	rule("OVER OVER", "2DUP", 2),
+	rule("SWAP OVER", "TUCK", 2),
+	rule("DROP DROP", "2DROP", 2),
+	rule("SWAP DROP", "NIP", 2),
+	rule("5 ROLL 5 ROLL", "2ROT", 6),
+	rule("3 PICK 3 PICK", "2OVER", 4),
+	rule("3 ROLL 3 ROLL", "2SWAP", 4),
+	rule("2 PICK 2 PICK 2 PICK", "3DUP", 3),
+	rule("1 ADD", "1ADD", 1),
+	rule("1 SUB", "1SUB", 1),
+	rule("EQUAL VERIFY", "EQUALVERIFY", 2),
+	rule("SWAP TXSIGHASH ROT", "TXSIGHASH SWAP", 2),
+	rule("SWAP EQUAL", "EQUAL", 2),
+	rule("SWAP EQUALVERIFY", "EQUALVERIFY", 2),
+	rule("SWAP ADD", "ADD", 2),
+	rule("SWAP BOOLAND", "BOOLAND", 2),
+	rule("SWAP BOOLOR", "BOOLOR", 2),
+	rule("SWAP MIN", "MIN", 2),
+	rule("SWAP MAX", "MAX", 2),
+	rule("DUP 2 PICK EQUAL", "2DUP EQUAL", 2),
+	rule("DUP 2 PICK EQUALVERIFY", "2DUP EQUALVERIFY", 2),
+	rule("DUP 2 PICK ADD", "2DUP ADD", 2),
+	rule("DUP 2 PICK BOOLAND", "2DUP BOOLAND", 2),
+	rule("DUP 2 PICK BOOLOR", "2DUP BOOLOR", 2),
+	rule("DUP 2 PICK MIN", "2DUP MIN", 2),
+	rule("DUP 2 PICK MAX", "2DUP MAX", 2),
+}
+
+// optimize applies the peephole rules to insts in one pass from first
+// to last. At each instruction, the first rule that matches there is
+// applied, and the pass steps back over as many instructions as a rule
+// may match, less one, so that the rules are tried again only where
+// the rewrite may have made one match. A match never spans a label, so
+// code that is jumped into is rewritten only after the jump target.
+// The instructions that replace a match take the origin of the last
+// instruction matched, after which the stack is the same.
+func optimize(insts []instruction) []instruction {
+	var longest int
+	for _, r := range peepholes {
+		if len(r.before) > longest {
+			longest = len(r.before)
+		}
+	}
+
+	// Each rule replaces a match with fewer instructions, which are
+	// written over its end, so the instructions yet to be checked are
+	// always in[i:], behind the len(result) <= i already passed.
+	in := append([]instruction(nil), insts...)
+	var result []instruction
+	for i := 0; i < len(in); {
+		r, ok := matchPeephole(in[i:])
+		if !ok {
+			result = append(result, in[i])
+			i++
+			continue
+		}
+		end := i + len(r.before)
+		depth := in[i].depth
+		origin := in[end-1].origin
+		i = end - len(r.after)
+		for j, op := range r.after {
+			in[i+j] = instruction{op: op, origin: origin, depth: depth}
+			depth = nextDepth(depth, op)
+		}
+		for n := 1; n < longest && len(result) > 0; n++ {
+			i--
+			in[i] = result[len(result)-1]
+			result = result[:len(result)-1]
+		}
+	}
+	return result
+}
+
+// matchPeephole returns the first rule that matches at the start of
+// insts.
+func matchPeephole(insts []instruction) (peephole, bool) {
+	for _, r := range peepholes {
+		if r.matches(insts) {
+			return r, true
+		}
+	}
+	return peephole{}, false
 }
 
-func optimize(opcodes string) string {
-	opcodes = " " + opcodes + " "
-	looping := true
-	for looping {
-		looping = false
-		for _, o := range optimizations {
-			before := " " + o.before + " "
-			var after string
-			if o.after == "" {
-				after = " "
-			} else {
-				after = " " + o.after + " "
-			}
-			newOpcodes := strings.Replace(opcodes, before, after, -1)
-			if newOpcodes != opcodes {
-				looping = true
-				opcodes = newOpcodes
-			}
+// matches tells whether insts begins with r.before, at a point where
+// the stack is deep enough for r.
+func (r peephole) matches(insts []instruction) bool {
+	if len(insts) < len(r.before) || (r.reads > 0 && insts[0].depth < r.reads) {
+		return false
+	}
+	for i, op := range r.before {
+		if insts[i].isLabel() || insts[i].op != op {
+			return false
 		}
 	}
-	return strings.TrimSpace(opcodes)
+	return true
+}
+
+// nextDepth returns the depth of the stack after op, given the depth
+// before it, or -1 if it is not known.
+func nextDepth(depth int, op string) int {
+	n, ok := stackEffect(op)
+	if depth < 0 || !ok {
+		return -1
+	}
+	return depth + n
+}
+
+// stackEffect returns the change in the depth of the stack that op
+// makes, if it does not depend on what is on the stack.
+func stackEffect(op string) (int, bool) {
+	if strings.HasPrefix(op, "$") {
+		return 0, true
+	}
+	if strings.HasPrefix(op, "JUMPIF:") {
+		return -1, true
+	}
+	if strings.HasPrefix(op, "JUMP:") {
+		return 0, true
+	}
+	if _, err := strconv.ParseInt(op, 10, 64); err == nil || strings.HasPrefix(op, "0x") {
+		return 1, true
+	}
+	n, ok := stackEffects[op]
+	return n, ok
+}
+
+var stackEffects = map[string]int{
+	"TRUE": 1, "FALSE": 1, "DEPTH": 1, "TXSIGHASH": 1,
+	"AMOUNT": 1, "ASSET": 1, "PROGRAM": 1, "INDEX": 1, "ENTRYID": 1, "OUTPUTID": 1, "BLOCKHEIGHT": 1,
+
+	"DUP": 1, "2DUP": 2, "3DUP": 3, "OVER": 1, "2OVER": 2, "TUCK": 1, "SIZE": 1,
+	"SWAP": 0, "ROT": 0, "2ROT": 0, "2SWAP": 0, "PICK": 0, "NOP": 0,
+	"DROP": -1, "2DROP": -2, "NIP": -1, "ROLL": -1, "VERIFY": -1,
+	"TOALTSTACK": -1, "FROMALTSTACK": 1,
+
+	"NOT": 0, "0NOTEQUAL": 0, "NEGATE": 0, "ABS": 0, "1ADD": 0, "1SUB": 0, "2MUL": 0, "2DIV": 0,
+	"INVERT": 0, "SHA3": 0, "SHA256": 0,
+
+	"ADD": -1, "SUB": -1, "MUL": -1, "DIV": -1, "MOD": -1, "LSHIFT": -1, "RSHIFT": -1,
+	"BOOLAND": -1, "BOOLOR": -1, "MIN": -1, "MAX": -1,
+	"NUMEQUAL": -1, "NUMNOTEQUAL": -1, "LESSTHAN": -1, "GREATERTHAN": -1, "LESSTHANOREQUAL": -1, "GREATERTHANOREQUAL": -1,
+	"EQUAL": -1, "AND": -1, "OR": -1, "XOR": -1, "CAT": -1, "CATPUSHDATA": -1,
+	"EQUALVERIFY": -2, "NUMEQUALVERIFY": -2, "WITHIN": -2, "CHECKSIG": -2,
+	"CHECKOUTPUT": -4,
 }
@@ -0,0 +1,228 @@
+package compiler
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// symbolic runs ops on a stack of reads named items and returns the
+// resulting stack and the conditions verified, with the operands of
+// commutative operations in order so that equal results look equal.
+// It fails if ops reach below the items.
+func symbolic(ops []string, reads int) (stk, verified []string, err error) {
+	for i := 0; i < reads; i++ {
+		stk = append(stk, fmt.Sprintf("x%d", i))
+	}
+	pop := func() string {
+		if len(stk) == 0 {
+			err = fmt.Errorf("stack underflow")
+			return ""
+		}
+		x := stk[len(stk)-1]
+		stk = stk[:len(stk)-1]
+		return x
+	}
+	push := func(xs ...string) { stk = append(stk, xs...) }
+	at := func(n int) string {
+		if n < 0 || n >= len(stk) {
+			err = fmt.Errorf("stack underflow")
+			return ""
+		}
+		return stk[len(stk)-1-n]
+	}
+	roll := func(n int) {
+		x := at(n)
+		if err == nil {
+			stk = append(stk[:len(stk)-1-n], stk[len(stk)-n:]...)
+			push(x)
+		}
+	}
+	apply := func(name string, commutative bool) {
+		b, a := pop(), pop()
+		if commutative && b < a {
+			a, b = b, a
+		}
+		push(name + "(" + a + "," + b + ")")
+	}
+	verify := func(x string) {
+		if x != "1" {
+			verified = append(verified, x)
+		}
+	}
+	number := func() int {
+		n, _ := strconv.Atoi(pop())
+		return n
+	}
+
+	for _, op := range ops {
+		switch op {
+		case "TRUE":
+			push("1")
+		case "TXSIGHASH":
+			push("TXSIGHASH")
+		case "DUP":
+			push(at(0))
+		case "2DUP":
+			push(at(1), at(0))
+		case "3DUP":
+			push(at(2), at(1), at(0))
+		case "OVER":
+			push(at(1))
+		case "2OVER":
+			push(at(3), at(2))
+		case "PICK":
+			push(at(number()))
+		case "ROLL":
+			roll(number())
+		case "SWAP":
+			roll(1)
+		case "ROT":
+			roll(2)
+		case "2SWAP":
+			roll(3)
+			roll(3)
+		case "2ROT":
+			roll(5)
+			roll(5)
+		case "TUCK":
+			b, a := pop(), pop()
+			push(b, a, b)
+		case "DROP":
+			pop()
+		case "2DROP":
+			pop()
+			pop()
+		case "NIP":
+			b := pop()
+			pop()
+			push(b)
+		case "VERIFY":
+			verify(pop())
+		case "1ADD":
+			push("1")
+			apply("ADD", true)
+		case "1SUB":
+			push("1")
+			apply("SUB", false)
+		case "ADD", "EQUAL", "BOOLAND", "BOOLOR", "MIN", "MAX":
+			apply(op, true)
+		case "SUB":
+			apply(op, false)
+		case "EQUALVERIFY":
+			apply("EQUAL", true)
+			verify(pop())
+		default:
+			if _, convErr := strconv.Atoi(op); convErr != nil {
+				return nil, nil, fmt.Errorf("unknown op %s", op)
+			}
+			push(op)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return stk, verified, nil
+}
+
+func TestPeepholes(t *testing.T) {
+	for _, r := range peepholes {
+		name := strings.Join(r.before, " ")
+		if len(r.after) >= len(r.before) {
+			t.Errorf("%s: got %d instructions after, want fewer than %d", name, len(r.after), len(r.before))
+		}
+		stk1, verified1, err := symbolic(r.before, r.reads)
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		stk2, verified2, err := symbolic(r.after, r.reads)
+		if err != nil {
+			t.Errorf("%s: after: %s", name, err)
+			continue
+		}
+		if fmt.Sprint(stk1, verified1) != fmt.Sprint(stk2, verified2) {
+			t.Errorf("%s: got stack %v verifying %v, want %v verifying %v", name, stk2, verified2, stk1, verified1)
+		}
+
+		var effect1, effect2 int
+		for _, op := range r.before {
+			n, ok := stackEffect(op)
+			if !ok {
+				t.Errorf("%s: no stack effect for %s", name, op)
+			}
+			effect1 += n
+		}
+		for _, op := range r.after {
+			n, ok := stackEffect(op)
+			if !ok {
+				t.Errorf("%s: no stack effect for %s", name, op)
+			}
+			effect2 += n
+		}
+		if effect1 != effect2 || effect1 != len(stk1)-r.reads {
+			t.Errorf("%s: got stack effects %d and %d, want %d", name, effect1, effect2, len(stk1)-r.reads)
+		}
+	}
+}
+
+func TestOptimize(t *testing.T) {
+	insts := func(depth int, ops string) []instruction {
+		var result []instruction
+		for _, op := range strings.Fields(ops) {
+			result = append(result, instruction{op: op, depth: depth})
+			depth = nextDepth(depth, op)
+		}
+		return result
+	}
+	opsOf := func(insts []instruction) string {
+		var ops []string
+		for _, x := range insts {
+			ops = append(ops, x.op)
+		}
+		return strings.Join(ops, " ")
+	}
+	cases := []struct {
+		depth     int
+		ops, want string
+	}{
+		{4, "3 PICK 3 PICK EQUAL", "2OVER EQUAL"},
+		{4, "1 ROLL 1 ROLL DROP DROP", "2DROP"},
+		{3, "DUP 2 PICK EQUAL VERIFY", "2DUP EQUALVERIFY"},
+
+		// A rewrite may make a rule match where the pass has been.
+		{2, "SWAP 1 ROLL DROP", "DROP"},
+		{3, "SWAP TXSIGHASH 2 ROLL", "TXSIGHASH SWAP"},
+		{3, "SWAP SWAP SWAP SWAP SWAP", "SWAP"},
+		{6, "DUP 0 ROLL 1 PICK 1 PICK EQUAL", "DUP 2DUP EQUAL"},
+
+		// Rewrites need the stack to be known to be deep enough.
+		{1, "3 PICK 3 PICK EQUAL", "3 PICK 3 PICK EQUAL"},
+		{-1, "SWAP DROP", "SWAP DROP"},
+
+		// Nor do they span a label.
+		{2, "SWAP $label SWAP", "SWAP $label SWAP"},
+		{2, "TRUE $label VERIFY", "TRUE $label VERIFY"},
+	}
+	for _, c := range cases {
+		got := opsOf(optimize(insts(c.depth, c.ops)))
+		if got != c.want {
+			t.Errorf("optimize(%q) at depth %d = %q, want %q", c.ops, c.depth, got, c.want)
+		}
+	}
+}
+
+func TestStackEffects(t *testing.T) {
+	var ops []string
+	for op := range stackEffects {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	for _, op := range ops {
+		if stk, _, err := symbolic([]string{op}, 6); err == nil && len(stk)-6 != stackEffects[op] {
+			t.Errorf("stack effect of %s is %d, want %d", op, stackEffects[op], len(stk)-6)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package compiler
+
+import "fmt"
+
+// OptLevel is how hard the compiler works to make programs small.
+type OptLevel int
+
+const (
+	// OptNone compiles each statement and expression as it is
+	// written.
+	OptNone OptLevel = iota - 1
+
+	// OptDefault evaluates expressions of literals at compile time,
+	// shares subexpressions where that makes a clause smaller,
+	// rewrites short sequences of instructions, merges code that ends
+	// two clauses or branches the same way, and selects the clause of
+	// a spend the way that uses the least gas.
+	OptDefault
+
+	// OptSize also searches each clause for a shorter schedule of
+	// stack operations: evaluating the operands of commutative
+	// operators the other way round, setting parameters aside on the
+	// alt stack until they are needed, and dropping those a clause
+	// does not use. It leaves the arguments a program takes as they
+	// are. It evaluates an expression of literals at compile time
+	// only where its value is no larger than the code that evaluates
+	// it, and selects the clause of a spend the way that makes the
+	// program smallest.
+	OptSize
+)
+
+// The optimization passes, as Options.Passes names them.
+const (
+	PassFold     = "fold"
+	PassShare    = "share"
+	PassSchedule = "schedule"
+	PassPeephole = "peephole"
+	PassDispatch = "dispatch"
+	PassMerge    = "merge"
+)
+
+// passes are the optimization passes each level runs.
+var passes = map[OptLevel][]string{
+	OptNone:    nil,
+	OptDefault: {PassFold, PassShare, PassPeephole, PassDispatch, PassMerge},
+	OptSize:    {PassFold, PassShare, PassSchedule, PassPeephole, PassDispatch, PassMerge},
+}
+
+// Options are the options of CompileWithOptions. The zero value
+// compiles as Compile does.
+type Options struct {
+	// Optimize is the optimization level.
+	Optimize OptLevel
+
+	// Passes, if not nil, are the optimization passes to run in place
+	// of those of the level.
+	Passes []string
+
+	// Resolve, if not nil, finds the files that import directives
+	// name. By default they are files relative to the working
+	// directory.
+	Resolve ImportResolver
+
+	// VMVersion is the version of the virtual machine the contracts
+	// run on, and the version of the programs they lock value with.
+	// Zero means version 1, the only version there is.
+	VMVersion uint64
+
+	// WarningsAsErrors fails a compilation that has warnings, as if
+	// they were errors.
+	WarningsAsErrors bool
+
+	// OmitSteps and OmitSourceMap leave out the Steps and SourceMap
+	// of each contract, which only debuggers and editors need.
+	OmitSteps, OmitSourceMap bool
+}
+
+// check returns an error if opts are not valid.
+func (opts Options) check() error {
+	if _, ok := passes[opts.Optimize]; !ok {
+		return fmt.Errorf("unknown optimization level %d", opts.Optimize)
+	}
+	for _, pass := range opts.Passes {
+		if !contains(passes[OptSize], pass) {
+			return fmt.Errorf("unknown optimization pass \"%s\"", pass)
+		}
+	}
+	if opts.VMVersion > 1 {
+		return fmt.Errorf("unsupported VM version %d, want 1", opts.VMVersion)
+	}
+	return nil
+}
+
+// enabled tells whether opts run the optimization pass.
+func (opts Options) enabled(pass string) bool {
+	if opts.Passes != nil {
+		return contains(opts.Passes, pass)
+	}
+	return contains(passes[opts.Optimize], pass)
+}
+
+// vmVersion returns the version of the virtual machine the contracts
+// run on.
+func (opts Options) vmVersion() int64 {
+	if opts.VMVersion == 0 {
+		return 1
+	}
+	return int64(opts.VMVersion)
+}
+
+func contains(strs []string, str string) bool {
+	for _, s := range strs {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
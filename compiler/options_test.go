@@ -0,0 +1,117 @@
+package compiler
+
+import (
+	"strings"
+	"testing"
+)
+
+const sum = `
+contract Sum(x: Integer) locks value of asset {
+  clause spend(y: Integer) {
+    verify y == x + (1 + 2)
+    unlock value of asset
+  }
+}
+`
+
+func TestCompileWithOptions(t *testing.T) {
+	compile := func(opts Options) *Contract {
+		contracts, err := CompileWithOptions(strings.NewReader(sum), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return contracts[0]
+	}
+	cases := []struct {
+		opts Options
+		want string
+	}{
+		{Options{}, "3 ADD EQUAL"},
+		{Options{Optimize: OptNone}, "SWAP SWAP 1 2 ADD ADD EQUAL"},
+		{Options{Passes: []string{}}, "SWAP SWAP 1 2 ADD ADD EQUAL"},
+		{Options{Optimize: OptNone, Passes: []string{PassFold}}, "SWAP SWAP 3 ADD EQUAL"},
+		{Options{Passes: []string{PassPeephole}}, "1 2 ADD ADD EQUAL"},
+	}
+	for _, c := range cases {
+		if got := compile(c.opts).Opcodes; got != c.want {
+			t.Errorf("%+v: got %s, want %s", c.opts, got, c.want)
+		}
+	}
+
+	contract := compile(Options{OmitSteps: true, OmitSourceMap: true})
+	if contract.Steps != nil || contract.SourceMap != nil || contract.StepAt(0) != nil {
+		t.Errorf("got steps %v and source map %v", contract.Steps, contract.SourceMap)
+	}
+	contract = compile(Options{})
+	if contract.Steps == nil || contract.SourceMap == nil || contract.StepAt(0) == nil {
+		t.Error("got no steps or source map")
+	}
+}
+
+func TestCompileOptionErrors(t *testing.T) {
+	cases := []struct {
+		opts Options
+		want string
+	}{
+		{Options{Optimize: OptSize + 1}, "unknown optimization level"},
+		{Options{Passes: []string{"inline"}}, "unknown optimization pass \"inline\""},
+		{Options{VMVersion: 2}, "unsupported VM version 2"},
+	}
+	for _, c := range cases {
+		_, err := CompileWithOptions(strings.NewReader(sum), c.opts)
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%+v: got error %v, want %q", c.opts, err, c.want)
+		}
+	}
+}
+
+func TestWarningsAsErrors(t *testing.T) {
+	data := "s"
+	for i := 0; i < 6; i++ {
+		data = "concat(" + data + ", 0x" + strings.Repeat("ab", 30000) + ")"
+	}
+	big := `
+contract Big(hash: Hash) locks value of asset {
+  clause spend(s: String) {
+    verify sha3(` + data + `) == hash
+    unlock value of asset
+  }
+}
+`
+	if _, err := CompileWithOptions(strings.NewReader(big), Options{}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := CompileWithOptions(strings.NewReader(big), Options{WarningsAsErrors: true})
+	diags, ok := err.(Diagnostics)
+	if !ok || len(diags) != 2 {
+		t.Fatalf("got error %v, want 2 diagnostics", err)
+	}
+	for _, d := range diags {
+		if d.Severity != SeverityError || d.Code != CodeNearLimit {
+			t.Errorf("got diagnostic %+v", d)
+		}
+	}
+}
+
+func TestDirResolver(t *testing.T) {
+	const src = `
+import "./FixedLimitProfit"
+
+contract Collect(bill: Asset, banker: Program, key: PublicKey) locks value of asset {
+  clause spend() {
+    lock value of asset with FixedLimitProfit(bill, 1, 1, 1, 1, banker, key)
+  }
+}
+`
+	_, err := CompileWithOptions(strings.NewReader(src), Options{})
+	if err == nil || !strings.Contains(err.Error(), "Check absolute path error") {
+		t.Errorf("got error %v, want the import not to be found", err)
+	}
+	contracts, err := CompileWithOptions(strings.NewReader(src), Options{Resolve: DirResolver("nowhere", "equitytest")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(contracts) != 2 || contracts[0].Name != "FixedLimitProfit" {
+		t.Errorf("got %d contracts, want FixedLimitProfit imported", len(contracts))
+	}
+}
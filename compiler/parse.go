@@ -20,44 +20,146 @@ import (
 //   parseX    takes *parser, returns AST node, updates parser position
 
 type parser struct {
-	buf []byte
-	pos int
+	src     *source
+	buf     []byte
+	pos     int
+	errs    Diagnostics
+	tests   []*Test
+	resolve ImportResolver
 }
 
 func (p *parser) errorf(format string, args ...interface{}) {
-	panic(parserErr{buf: p.buf, offset: p.pos, format: format, args: args})
+	p.errorCodef(CodeSyntax, format, args...)
 }
 
-// parse is the main entry point to the parser
-func parse(buf []byte) (contracts []*Contract, err error) {
+func (p *parser) errorCodef(code string, format string, args ...interface{}) {
+	panic(parserErr{buf: p.buf, offset: p.pos, code: code, format: format, args: args})
+}
+
+// start returns the offset at which the next token begins.
+func (p *parser) start() int {
+	return skipWsAndComments(p.buf, p.pos)
+}
+
+// spanFrom returns the span from start to the current position.
+func (p *parser) spanFrom(start int) span {
+	return span{src: p.src, start: start, end: p.pos}
+}
+
+// atEnd tells whether only whitespace and comments remain.
+func (p *parser) atEnd() bool {
+	return p.start() >= len(p.buf)
+}
+
+// addError records a syntax error raised with panic. A second error at
+// the same place as the previous one adds nothing and is dropped.
+func (p *parser) addError(val interface{}) {
+	var d *Diagnostic
+	switch e := val.(type) {
+	case parserErr:
+		d = e.diagnostic(p.src)
+	case *Diagnostic:
+		d = e
+	case Diagnostics:
+		// errors in an imported file
+		p.errs = append(p.errs, e...)
+		return
+	default:
+		panic(val)
+	}
+	if n := len(p.errs); n > 0 {
+		last := p.errs[n-1]
+		if last.File == d.File && last.Line == d.Line && last.Col == d.Col {
+			return
+		}
+	}
+	p.errs = append(p.errs, d)
+}
+
+// parse is the main entry point to the parser. The name is used only
+// for reporting errors, and resolve finds the files that import
+// directives name, or if nil, resolveFile does. Test blocks are
+// returned apart from the contracts.
+//
+// The parser recovers from syntax errors at statement, clause and
+// contract boundaries, so err may list several problems. The
+// contracts parsed around them are returned too, with the ones
+// (and clauses) that were affected marked incomplete.
+func parse(buf []byte, name string, resolve ImportResolver) (contracts []*Contract, tests []*Test, err error) {
+	if resolve == nil {
+		resolve = resolveFile
+	}
+	p := &parser{src: &source{name: name, buf: buf}, buf: buf, resolve: resolve}
 	defer func() {
 		if val := recover(); val != nil {
-			if e, ok := val.(parserErr); ok {
-				err = e
-			} else {
-				panic(val)
-			}
+			p.addError(val)
+		}
+		if len(p.errs) > 0 {
+			err = p.errs
 		}
 	}()
-	p := &parser{buf: buf}
 	contracts = parseContracts(p)
+	tests = p.tests
 	return
 }
 
+// try calls f. If f raises a syntax error, try records it, skips ahead
+// to the next token at which parsing can resume (one of the sync
+// keywords, or a "}" closing the enclosing block) and returns false.
+func try(p *parser, sync []string, f func()) (ok bool) {
+	start := p.start()
+	defer func() {
+		if val := recover(); val != nil {
+			p.addError(val)
+			if p.pos <= start {
+				// make progress past the offending token
+				p.pos = start
+				skipToken(p)
+			}
+			skipTo(p, sync)
+			ok = false
+		}
+	}()
+	f()
+	return true
+}
+
+var (
+	statementSync = []string{"verify", "lock", "unlock", "define", "assign", "if", "clause", "contract"}
+	clauseSync    = []string{"clause", "contract", "test"}
+	contractSync  = []string{"contract", "test"}
+)
+
 // parse contracts
 func parseContracts(p *parser) []*Contract {
 	var result []*Contract
-	contracts := parseImportDirectives(p)
-	for _, c := range contracts {
-		result = append(result, c)
-	}
+	try(p, contractSync, func() {
+		contracts := parseImportDirectives(p)
+		for _, c := range contracts {
+			result = append(result, c)
+		}
 
-	if pos := scanKeyword(p.buf, p.pos, "contract"); pos < 0 {
-		p.errorf("expected contract")
-	}
-	for peekKeyword(p) == "contract" {
-		contract := parseContract(p)
-		result = append(result, contract)
+		if kw := peekKeyword(p); kw != "contract" && kw != "test" {
+			p.errorf("expected contract")
+		}
+	})
+	for {
+		if peekKeyword(p) == "test" {
+			var t *Test
+			if try(p, contractSync, func() { t = parseTest(p) }) {
+				p.tests = append(p.tests, t)
+			}
+			continue
+		}
+		if peekKeyword(p) != "contract" {
+			break
+		}
+		var contract *Contract
+		n := len(p.errs)
+		if try(p, contractSync, func() { contract = parseContract(p) }) {
+			contract.incomplete = len(p.errs) > n
+			result = append(result, contract)
+		}
 	}
 	return result
 }
@@ -65,18 +167,24 @@ func parseContracts(p *parser) []*Contract {
 // contract name(p1, p2: t1, p3: t2) locks value { ... }
 func parseContract(p *parser) *Contract {
 	consumeKeyword(p, "contract")
+	start := p.start()
 	name := consumeIdentifier(p)
+	nameSpan := p.spanFrom(start)
 	params := parseParams(p)
 	// locks amount of asset
 	consumeKeyword(p, "locks")
 	value := ValueInfo{}
+	start = p.start()
 	value.Amount = consumeIdentifier(p)
+	amountSpan := p.spanFrom(start)
 	consumeKeyword(p, "of")
+	start = p.start()
 	value.Asset = consumeIdentifier(p)
+	assetSpan := p.spanFrom(start)
 	consumeTok(p, "{")
 	clauses := parseClauses(p)
-	consumeTok(p, "}")
-	return &Contract{Name: name, Params: params, Clauses: clauses, Value: value}
+	try(p, contractSync, func() { consumeTok(p, "}") })
+	return &Contract{Name: name, Params: params, Clauses: clauses, Value: value, span: nameSpan, amountSpan: amountSpan, assetSpan: assetSpan}
 }
 
 // (p1, p2: t1, p3: t2)
@@ -99,20 +207,27 @@ func parseParams(p *parser) []*Param {
 
 func parseClauses(p *parser) []*Clause {
 	var clauses []*Clause
-	for !peekTok(p, "}") {
-		c := parseClause(p)
-		clauses = append(clauses, c)
+	for !peekTok(p, "}") && !p.atEnd() && !peekSync(p, contractSync) {
+		var c *Clause
+		n := len(p.errs)
+		if try(p, clauseSync, func() { c = parseClause(p) }) {
+			c.incomplete = len(p.errs) > n
+			clauses = append(clauses, c)
+		}
 	}
 	return clauses
 }
 
 func parseParamsType(p *parser) []*Param {
-	firstName := consumeIdentifier(p)
-	params := []*Param{&Param{Name: firstName}}
-	for peekTok(p, ",") {
-		consumeTok(p, ",")
+	var params []*Param
+	for {
+		start := p.start()
 		name := consumeIdentifier(p)
-		params = append(params, &Param{Name: name})
+		params = append(params, &Param{Name: name, span: p.spanFrom(start)})
+		if !peekTok(p, ",") {
+			break
+		}
+		consumeTok(p, ",")
 	}
 	consumeTok(p, ":")
 	typ := consumeIdentifier(p)
@@ -129,7 +244,9 @@ func parseParamsType(p *parser) []*Param {
 func parseClause(p *parser) *Clause {
 	var c Clause
 	consumeKeyword(p, "clause")
+	start := p.start()
 	c.Name = consumeIdentifier(p)
+	c.span = p.spanFrom(start)
 	c.Params = parseParams(p)
 	consumeTok(p, "{")
 	c.statements = parseStatements(p)
@@ -139,9 +256,11 @@ func parseClause(p *parser) *Clause {
 
 func parseStatements(p *parser) []statement {
 	var statements []statement
-	for !peekTok(p, "}") {
-		s := parseStatement(p)
-		statements = append(statements, s)
+	for !peekTok(p, "}") && !p.atEnd() && !peekSync(p, clauseSync) {
+		var s statement
+		if try(p, statementSync, func() { s = parseStatement(p) }) {
+			statements = append(statements, s)
+		}
 	}
 	return statements
 }
@@ -165,6 +284,7 @@ func parseStatement(p *parser) statement {
 }
 
 func parseIfStmt(p *parser) *ifStatement {
+	start := p.start()
 	consumeKeyword(p, "if")
 	condition := parseExpr(p)
 	body := &IfStatmentBody{}
@@ -177,14 +297,17 @@ func parseIfStmt(p *parser) *ifStatement {
 		body.falseBody = parseStatements(p)
 		consumeTok(p, "}")
 	}
-	return &ifStatement{condition: condition, body: body}
+	return &ifStatement{condition: condition, body: body, span: p.spanFrom(start)}
 }
 
 func parseDefineStmt(p *parser) *defineStatement {
 	defineStat := &defineStatement{}
+	start := p.start()
 	consumeKeyword(p, "define")
 	param := &Param{}
+	nameStart := p.start()
 	param.Name = consumeIdentifier(p)
+	param.span = p.spanFrom(nameStart)
 	consumeTok(p, ":")
 	variableType := consumeIdentifier(p)
 	if tdesc, ok := types[variableType]; ok {
@@ -197,39 +320,46 @@ func parseDefineStmt(p *parser) *defineStatement {
 		consumeTok(p, "=")
 		defineStat.expr = parseExpr(p)
 	}
+	defineStat.span = p.spanFrom(start)
 	return defineStat
 }
 
 func parseAssignStmt(p *parser) *assignStatement {
+	start := p.start()
 	consumeKeyword(p, "assign")
+	nameStart := p.start()
 	varName := consumeIdentifier(p)
+	variable := &Param{Name: varName, span: p.spanFrom(nameStart)}
 	consumeTok(p, "=")
 	expr := parseExpr(p)
-	return &assignStatement{variable: &Param{Name: varName}, expr: expr}
+	return &assignStatement{variable: variable, expr: expr, span: p.spanFrom(start)}
 }
 
 func parseVerifyStmt(p *parser) *verifyStatement {
+	start := p.start()
 	consumeKeyword(p, "verify")
 	expr := parseExpr(p)
-	return &verifyStatement{expr: expr}
+	return &verifyStatement{expr: expr, span: p.spanFrom(start)}
 }
 
 func parseLockStmt(p *parser) *lockStatement {
+	start := p.start()
 	consumeKeyword(p, "lock")
 	lockedAmount := parseExpr(p)
 	consumeKeyword(p, "of")
 	lockedAsset := parseExpr(p)
 	consumeKeyword(p, "with")
 	program := parseExpr(p)
-	return &lockStatement{lockedAmount: lockedAmount, lockedAsset: lockedAsset, program: program}
+	return &lockStatement{lockedAmount: lockedAmount, lockedAsset: lockedAsset, program: program, span: p.spanFrom(start)}
 }
 
 func parseUnlockStmt(p *parser) *unlockStatement {
+	start := p.start()
 	consumeKeyword(p, "unlock")
 	unlockedAmount := parseExpr(p)
 	consumeKeyword(p, "of")
 	unlockedAsset := parseExpr(p)
-	return &unlockStatement{unlockedAmount: unlockedAmount, unlockedAsset: unlockedAsset}
+	return &unlockStatement{unlockedAmount: unlockedAmount, unlockedAsset: unlockedAsset, span: p.spanFrom(start)}
 }
 
 func parseExpr(p *parser) expression {
@@ -245,13 +375,14 @@ func parseExpr(p *parser) expression {
 }
 
 func parseUnaryExpr(p *parser) expression {
+	start := p.start()
 	op, pos := scanUnaryOp(p.buf, p.pos)
 	if pos < 0 {
 		return parseExpr2(p)
 	}
 	p.pos = pos
 	expr := parseUnaryExpr(p)
-	return &unaryExpr{op: op, expr: expr}
+	return &unaryExpr{op: op, expr: expr, span: p.spanFrom(start)}
 }
 
 func parseExprCont(p *parser, lhs expression, minPrecedence int) (expression, int) {
@@ -274,29 +405,32 @@ func parseExprCont(p *parser, lhs expression, minPrecedence int) (expression, in
 				return nil, -1 // or is this an error?
 			}
 		}
-		lhs = &binaryExpr{left: lhs, right: rhs, op: op}
+		lhs = &binaryExpr{left: lhs, right: rhs, op: op, span: span{src: p.src, start: lhs.pos().start, end: p.pos}}
 	}
 	return lhs, p.pos
 }
 
 func parseExpr2(p *parser) expression {
+	start := p.start()
 	if expr, pos := scanLiteralExpr(p.buf, p.pos); pos >= 0 {
 		p.pos = pos
-		return expr
+		return withSpan(expr, p.spanFrom(start))
 	}
 	return parseExpr3(p)
 }
 
 func parseExpr3(p *parser) expression {
+	start := p.start()
 	e := parseExpr4(p)
 	if peekTok(p, "(") {
 		args := parseArgs(p)
-		return &callExpr{fn: e, args: args}
+		return &callExpr{fn: e, args: args, span: p.spanFrom(start)}
 	}
 	return e
 }
 
 func parseExpr4(p *parser) expression {
+	start := p.start()
 	if peekTok(p, "(") {
 		consumeTok(p, "(")
 		e := parseExpr(p)
@@ -317,10 +451,10 @@ func parseExpr4(p *parser) expression {
 			elts = append(elts, e)
 		}
 		consumeTok(p, "]")
-		return listExpr(elts)
+		return listExpr{elts: elts, span: p.spanFrom(start)}
 	}
 	name := consumeIdentifier(p)
-	return varRef(name)
+	return varRef{name: name, span: p.spanFrom(start)}
 }
 
 func parseArgs(p *parser) []expression {
@@ -352,6 +486,62 @@ func peekTok(p *parser, token string) bool {
 	return pos >= 0
 }
 
+func peekSync(p *parser, sync []string) bool {
+	kw := peekKeyword(p)
+	for _, s := range sync {
+		if kw == s {
+			return true
+		}
+	}
+	return false
+}
+
+// skip functions, for recovering from syntax errors
+
+// skipTo advances to the next of the sync keywords, or to a "}" that
+// closes the enclosing block, passing over any nested blocks.
+func skipTo(p *parser, sync []string) {
+	depth := 0
+	for !p.atEnd() {
+		switch {
+		case peekTok(p, "{"):
+			depth++
+		case peekTok(p, "}"):
+			if depth == 0 {
+				return
+			}
+			depth--
+		case depth == 0 && peekSync(p, sync):
+			return
+		}
+		skipToken(p)
+	}
+}
+
+// skipToken advances past one identifier, string literal or other
+// character.
+func skipToken(p *parser) {
+	p.pos = p.start()
+	if p.pos >= len(p.buf) {
+		return
+	}
+	if _, pos := scanIdentifier(p.buf, p.pos); pos >= 0 {
+		p.pos = pos
+		return
+	}
+	if quote := p.buf[p.pos]; quote == '\'' || quote == '"' {
+		for p.pos++; p.pos < len(p.buf) && p.buf[p.pos] != quote; p.pos++ {
+			if p.buf[p.pos] == '\\' {
+				p.pos++
+			}
+		}
+	}
+	p.pos++
+	if p.pos > len(p.buf) {
+		p.pos = len(p.buf)
+	}
+}
+
 // consume functions
 
 var keywords = []string{
@@ -441,6 +631,22 @@ func scanLiteralExpr(buf []byte, offset int) (expression, int) {
 	return nil, -1
 }
 
+// withSpan returns a copy of the literal expr located at sp.
+func withSpan(expr expression, sp span) expression {
+	switch e := expr.(type) {
+	case integerLiteral:
+		e.span = sp
+		return e
+	case bytesLiteral:
+		e.span = sp
+		return e
+	case booleanLiteral:
+		e.span = sp
+		return e
+	}
+	return expr
+}
+
 func scanIdentifier(buf []byte, offset int) (string, int) {
 	offset = skipWsAndComments(buf, offset)
 	i := offset
@@ -482,17 +688,17 @@ func scanIntLiteral(buf []byte, offset int) (integerLiteral, int) {
 	for ; i < len(buf) && unicode.IsDigit(rune(buf[i])); i++ {
 		// the literal is BytesLiteral when it starts with 0x/0X
 		if buf[i] == '0' && i < len(buf)-1 && (buf[i+1] == 'x' || buf[i+1] == 'X') {
-			return 0, -1
+			return integerLiteral{}, -1
 		}
 	}
 	if i > offset {
 		n, err := strconv.ParseInt(string(buf[start:i]), 10, 64)
 		if err != nil {
-			return 0, -1
+			return integerLiteral{}, -1
 		}
-		return integerLiteral(n), i
+		return integerLiteral{value: n}, i
 	}
-	return 0, -1
+	return integerLiteral{}, -1
 }
 
 func scanStrLiteral(buf []byte, offset int) (bytesLiteral, int) {
@@ -500,10 +706,10 @@ func scanStrLiteral(buf []byte, offset int) (bytesLiteral, int) {
 	if offset >= len(buf) || !(buf[offset] == '\'' || buf[offset] == '"') {
 		return bytesLiteral{}, -1
 	}
-	var byteBuf bytesLiteral
+	var byteBuf []byte
 	for i := offset + 1; i < len(buf); i++ {
 		if (buf[offset] == '\'' && buf[i] == '\'') || (buf[offset] == '"' && buf[i] == '"') {
-			return byteBuf, i + 1
+			return bytesLiteral{value: byteBuf}, i + 1
 		}
 		if buf[i] == '\\' && i < len(buf)-1 {
 			if c, ok := scanEscape(buf[i+1]); ok {
@@ -520,13 +726,13 @@ func scanStrLiteral(buf []byte, offset int) (bytesLiteral, int) {
 func scanBytesLiteral(buf []byte, offset int) (bytesLiteral, int) {
 	offset = skipWsAndComments(buf, offset)
 	if offset+4 >= len(buf) {
-		return nil, -1
+		return bytesLiteral{}, -1
 	}
 	if buf[offset] != '0' || (buf[offset+1] != 'x' && buf[offset+1] != 'X') {
-		return nil, -1
+		return bytesLiteral{}, -1
 	}
 	if !isHexDigit(buf[offset+2]) || !isHexDigit(buf[offset+3]) {
-		return nil, -1
+		return bytesLiteral{}, -1
 	}
 	i := offset + 4
 	for ; i < len(buf); i += 2 {
@@ -545,23 +751,23 @@ func scanBytesLiteral(buf []byte, offset int) (bytesLiteral, int) {
 	if err != nil {
 		return bytesLiteral{}, -1
 	}
-	return bytesLiteral(decoded), i
+	return bytesLiteral{value: decoded}, i
 }
 
 func scanBoolLiteral(buf []byte, offset int) (booleanLiteral, int) {
 	offset = skipWsAndComments(buf, offset)
 	if offset >= len(buf) {
-		return false, -1
+		return booleanLiteral{}, -1
 	}
 
 	newOffset := scanKeyword(buf, offset, "true")
 	if newOffset < 0 {
 		if newOffset = scanKeyword(buf, offset, "false"); newOffset < 0 {
-			return false, -1
+			return booleanLiteral{}, -1
 		}
-		return false, newOffset
+		return booleanLiteral{value: false}, newOffset
 	}
-	return true, newOffset
+	return booleanLiteral{value: true}, newOffset
 }
 
 func skipWsAndComments(buf []byte, offset int) int {
@@ -607,6 +813,7 @@ func isIDChar(c byte, initial bool) bool {
 type parserErr struct {
 	buf    []byte
 	offset int
+	code   string
 	format string
 	args   []interface{}
 }
@@ -632,6 +839,24 @@ func (p parserErr) Error() string {
 	return fmt.Sprintf("line %d, col %d: "+p.format, args...)
 }
 
+// diagnostic converts the error to a Diagnostic covering the token at
+// which parsing failed.
+func (p parserErr) diagnostic(src *source) *Diagnostic {
+	code := p.code
+	if code == "" {
+		code = CodeSyntax
+	}
+	start := skipWsAndComments(p.buf, p.offset)
+	end := start
+	for end < len(p.buf) && isIDChar(p.buf[end], false) {
+		end++
+	}
+	if end == start && end < len(p.buf) {
+		end++
+	}
+	return newDiagnostic(span{src: src, start: start, end: end}, code, fmt.Sprintf(p.format, p.args...))
+}
+
 func scanEscape(c byte) (byte, bool) {
 	escapeFlag := true
 	switch c {
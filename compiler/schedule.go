@@ -0,0 +1,185 @@
+package compiler
+
+import "sort"
+
+// schedule returns stmts, the statements of the clause, with the
+// stack operations they compile to scheduled anew where that makes
+// the clause smaller.
+//
+// The parameters are on the stack in the order the program takes
+// them, which is not the order the clause uses them in, so every
+// reference to one picks or rolls it from wherever it is. schedule
+// tries dropping the parameters the clause does not use, setting
+// aside on the alt stack those it does not use at first, and
+// evaluating the operands of commutative operators the other way
+// round, keeping each change that makes the clause smaller.
+func (s *clauseSearch) schedule(stmts []statement) []statement {
+	counts := make(map[string]int)
+	for _, stat := range stmts {
+		counts = countsVarRef(stat, counts)
+	}
+
+	// The parameters, from the top of the stack down.
+	var params []string
+	if s.contract.Recursive {
+		params = append(params, s.contract.Name)
+	}
+	for _, p := range s.contract.Params {
+		params = append(params, p.Name)
+	}
+	for i := len(s.clause.Params) - 1; i >= 0; i-- {
+		params = append(params, s.clause.Params[i].Name)
+	}
+
+	var drops, stashes []string
+	whole := func(stmts []statement) []statement {
+		return scheduled(stmts, drops, stashes)
+	}
+	best, _ := s.size(whole(stmts))
+	if best < 0 {
+		return stmts
+	}
+	try := func() bool {
+		n, _ := s.size(whole(stmts))
+		if n < 0 || n >= best {
+			return false
+		}
+		best = n
+		return true
+	}
+
+	for _, name := range params {
+		if counts[name] > 0 {
+			continue
+		}
+		drops = append(drops, name)
+		if !try() {
+			drops = drops[:len(drops)-1]
+		}
+	}
+	for _, name := range params {
+		if counts[name] == 0 || firstUse(stmts, name) == 0 {
+			continue
+		}
+		stashes = append(stashes, name)
+		if !try() {
+			stashes = stashes[:len(stashes)-1]
+		}
+	}
+	for n := 0; ; n++ {
+		flipped, ok := flip(stmts, n)
+		if !ok {
+			break
+		}
+		if size, _ := s.size(whole(flipped)); size >= 0 && size < best {
+			stmts, best = flipped, size
+		}
+	}
+	return whole(stmts)
+}
+
+// scheduled returns stmts with the parameters in drops dropped and
+// those in stashes set aside on the alt stack until the first
+// statement using them.
+func scheduled(stmts []statement, drops, stashes []string) []statement {
+	if len(stmts) == 0 || len(drops)+len(stashes) == 0 {
+		return stmts
+	}
+
+	// The alt stack is last in, first out, so the parameters used
+	// last are set aside first.
+	stashes = append([]string(nil), stashes...)
+	sort.SliceStable(stashes, func(i, j int) bool {
+		return firstUse(stmts, stashes[i]) > firstUse(stmts, stashes[j])
+	})
+
+	var result []statement
+	for _, name := range drops {
+		result = append(result, &dropStatement{name: name, span: stmts[0].pos()})
+	}
+	for _, name := range stashes {
+		result = append(result, &stashStatement{name: name, span: stmts[0].pos()})
+	}
+	for i, stat := range stmts {
+		for j := len(stashes) - 1; j >= 0; j-- {
+			if firstUse(stmts, stashes[j]) == i {
+				result = append(result, &unstashStatement{name: stashes[j], span: stat.pos()})
+			}
+		}
+		result = append(result, stat)
+	}
+	return result
+}
+
+// firstUse returns the index of the first of stmts to refer to name,
+// or -1 if none does.
+func firstUse(stmts []statement, name string) int {
+	for i, stat := range stmts {
+		if countsVarRef(stat, make(map[string]int))[name] > 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// flip returns stmts with the operands of their nth binary expression
+// with a mirrored operator, counting in the order mapStatement visits
+// them, evaluated the other way round. It returns false if there are
+// not that many.
+func flip(stmts []statement, n int) ([]statement, bool) {
+	var i int
+	f := func(e expression) (expression, bool) {
+		b, ok := e.(*binaryExpr)
+		if !ok || b.op.mirror() == nil {
+			return e, false
+		}
+		i++
+		if i-1 != n {
+			return e, false
+		}
+		return &binaryExpr{left: b.left, right: b.right, op: b.op, swapped: !b.swapped, span: b.span}, true
+	}
+
+	var result []statement
+	for _, stat := range stmts {
+		result = append(result, mapStatement(stat, f))
+	}
+	return result, i > n
+}
+
+// dropStatement drops a parameter that the clause does not use.
+type dropStatement struct {
+	name string
+	span
+}
+
+func (dropStatement) countVarRefs(map[string]int) {}
+
+// stashStatement sets a parameter aside on the alt stack.
+type stashStatement struct {
+	name string
+	span
+}
+
+func (stashStatement) countVarRefs(map[string]int) {}
+
+// unstashStatement returns the parameter that a stashStatement set
+// aside to the stack.
+type unstashStatement struct {
+	name string
+	span
+}
+
+func (unstashStatement) countVarRefs(map[string]int) {}
+
+// compileRoll rolls the item named name to the top of the stack.
+func compileRoll(b *builder, stk stack, name string) stack {
+	switch depth := stk.find(name); depth {
+	case 0:
+	case 1:
+		stk = b.addSwap(stk)
+	default:
+		stk = b.addRoll(stk, depth)
+	}
+	return stk
+}
@@ -0,0 +1,84 @@
+package compiler
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const sums = `
+contract Sums(a: Integer, b: Integer, c: Integer, d: Integer, e: Integer, f: Integer, g: Integer, h: Integer, key: PublicKey) locks value of asset {
+  clause spend(x: Integer, y: Integer) {
+    verify x > h
+    verify y < g + h
+    verify x + y < a + b + c + d + e + f
+    unlock value of asset
+  }
+  clause cancel(sig: Signature) {
+    verify checkTxSig(key, sig)
+    unlock value of asset
+  }
+}
+`
+
+func TestSchedule(t *testing.T) {
+	contracts, err := Compile(strings.NewReader(sums))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sized, err := CompileWithOptions(strings.NewReader(sums), Options{Optimize: OptSize})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, want := sized[0], contracts[0]
+	if len(got.Body) >= len(want.Body) {
+		t.Errorf("got %d bytes, %s; want fewer than %d, %s", len(got.Body), got.Opcodes, len(want.Body), want.Opcodes)
+	}
+
+	// The program takes the same arguments.
+	if !reflect.DeepEqual(got.Params, want.Params) {
+		t.Errorf("got params %+v, want %+v", got.Params, want.Params)
+	}
+	for i, clause := range got.Clauses {
+		if !reflect.DeepEqual(clause.Params, want.Clauses[i].Params) {
+			t.Errorf("clause %s: got params %+v, want %+v", clause.Name, clause.Params, want.Clauses[i].Params)
+		}
+	}
+}
+
+func TestCompileSwapped(t *testing.T) {
+	for _, op := range []string{"<", "<=", ">", ">=", "==", "!=", "+", "*"} {
+		e := parseTestExpr(t, "x "+op+" 7").(*binaryExpr)
+		mirror := e.op.mirror()
+		if mirror == nil {
+			t.Errorf("%s: no mirror", op)
+			continue
+		}
+		if mirror.mirror() != e.op {
+			t.Errorf("%s: mirror of mirror %s is %s", op, mirror.op, mirror.mirror().op)
+		}
+		e.swapped = true
+		if got := e.String(); got != "(x "+op+" 7)" {
+			t.Errorf("%s: swapped expression reads %s", op, got)
+		}
+
+		b := &builder{}
+		env := newEnviron(nil)
+		env.add("x", intType, roleClauseParam)
+		if _, err := compileExpr(b, stack{}.add("x"), &Contract{}, &Clause{}, env, map[string]int{"x": 1}, e); err != nil {
+			t.Fatal(err)
+		}
+		var ops []string
+		for _, x := range b.instructions() {
+			ops = append(ops, x.op)
+		}
+		if got, want := strings.Join(ops, " "), "7 SWAP "+mirror.opcodes; got != want {
+			t.Errorf("%s: got %s, want %s", op, got, want)
+		}
+	}
+	for _, op := range []string{"-", "/", "%", "<<", ">>"} {
+		if e := parseTestExpr(t, "x "+op+" 7").(*binaryExpr); e.op.mirror() != nil {
+			t.Errorf("%s has mirror %s", op, e.op.mirror().op)
+		}
+	}
+}
@@ -37,6 +37,14 @@ func (stk stack) addFromStack(other stack) stack {
 	return res.add(other.top())
 }
 
+func (stk stack) size() int {
+	n := 0
+	for e := stk.stackEntry; e != nil; e = e.prev {
+		n++
+	}
+	return n
+}
+
 func (stk stack) drop() stack {
 	if !stk.isEmpty() {
 		stk = stack{stk.prev}
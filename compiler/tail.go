@@ -0,0 +1,87 @@
+package compiler
+
+import (
+	"fmt"
+	"strings"
+)
+
+// mergeTails merges the code at the ends of clauses and of the
+// branches of if statements that is the same in two of them. Where
+// the code before a JUMP to a label ends the same way as the code
+// that runs on into the label, with the same stacks as the compiler
+// knows them, the JUMP goes to the start of the latter instead and the
+// former is removed. The JUMP was there anyway, so this makes the body
+// smaller without costing gas.
+//
+// items are the builder items from which insts were compiled.
+func mergeTails(insts []instruction, items []*builderItem) []instruction {
+	for n := 0; ; n++ {
+		merged, ok := mergeTail(insts, items, fmt.Sprintf("_tail_%d", n))
+		if !ok {
+			return insts
+		}
+		insts = merged
+	}
+}
+
+// mergeTail merges the first tail that mergeTails may, labeling the
+// code that is kept with label. It returns false if there is none.
+func mergeTail(insts []instruction, items []*builderItem, label string) ([]instruction, bool) {
+	labels := make(map[string]int)
+	for i, x := range insts {
+		if x.isLabel() {
+			labels[x.op[1:]] = i
+		}
+	}
+
+	for j, x := range insts {
+		if !strings.HasPrefix(x.op, "JUMP:$") {
+			continue
+		}
+		at, ok := labels[x.op[len("JUMP:$"):]]
+		if !ok || at < j {
+			continue
+		}
+
+		// The code that runs on into the label may start after labels
+		// of its own, but the code that jumps must be reached only by
+		// running on into the JUMP.
+		var into, jumps []int
+		for i := at - 1; i > j && !isJump(insts[i].op); i-- {
+			if !insts[i].isLabel() {
+				into = append(into, i)
+			}
+		}
+		for i := j - 1; i >= 0 && !insts[i].isLabel() && !isJump(insts[i].op); i-- {
+			jumps = append(jumps, i)
+		}
+
+		var k int
+		for k < len(into) && k < len(jumps) && sameTail(insts[into[k]], insts[jumps[k]], items) {
+			k++
+		}
+		if k == 0 {
+			continue
+		}
+
+		start := into[k-1]
+		var result []instruction
+		result = append(result, insts[:j-k]...)
+		result = append(result, instruction{op: "JUMP:$" + label, origin: x.origin, depth: x.depth})
+		result = append(result, insts[j+1:start]...)
+		result = append(result, instruction{op: "$" + label, origin: insts[start].origin, depth: insts[start].depth})
+		result = append(result, insts[start:]...)
+		return result, true
+	}
+	return insts, false
+}
+
+// sameTail tells whether x and y are the same instruction, run with
+// the same stack.
+func sameTail(x, y instruction, items []*builderItem) bool {
+	return x.op == y.op && x.depth == y.depth && items[x.origin].stk.String() == items[y.origin].stk.String()
+}
+
+func isJump(op string) bool {
+	return strings.HasPrefix(op, "JUMP:") || strings.HasPrefix(op, "JUMPIF:")
+}
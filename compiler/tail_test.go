@@ -0,0 +1,57 @@
+package compiler
+
+import (
+	"strings"
+	"testing"
+)
+
+const tails = `
+contract Tails(key: PublicKey, deadline: Integer, dest: Program) locks value of asset {
+  clause spend(sig: Signature, late: Boolean) {
+    verify checkTxSig(key, sig)
+    if late {
+      verify above(deadline)
+      lock value of asset with dest
+    } else {
+      verify below(deadline)
+      lock value of asset with dest
+    }
+  }
+  clause expire() {
+    verify above(deadline)
+    lock value of asset with dest
+  }
+}
+`
+
+func TestMergeTails(t *testing.T) {
+	compile := func(opts Options) *Contract {
+		contracts, err := CompileWithOptions(strings.NewReader(tails), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return contracts[0]
+	}
+	merged := compile(Options{})
+	unmerged := compile(Options{Passes: []string{PassFold, PassShare, PassPeephole, PassDispatch}})
+
+	if len(merged.Body) >= len(unmerged.Body) {
+		t.Errorf("got %d bytes merged, %d unmerged", len(merged.Body), len(unmerged.Body))
+	}
+	const lock = "0 AMOUNT ASSET 1 5 PICK CHECKOUTPUT"
+	if n := strings.Count(unmerged.Opcodes, lock); n != 2 {
+		t.Fatalf("got %s unmerged, want the lock in both branches", unmerged.Opcodes)
+	}
+	if n := strings.Count(merged.Opcodes, lock); n != 1 {
+		t.Errorf("got %s, want the branches to share the lock", merged.Opcodes)
+	}
+
+	// The code of the branches differs before the lock, and that of
+	// the clauses in how they take dest.
+	if !strings.Contains(merged.Opcodes, "LESSTHAN JUMP:$_tail_0") || !strings.Contains(merged.Opcodes, "GREATERTHAN $_tail_0 VERIFY") {
+		t.Errorf("got %s", merged.Opcodes)
+	}
+	if merged.Clauses[0].Gas == nil || merged.Clauses[0].Gas.Worst != unmerged.Clauses[0].Gas.Worst {
+		t.Errorf("got gas %+v merged, %+v unmerged", merged.Clauses[0].Gas, unmerged.Clauses[0].Gas)
+	}
+}
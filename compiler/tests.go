@@ -0,0 +1,388 @@
+package compiler
+
+import (
+	"io"
+	"io/ioutil"
+
+	chainjson "github.com/bytom/encoding/json"
+	"github.com/bytom/errors"
+)
+
+// Test is a test case declared in Equity source with a test block:
+//
+//	test "partial profit leaves the rest locked" {
+//	  contract FixedLimitProfit(0xb1b1...b1, 1000000000, 10000, 100, 200, 0x0014ba, publicKey(banker)) locks 10000 of 0xcaca...ca
+//	  height 101
+//	  clause profit(300000000, 0x00145a)
+//	  output 300000000 of 0xb1b1...b1 with 0x0014ba
+//	  output 3000 of 0xcaca...ca with 0x00145a
+//	  output 7000 of 0xcaca...ca with FixedLimitProfit(0xb1b1...b1, 1000000000, 10000, 100, 200, 0x0014ba, publicKey(banker))
+//	  expect pass
+//	}
+//
+// A test instantiates a contract, spends it through one of its
+// clauses in a transaction at the given block height with the given
+// outputs, and expects the clause to pass or fail. The locks part,
+// the height and the outputs may be left out. Test blocks are not
+// compiled into any contract.
+type Test struct {
+	// Name is the description given after the test keyword.
+	Name string
+
+	// Contract is the name of the contract under test, and Args the
+	// arguments with which it is instantiated.
+	Contract string
+	Args     []*TestValue
+
+	// Amount and Asset are the value locked by the contract. Asset is
+	// nil if the test does not give one.
+	Amount int64
+	Asset  *TestValue
+
+	// BlockHeight is the height of the block containing the spending
+	// transaction.
+	BlockHeight int64
+
+	// Clause is the name of the clause through which the contract is
+	// spent, and ClauseArgs the arguments passed to it.
+	Clause     string
+	ClauseArgs []*TestValue
+
+	// Outputs is the list of outputs of the spending transaction. If
+	// it is empty, every output a clause checks for is taken to be
+	// present.
+	Outputs []*TestOutput
+
+	// ExpectPass tells whether the clause is expected to pass ("expect
+	// pass") or to fail ("expect fail").
+	ExpectPass bool
+
+	// Location is the name of the test in its declaration.
+	Location Location
+
+	span
+	contractSpan, clauseSpan span
+
+	// incomplete is set when the parser recovered from syntax errors
+	// inside the test.
+	incomplete bool
+}
+
+// TestOutput is an output of the transaction spending a contract under
+// test.
+type TestOutput struct {
+	Amount  int64
+	Asset   *TestValue
+	Program *TestValue
+}
+
+// TestValue is a value written in a test block. Exactly one of B, I,
+// S, PublicKey, Signature and Contract is set.
+type TestValue struct {
+	// B, I and S hold boolean, integer and string or hex literals, as
+	// in ContractArg.
+	B *bool
+	I *int64
+	S *chainjson.HexBytes
+
+	// PublicKey and Signature name a test key, written publicKey(name)
+	// and signature(name). The value is the key's public key, or its
+	// signature of the spending transaction. Keys are derived from
+	// their names, so tests are reproducible.
+	PublicKey string
+	Signature string
+
+	// Contract names a contract whose program, instantiated with Args,
+	// is the value, written as a call: Name(args...).
+	Contract string
+	Args     []*TestValue
+
+	span
+}
+
+// typeName describes the kind of value v holds, in the terms of
+// Equity types.
+func (v *TestValue) typeName() typeDesc {
+	switch {
+	case v.B != nil:
+		return boolType
+	case v.I != nil:
+		return intType
+	case v.PublicKey != "":
+		return pubkeyType
+	case v.Signature != "":
+		return sigType
+	case v.Contract != "":
+		return progType
+	}
+	return strType
+}
+
+// CompileTests is like Compile, but also returns the test blocks
+// declared in the input, checked against the contracts they use.
+// Test blocks in imported files are not returned.
+func CompileTests(r io.Reader) ([]*Contract, []*Test, error) {
+	return CompileTestsWithOptions(r, Options{})
+}
+
+// CompileTestsWithOptions is CompileTests with the given options, so
+// that the tests run the programs that CompileWithOptions compiles.
+func CompileTestsWithOptions(r io.Reader, opts Options) ([]*Contract, []*Test, error) {
+	if err := opts.check(); err != nil {
+		return nil, nil, err
+	}
+	inp, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "reading input")
+	}
+	var name string
+	if f, ok := r.(interface{ Name() string }); ok {
+		name = f.Name()
+	}
+	contracts, tests, diags := compile(inp, name, opts)
+	if len(diags) > 0 {
+		return nil, nil, diags
+	}
+	return contracts, tests, nil
+}
+
+var testSync = []string{"contract", "height", "clause", "output", "expect", "test"}
+
+// test "name" { ... }
+func parseTest(p *parser) *Test {
+	consumeKeyword(p, "test")
+	start := p.start()
+	name, pos := scanStrLiteral(p.buf, p.pos)
+	if pos < 0 {
+		p.errorf("expected test name")
+	}
+	p.pos = pos
+	t := &Test{Name: string(name.value), span: p.spanFrom(start)}
+	t.Location = t.span.location()
+	consumeTok(p, "{")
+	n := len(p.errs)
+	for !peekTok(p, "}") && !p.atEnd() && peekKeyword(p) != "test" {
+		try(p, testSync, func() { parseTestStatement(p, t) })
+	}
+	t.incomplete = len(p.errs) > n
+	consumeTok(p, "}")
+	return t
+}
+
+func parseTestStatement(p *parser, t *Test) {
+	switch peekKeyword(p) {
+	case "contract":
+		// contract name(args) [locks amount of asset]
+		consumeKeyword(p, "contract")
+		start := p.start()
+		t.Contract = consumeIdentifier(p)
+		t.contractSpan = p.spanFrom(start)
+		t.Args = parseTestArgs(p)
+		if peekKeyword(p) == "locks" {
+			consumeKeyword(p, "locks")
+			t.Amount = parseTestInt(p)
+			consumeKeyword(p, "of")
+			t.Asset = parseTestValue(p)
+		}
+
+	case "height":
+		consumeKeyword(p, "height")
+		t.BlockHeight = parseTestInt(p)
+
+	case "clause":
+		consumeKeyword(p, "clause")
+		start := p.start()
+		t.Clause = consumeIdentifier(p)
+		t.clauseSpan = p.spanFrom(start)
+		t.ClauseArgs = parseTestArgs(p)
+
+	case "output":
+		// output amount of asset with program
+		consumeKeyword(p, "output")
+		out := &TestOutput{Amount: parseTestInt(p)}
+		consumeKeyword(p, "of")
+		out.Asset = parseTestValue(p)
+		consumeKeyword(p, "with")
+		out.Program = parseTestValue(p)
+		t.Outputs = append(t.Outputs, out)
+
+	case "expect":
+		consumeKeyword(p, "expect")
+		switch peekKeyword(p) {
+		case "pass":
+			t.ExpectPass = true
+		case "fail":
+			t.ExpectPass = false
+		default:
+			p.errorf("expected pass or fail")
+		}
+		consumeIdentifier(p)
+
+	default:
+		p.errorf("unknown test statement \"%s\"", peekKeyword(p))
+	}
+}
+
+func parseTestArgs(p *parser) []*TestValue {
+	var values []*TestValue
+	for _, arg := range parseArgs(p) {
+		values = append(values, testValue(arg))
+	}
+	return values
+}
+
+func parseTestInt(p *parser) int64 {
+	v := parseTestValue(p)
+	if v.I == nil {
+		panic(errorf(v.span, CodeTypeMismatch, "expected integer"))
+	}
+	return *v.I
+}
+
+func parseTestValue(p *parser) *TestValue {
+	return testValue(parseExpr(p))
+}
+
+// testValue converts an expression in a test block to the value it
+// denotes. Only literals, key references and contract instantiations
+// are allowed.
+func testValue(expr expression) *TestValue {
+	v := &TestValue{span: expr.pos()}
+	switch e := expr.(type) {
+	case integerLiteral:
+		v.I = &e.value
+		return v
+	case booleanLiteral:
+		v.B = &e.value
+		return v
+	case bytesLiteral:
+		v.S = (*chainjson.HexBytes)(&e.value)
+		return v
+	case *callExpr:
+		fn, ok := e.fn.(varRef)
+		if !ok {
+			break
+		}
+		switch fn.name {
+		case "publicKey", "signature":
+			var key varRef
+			if len(e.args) == 1 {
+				key, ok = e.args[0].(varRef)
+			}
+			if !ok || len(e.args) != 1 {
+				panic(errorf(v.span, CodeSyntax, "expected %s(name)", fn.name))
+			}
+			if fn.name == "publicKey" {
+				v.PublicKey = key.name
+			} else {
+				v.Signature = key.name
+			}
+			return v
+		}
+		v.Contract = fn.name
+		for _, arg := range e.args {
+			v.Args = append(v.Args, testValue(arg))
+		}
+		return v
+	}
+	panic(errorf(v.span, CodeSyntax, "expected a literal, publicKey(name), signature(name) or contract instantiation"))
+}
+
+// checkTests reports the problems in tests: unknown contracts and
+// clauses, missing statements, and arguments that do not suit their
+// parameters.
+func checkTests(tests []*Test, contracts []*Contract) Diagnostics {
+	var diags Diagnostics
+	byName := make(map[string]*Contract)
+	for _, contract := range contracts {
+		byName[contract.Name] = contract
+	}
+	names := make(map[string]bool)
+	for _, t := range tests {
+		if names[t.Name] {
+			diags.add(errorf(t.span, CodeRedeclared, "test \"%s\" redeclared", t.Name))
+		}
+		names[t.Name] = true
+		if t.incomplete {
+			// already reported
+			continue
+		}
+
+		if t.Contract == "" {
+			diags.add(errorf(t.span, CodeTest, "test \"%s\" has no contract statement", t.Name))
+			continue
+		}
+		contract, ok := byName[t.Contract]
+		if !ok {
+			diags.add(errorf(t.contractSpan, CodeUndefined, "undefined contract \"%s\"", t.Contract))
+			continue
+		}
+		diags.add(checkTestArgs(t.contractSpan, contract.Name, contract.Params, t.Args, byName))
+		if t.Asset != nil {
+			diags.add(checkTestValue(t.Asset, assetType, "asset", byName))
+		}
+		for _, out := range t.Outputs {
+			diags.add(checkTestValue(out.Asset, assetType, "asset", byName))
+			diags.add(checkTestValue(out.Program, progType, "program", byName))
+		}
+
+		if t.Clause == "" {
+			diags.add(errorf(t.span, CodeTest, "test \"%s\" has no clause statement", t.Name))
+			continue
+		}
+		var clause *Clause
+		for _, c := range contract.Clauses {
+			if c.Name == t.Clause {
+				clause = c
+			}
+		}
+		if clause == nil {
+			diags.add(errorf(t.clauseSpan, CodeUndefined, "contract \"%s\" has no clause \"%s\"", contract.Name, t.Clause))
+			continue
+		}
+		diags.add(checkTestArgs(t.clauseSpan, clause.Name, clause.Params, t.ClauseArgs, byName))
+	}
+	return diags
+}
+
+func checkTestArgs(sp span, name string, params []*Param, args []*TestValue, contracts map[string]*Contract) error {
+	if len(args) != len(params) {
+		return errorf(sp, CodeArgCount, "wrong number of args for \"%s\": have %d, want %d", name, len(args), len(params))
+	}
+	var diags Diagnostics
+	for i, param := range params {
+		diags.add(checkTestValue(args[i], param.Type, param.Name, contracts))
+	}
+	return diags.err()
+}
+
+// checkTestValue reports whether v suits a parameter of type typ.
+func checkTestValue(v *TestValue, typ typeDesc, name string, contracts map[string]*Contract) error {
+	got := v.typeName()
+	var ok bool
+	switch typ {
+	case amountType, intType:
+		ok = got == intType
+	case boolType:
+		ok = got == boolType
+	case pubkeyType:
+		ok = got == strType || got == pubkeyType
+	case sigType, signType:
+		ok = got == strType || got == sigType
+	case progType:
+		ok = got == strType || got == progType
+	default:
+		ok = got == strType
+	}
+	if !ok {
+		return errorf(v.span, CodeTypeMismatch, "wrong type for %s: got %s, want %s", name, got, typ)
+	}
+	if v.Contract == "" {
+		return nil
+	}
+	contract, ok := contracts[v.Contract]
+	if !ok {
+		return errorf(v.span, CodeUndefined, "undefined contract \"%s\"", v.Contract)
+	}
+	return checkTestArgs(v.span, contract.Name, contract.Params, v.Args, contracts)
+}
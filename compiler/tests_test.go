@@ -0,0 +1,182 @@
+package compiler
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const lockWithDeadline = `
+contract LockWithDeadline(publicKey: PublicKey, deadline: Integer, dest: Program) locks value of asset {
+  clause spend(sig: Signature) {
+    verify checkTxSig(publicKey, sig)
+    unlock value of asset
+  }
+  clause expire() {
+    verify above(deadline)
+    lock value of asset with dest
+  }
+}
+`
+
+func TestCompileTests(t *testing.T) {
+	src := lockWithDeadline + `
+test "spend" {
+  contract LockWithDeadline(publicKey(alice), 100, 0x0014aa) locks 1000 of 0x0101
+  clause spend(signature(alice))
+  expect pass
+}
+
+test "expire early" {
+  contract LockWithDeadline(publicKey(alice), 100, LockWithDeadline(publicKey(bob), -1, "x"))
+  height 99
+  clause expire()
+  output 1000 of 0x0101 with 0x0014aa
+  expect fail
+}
+`
+	contracts, tests, err := CompileTests(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(contracts) != 1 {
+		t.Fatalf("got %d contracts, want 1", len(contracts))
+	}
+	if len(tests) != 2 {
+		t.Fatalf("got %d tests, want 2", len(tests))
+	}
+
+	spend := tests[0]
+	if spend.Name != "spend" || spend.Contract != "LockWithDeadline" || spend.Clause != "spend" || !spend.ExpectPass {
+		t.Errorf("got test %+v", spend)
+	}
+	if spend.Amount != 1000 || !bytes.Equal(*spend.Asset.S, []byte{1, 1}) {
+		t.Errorf("got locked value %d of %v", spend.Amount, spend.Asset)
+	}
+	if spend.Args[0].PublicKey != "alice" || *spend.Args[1].I != 100 || spend.ClauseArgs[0].Signature != "alice" {
+		t.Errorf("got args %+v and clause args %+v", spend.Args, spend.ClauseArgs)
+	}
+	if spend.Location.Line != 13 {
+		t.Errorf("got location %+v, want line 13", spend.Location)
+	}
+
+	expire := tests[1]
+	if expire.ExpectPass || expire.BlockHeight != 99 || len(expire.Outputs) != 1 {
+		t.Errorf("got test %+v", expire)
+	}
+	dest := expire.Args[2]
+	if dest.Contract != "LockWithDeadline" || len(dest.Args) != 3 || *dest.Args[1].I != -1 || string(*dest.Args[2].S) != "x" {
+		t.Errorf("got instantiation %+v", dest)
+	}
+
+	// Compile ignores tests
+	contracts, err = Compile(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(contracts[0].Body, mustCompile(t, lockWithDeadline).Body) {
+		t.Error("test blocks changed the compiled contract")
+	}
+}
+
+func TestCompileTestsWithOptions(t *testing.T) {
+	src := lockWithDeadline + `
+test "spend" {
+  contract LockWithDeadline(publicKey(alice), 100, 0x0014aa)
+  clause spend(signature(alice))
+  expect pass
+}
+`
+	for _, opts := range []Options{{Optimize: OptNone}, {Optimize: OptSize}, {Passes: []string{}}} {
+		contracts, tests, err := CompileTestsWithOptions(strings.NewReader(src), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := CompileWithOptions(strings.NewReader(lockWithDeadline), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(contracts[0].Body, want[0].Body) || len(tests) != 1 {
+			t.Errorf("%+v: got %x and %d tests, want %x and 1", opts, contracts[0].Body, len(tests), want[0].Body)
+		}
+	}
+
+	if _, _, err := CompileTestsWithOptions(strings.NewReader(src), Options{Passes: []string{"inline"}}); err == nil {
+		t.Error("got no error for an unknown pass")
+	}
+}
+
+func TestCompileTestsErrors(t *testing.T) {
+	src := lockWithDeadline + `
+test "unknown contract" {
+  contract Frob(1)
+  clause spend(0x00)
+  expect pass
+}
+
+test "unknown clause" {
+  contract LockWithDeadline(publicKey(alice), 100, 0x0014aa)
+  clause frob()
+  expect pass
+}
+
+test "wrong types" {
+  contract LockWithDeadline(0x00, true, signature(alice))
+  clause spend(publicKey(alice))
+  expect pass
+}
+
+test "bad statement" {
+  contract LockWithDeadline(publicKey(alice), 100, 0x0014aa)
+  clause expire()
+  expect success
+}
+
+test "bad value" {
+  contract LockWithDeadline(publicKey(alice), 1+2, 0x0014aa)
+  clause expire()
+  expect fail
+}
+
+test "bad value" {
+  contract LockWithDeadline(publicKey(alice), 100, 0x0014aa)
+  expect fail
+}
+`
+	want := []struct {
+		line int
+		code string
+	}{
+		{14, CodeUndefined},
+		{21, CodeUndefined},
+		{26, CodeTypeMismatch},
+		{26, CodeTypeMismatch},
+		{27, CodeTypeMismatch},
+		{34, CodeSyntax},
+		{38, CodeSyntax},
+		{43, CodeRedeclared},
+		{43, CodeTest},
+	}
+
+	_, _, err := CompileTests(strings.NewReader(src))
+	diags, ok := err.(Diagnostics)
+	if !ok {
+		t.Fatalf("got error %v (%T), want Diagnostics", err, err)
+	}
+	if len(diags) != len(want) {
+		t.Fatalf("got %d diagnostics, want %d:\n%v", len(diags), len(want), diags)
+	}
+	for i, w := range want {
+		if diags[i].Line != w.line || diags[i].Code != w.code {
+			t.Errorf("diagnostic %d: got line %d code %s (%s), want line %d code %s", i, diags[i].Line, diags[i].Code, diags[i].Message, w.line, w.code)
+		}
+	}
+}
+
+func mustCompile(t *testing.T, src string) *Contract {
+	contracts, err := Compile(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return contracts[len(contracts)-1]
+}
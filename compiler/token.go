@@ -0,0 +1,166 @@
+package compiler
+
+import (
+	"bytes"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokInt
+	tokString
+	tokBytes
+	tokPunct
+)
+
+// token is a lexical token of Equity source, together with the
+// comments and line breaks around it. The parser skips such trivia;
+// tokens keep it so that source can be printed back without losing
+// it.
+type token struct {
+	kind tokenKind
+	text string
+	span
+
+	// newline tells whether a line break precedes the token, and blank
+	// whether an empty line does (after any leading comments).
+	newline, blank bool
+
+	// leading is the list of comments on lines of their own before
+	// the token.
+	leading []comment
+
+	// trailing is a comment following the token on the same line.
+	trailing string
+}
+
+type comment struct {
+	text string
+
+	// blank tells whether an empty line precedes the comment.
+	blank bool
+}
+
+// puncts is the list of operators and punctuation, longest first so
+// that the first match is the maximal munch.
+var puncts = []string{
+	"==", "!=", "<=", ">=", "<<", ">>", "||", "&&",
+	"<", ">", "+", "-", "*", "/", "%", "&", "|", "^", "~", "!",
+	"(", ")", "{", "}", "[", "]", ",", ":", "=",
+}
+
+// lex splits src into tokens, ending with a tokEOF token that holds
+// any comments at the end of the file.
+func lex(src *source) ([]*token, error) {
+	var (
+		buf      = src.buf
+		toks     []*token
+		prev     *token
+		leading  []comment
+		newlines int  // line breaks since the last token or comment
+		newline  bool // whether a line break follows prev
+	)
+	for i := 0; ; {
+		if i < len(buf) {
+			c := buf[i]
+			switch {
+			case c == '\n':
+				newlines++
+				newline = true
+				i++
+				continue
+
+			case unicode.IsSpace(rune(c)):
+				i++
+				continue
+
+			case c == '/' && i+1 < len(buf) && buf[i+1] == '/':
+				end := bytes.IndexByte(buf[i:], '\n')
+				if end < 0 {
+					end = len(buf)
+				} else {
+					end += i
+				}
+				text := strings.TrimRightFunc(string(buf[i:end]), unicode.IsSpace)
+				if prev != nil && !newline && prev.trailing == "" {
+					prev.trailing = text
+				} else {
+					leading = append(leading, comment{text: text, blank: newlines > 1})
+				}
+				newlines = 0
+				i = end
+				continue
+			}
+		}
+
+		tok := &token{newline: newline, blank: newlines > 1, leading: leading}
+		start := i
+		if i >= len(buf) {
+			tok.kind = tokEOF
+		} else {
+			var err error
+			tok.kind, i, err = scanToken(src, i)
+			if err != nil {
+				return nil, err
+			}
+		}
+		tok.text = string(buf[start:i])
+		tok.span = span{src: src, start: start, end: i}
+		toks = append(toks, tok)
+		if tok.kind == tokEOF {
+			return toks, nil
+		}
+		prev, leading, newlines, newline = tok, nil, 0, false
+	}
+}
+
+// scanToken returns the kind and end of the token starting at offset.
+func scanToken(src *source, offset int) (tokenKind, int, error) {
+	buf := src.buf
+	c := buf[offset]
+	switch {
+	case isIDChar(c, true):
+		_, end := scanIdentifier(buf, offset)
+		return tokIdent, end, nil
+
+	case c == '0' && offset+1 < len(buf) && (buf[offset+1] == 'x' || buf[offset+1] == 'X'):
+		end := offset + 2
+		for end < len(buf) && isHexDigit(buf[end]) {
+			end++
+		}
+		return tokBytes, end, nil
+
+	case unicode.IsDigit(rune(c)):
+		end := offset
+		for end < len(buf) && unicode.IsDigit(rune(buf[end])) {
+			end++
+		}
+		return tokInt, end, nil
+
+	case c == '\'' || c == '"':
+		for i := offset + 1; i < len(buf); i++ {
+			switch buf[i] {
+			case c:
+				return tokString, i + 1, nil
+			case '\\':
+				if i+1 < len(buf) {
+					if _, ok := scanEscape(buf[i+1]); ok {
+						i++
+					}
+				}
+			}
+		}
+		return 0, 0, errorf(span{src: src, start: offset, end: offset + 1}, CodeSyntax, "unterminated string literal")
+	}
+
+	for _, p := range puncts {
+		if bytes.HasPrefix(buf[offset:], []byte(p)) {
+			return tokPunct, offset + len(p), nil
+		}
+	}
+	return 0, 0, errorf(span{src: src, start: offset, end: offset + 1}, CodeSyntax, "unexpected character %q", c)
+}
@@ -58,16 +58,16 @@ func propagateType(contract *Contract, clause *Clause, env *environ, t typeDesc,
 	if !ok {
 		return
 	}
-	if entry := env.lookup(string(v)); entry != nil {
+	if entry := env.lookup(v.name); entry != nil {
 		entry.t = t
 		for _, p := range contract.Params {
-			if p.Name == string(v) {
+			if p.Name == v.name {
 				p.InferredType = t
 				return
 			}
 		}
 		for _, p := range clause.Params {
-			if p.Name == string(v) {
+			if p.Name == v.name {
 				p.InferredType = t
 				return
 			}
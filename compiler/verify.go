@@ -0,0 +1,653 @@
+package compiler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/bytom/errors"
+	"github.com/bytom/protocol/vm"
+)
+
+// BytecodeReport is what VerifyBytecode finds in a program.
+type BytecodeReport struct {
+	// Needs is the most items that any path through the program reads
+	// from the data stack it starts with, if its depth is not known.
+	Needs int `json:"needs"`
+
+	// Unreachable is the offset of the first of each run of
+	// instructions that no path through the program reaches.
+	Unreachable []uint32 `json:"unreachable,omitempty"`
+
+	// Fails tells whether every path through the program fails.
+	Fails bool `json:"fails,omitempty"`
+}
+
+// VerifyBytecode checks prog, a control program or a contract body,
+// without running it. Every JUMP and JUMPIF must land on an
+// instruction of prog or at its end, no path may take more from the
+// data or alt stack than it holds, and every path that does not fail
+// must end with a result on the data stack. A path that ends with a
+// result known to be false fails, as does one that meets FAIL or a
+// VERIFY of a false item.
+//
+// depth is the number of items on the data stack when prog starts, or
+// -1 if it is not known, in which case prog may read as many as it
+// likes and the report says how many it does. Only the items that prog
+// pushes itself are known, so both ways of a JUMPIF on anything else
+// are followed.
+func VerifyBytecode(prog []byte, depth int) (*BytecodeReport, error) {
+	var start []verifyItem
+	for i := 0; i < depth; i++ {
+		start = append(start, verifyItem{})
+	}
+	return verifyBytecode(prog, [][]verifyItem{start}, depth >= 0)
+}
+
+// verifyBody verifies the body of contract, as it starts for a spend
+// through each clause: with the clause arguments, the clause selector
+// and the contract arguments, last first, and the body for a recursive
+// contract, on the data stack.
+func verifyBody(contract *Contract) (*BytecodeReport, error) {
+	var starts [][]verifyItem
+	for i, clause := range contract.Clauses {
+		var start []verifyItem
+		for range clause.Params {
+			start = append(start, verifyItem{})
+		}
+		if len(contract.Clauses) > 1 {
+			start = append(start, knownVerifyItem(vm.Int64Bytes(int64(i))))
+		}
+		for range contract.Params {
+			start = append(start, verifyItem{})
+		}
+		if contract.Recursive {
+			start = append(start, knownVerifyItem(contract.Body))
+		}
+		starts = append(starts, start)
+	}
+	return verifyBytecode(contract.Body, starts, true)
+}
+
+var (
+	errNoResult     = errors.New("a path ends with no result on the stack")
+	errTooManyPaths = errors.New("too many paths to verify")
+)
+
+// maxVerifyStates is the most states of the virtual machine that
+// verifyBytecode follows before it gives up, as it must for a program
+// that loops while its stack grows, and maxVerifyDepth the most items
+// it lets the stacks hold.
+const (
+	maxVerifyStates = 10000
+	maxVerifyDepth  = 1000
+)
+
+// verifyBytecode verifies prog run from each of starts. If bounded is
+// false, prog may read items below those of a start, which are not
+// known.
+func verifyBytecode(prog []byte, starts [][]verifyItem, bounded bool) (*BytecodeReport, error) {
+	insts, err := vm.ParseProgram(prog)
+	if err != nil {
+		return nil, err
+	}
+	boundaries := make(map[uint32]bool)
+	var pc uint32
+	for _, inst := range insts {
+		boundaries[pc] = true
+		pc += inst.Len
+	}
+	boundaries[pc] = true
+
+	pc = 0
+	for _, inst := range insts {
+		if inst.Op == vm.OP_JUMP || inst.Op == vm.OP_JUMPIF {
+			target := binary.LittleEndian.Uint32(inst.Data)
+			if !boundaries[target] {
+				return nil, fmt.Errorf("%s at %d jumps to %d, which is not the start of an instruction", inst.Op, pc, target)
+			}
+		}
+		pc += inst.Len
+	}
+
+	v := &verifier{prog: prog, bounded: bounded, reached: make(map[uint32]bool), seen: make(map[string]bool)}
+	for _, start := range starts {
+		v.queue = append(v.queue, &verifyState{stack: start})
+	}
+	for len(v.queue) > 0 {
+		st := v.queue[len(v.queue)-1]
+		v.queue = v.queue[:len(v.queue)-1]
+		if err := v.run(st); err != nil {
+			return nil, err
+		}
+	}
+
+	report := &BytecodeReport{Needs: v.needs, Fails: v.succeeds == 0}
+	pc = 0
+	for i, inst := range insts {
+		if !v.reached[pc] && (i == 0 || v.reached[pc-insts[i-1].Len]) {
+			report.Unreachable = append(report.Unreachable, pc)
+		}
+		pc += inst.Len
+	}
+	return report, nil
+}
+
+// verifyItem is what the verifier knows of an item on a stack.
+type verifyItem struct {
+	data  []byte
+	known bool
+
+	// depth tells whether the item is the depth of the whole stack,
+	// pushed by DEPTH where that is not known, as a count for
+	// CHECKPREDICATE to take all the items below it.
+	depth bool
+}
+
+func knownVerifyItem(data []byte) verifyItem {
+	return verifyItem{data: data, known: true}
+}
+
+// verifyState is the state of the virtual machine on one path through
+// a program.
+type verifyState struct {
+	pc         uint32
+	stack, alt []verifyItem
+
+	// drawn is how many items the path has read from below the items
+	// it started with.
+	drawn int
+}
+
+// key identifies st among the states the verifier has followed.
+func (st *verifyState) key() string {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "%d %d", st.pc, st.drawn)
+	for _, items := range [][]verifyItem{st.stack, st.alt} {
+		buf.WriteString(" |")
+		for _, item := range items {
+			switch {
+			case item.known:
+				buf.WriteString(" " + hex.EncodeToString(item.data))
+			case item.depth:
+				buf.WriteString(" depth")
+			default:
+				buf.WriteString(" ?")
+			}
+		}
+	}
+	return buf.String()
+}
+
+func (st *verifyState) fork(pc uint32) *verifyState {
+	return &verifyState{
+		pc:    pc,
+		stack: append([]verifyItem{}, st.stack...),
+		alt:   append([]verifyItem{}, st.alt...),
+		drawn: st.drawn,
+	}
+}
+
+type verifier struct {
+	prog    []byte
+	bounded bool
+	queue   []*verifyState
+	reached map[uint32]bool
+	seen    map[string]bool
+
+	needs    int
+	succeeds int
+}
+
+// need makes sure that st has n items on its data stack, drawing
+// unknown ones from below those it started with if the program may.
+func (v *verifier) need(st *verifyState, n int) error {
+	if n <= len(st.stack) {
+		return nil
+	}
+	if v.bounded {
+		return fmt.Errorf("%s at %d: %s", v.opAt(st.pc), st.pc, vm.ErrDataStackUnderflow)
+	}
+	more := n - len(st.stack)
+	st.stack = append(make([]verifyItem, more), st.stack...)
+	st.drawn += more
+	return nil
+}
+
+func (v *verifier) pop(st *verifyState, n int) ([]verifyItem, error) {
+	if err := v.need(st, n); err != nil {
+		return nil, err
+	}
+	items := append([]verifyItem{}, st.stack[len(st.stack)-n:]...)
+	st.stack = st.stack[:len(st.stack)-n]
+	return items, nil
+}
+
+// popCount pops an item that must be a known integer, as the count of
+// items that op takes.
+func (v *verifier) popCount(st *verifyState) (int64, error) {
+	items, err := v.pop(st, 1)
+	if err != nil {
+		return 0, err
+	}
+	if !items[0].known {
+		return 0, fmt.Errorf("%s at %d takes a count that is not known", v.opAt(st.pc), st.pc)
+	}
+	n, err := vm.AsInt64(items[0].data)
+	if err != nil {
+		return 0, fmt.Errorf("%s at %d: %s", v.opAt(st.pc), st.pc, err)
+	}
+	return n, nil
+}
+
+// checkCount returns n, a count of items that the instruction at
+// st.pc takes from the data stack, if the verifier may follow a path
+// on which the stack holds that many: no more than it does, if the
+// program may not read below the items it starts with, and otherwise
+// no more than maxVerifyDepth more.
+func (v *verifier) checkCount(st *verifyState, n int64) (int, error) {
+	switch {
+	case v.bounded && n > int64(len(st.stack)):
+		return 0, fmt.Errorf("%s at %d: %s", v.opAt(st.pc), st.pc, vm.ErrDataStackUnderflow)
+	case n > int64(len(st.stack)+maxVerifyDepth):
+		return 0, fmt.Errorf("%s at %d takes %d items, too many to verify", v.opAt(st.pc), st.pc, n)
+	}
+	return int(n), nil
+}
+
+func (v *verifier) opAt(pc uint32) vm.Op {
+	return vm.Op(v.prog[pc])
+}
+
+// run follows st until its path ends, queueing the states on the
+// other ways of the JUMPIFs it meets.
+func (v *verifier) run(st *verifyState) error {
+	for {
+		if v.seen[st.key()] {
+			return nil
+		}
+		if len(v.seen) >= maxVerifyStates || len(st.stack)+len(st.alt) > maxVerifyDepth {
+			return errTooManyPaths
+		}
+		v.seen[st.key()] = true
+		if st.drawn > v.needs {
+			v.needs = st.drawn
+		}
+
+		if st.pc >= uint32(len(v.prog)) {
+			if len(st.stack) == 0 {
+				if v.bounded {
+					return errNoResult
+				}
+				st.drawn++
+				if st.drawn > v.needs {
+					v.needs = st.drawn
+				}
+				v.succeeds++
+				return nil
+			}
+			if top := st.stack[len(st.stack)-1]; !top.known || vm.AsBool(top.data) {
+				v.succeeds++
+			}
+			return nil
+		}
+		v.reached[st.pc] = true
+
+		inst, err := vm.ParseOp(v.prog, st.pc)
+		if err != nil {
+			return err
+		}
+		next := st.pc + inst.Len
+
+		switch inst.Op {
+		case vm.OP_JUMP:
+			next = binary.LittleEndian.Uint32(inst.Data)
+
+		case vm.OP_JUMPIF:
+			items, err := v.pop(st, 1)
+			if err != nil {
+				return err
+			}
+			target := binary.LittleEndian.Uint32(inst.Data)
+			switch {
+			case !items[0].known:
+				v.queue = append(v.queue, st.fork(target))
+			case vm.AsBool(items[0].data):
+				next = target
+			}
+
+		case vm.OP_FAIL:
+			return nil
+
+		default:
+			fails, err := v.step(st, inst)
+			if err != nil {
+				return err
+			}
+			if fails {
+				return nil
+			}
+		}
+		st.pc = next
+	}
+}
+
+// verifyArity is the number of items that each instruction with a fixed
+// effect on the data stack pops, and the number it pushes, none of
+// which is known.
+var verifyArity = map[vm.Op][2]int{
+	vm.OP_INVERT: {1, 1}, vm.OP_1ADD: {1, 1}, vm.OP_1SUB: {1, 1}, vm.OP_2MUL: {1, 1}, vm.OP_2DIV: {1, 1},
+	vm.OP_NEGATE: {1, 1}, vm.OP_ABS: {1, 1}, vm.OP_SHA256: {1, 1}, vm.OP_SHA3: {1, 1}, vm.OP_HASH160: {1, 1},
+
+	vm.OP_CAT: {2, 1}, vm.OP_CATPUSHDATA: {2, 1}, vm.OP_LEFT: {2, 1}, vm.OP_RIGHT: {2, 1},
+	vm.OP_AND: {2, 1}, vm.OP_OR: {2, 1}, vm.OP_XOR: {2, 1},
+	vm.OP_ADD: {2, 1}, vm.OP_SUB: {2, 1}, vm.OP_MUL: {2, 1}, vm.OP_DIV: {2, 1}, vm.OP_MOD: {2, 1},
+	vm.OP_LSHIFT: {2, 1}, vm.OP_RSHIFT: {2, 1}, vm.OP_BOOLAND: {2, 1}, vm.OP_BOOLOR: {2, 1},
+	vm.OP_MIN: {2, 1}, vm.OP_MAX: {2, 1},
+
+	vm.OP_SUBSTR: {3, 1}, vm.OP_WITHIN: {3, 1}, vm.OP_CHECKSIG: {3, 1},
+	vm.OP_CHECKOUTPUT: {5, 1},
+
+	vm.OP_TXSIGHASH: {0, 1}, vm.OP_ASSET: {0, 1}, vm.OP_AMOUNT: {0, 1}, vm.OP_PROGRAM: {0, 1},
+	vm.OP_INDEX: {0, 1}, vm.OP_ENTRYID: {0, 1}, vm.OP_OUTPUTID: {0, 1}, vm.OP_BLOCKHEIGHT: {0, 1},
+
+	vm.OP_NOP: {0, 0},
+}
+
+// step applies the effect of inst, other than a jump or FAIL, on the
+// stacks of st, as far as it is known. It returns true if inst always
+// fails on this path.
+func (v *verifier) step(st *verifyState, inst vm.Instruction) (bool, error) {
+	op := inst.Op
+	switch {
+	case inst.IsPushdata():
+		st.stack = append(st.stack, knownVerifyItem(inst.Data))
+		return false, nil
+
+	case op == vm.OP_1NEGATE:
+		st.stack = append(st.stack, knownVerifyItem(vm.Int64Bytes(-1)))
+		return false, nil
+	}
+
+	if arity, ok := verifyArity[op]; ok {
+		if _, err := v.pop(st, arity[0]); err != nil {
+			return false, err
+		}
+		for i := 0; i < arity[1]; i++ {
+			st.stack = append(st.stack, verifyItem{})
+		}
+		return false, nil
+	}
+
+	switch op {
+	case vm.OP_VERIFY:
+		items, err := v.pop(st, 1)
+		if err != nil {
+			return false, err
+		}
+		return items[0].known && !vm.AsBool(items[0].data), nil
+
+	case vm.OP_TOALTSTACK:
+		items, err := v.pop(st, 1)
+		if err != nil {
+			return false, err
+		}
+		st.alt = append(st.alt, items[0])
+
+	case vm.OP_FROMALTSTACK:
+		if len(st.alt) == 0 {
+			return false, fmt.Errorf("%s at %d: %s", op, st.pc, vm.ErrAltStackUnderflow)
+		}
+		st.stack = append(st.stack, st.alt[len(st.alt)-1])
+		st.alt = st.alt[:len(st.alt)-1]
+
+	case vm.OP_DUP, vm.OP_2DUP, vm.OP_3DUP, vm.OP_OVER, vm.OP_2OVER:
+		// copy n items from the given depth
+		n, depth := 1, 1
+		switch op {
+		case vm.OP_2DUP:
+			n, depth = 2, 2
+		case vm.OP_3DUP:
+			n, depth = 3, 3
+		case vm.OP_OVER:
+			depth = 2
+		case vm.OP_2OVER:
+			n, depth = 2, 4
+		}
+		if err := v.need(st, depth); err != nil {
+			return false, err
+		}
+		from := len(st.stack) - depth
+		st.stack = append(st.stack, st.stack[from:from+n]...)
+
+	case vm.OP_DROP, vm.OP_2DROP:
+		n := 1
+		if op == vm.OP_2DROP {
+			n = 2
+		}
+		if _, err := v.pop(st, n); err != nil {
+			return false, err
+		}
+
+	case vm.OP_NIP, vm.OP_SWAP, vm.OP_TUCK, vm.OP_ROT, vm.OP_2ROT, vm.OP_2SWAP:
+		// rearrange the top items
+		var order []int
+		switch op {
+		case vm.OP_NIP:
+			order = []int{1}
+		case vm.OP_SWAP:
+			order = []int{1, 0}
+		case vm.OP_TUCK:
+			order = []int{1, 0, 1}
+		case vm.OP_ROT:
+			order = []int{1, 2, 0}
+		case vm.OP_2ROT:
+			order = []int{2, 3, 4, 5, 0, 1}
+		case vm.OP_2SWAP:
+			order = []int{2, 3, 0, 1}
+		}
+		n := 2
+		switch op {
+		case vm.OP_ROT:
+			n = 3
+		case vm.OP_2ROT:
+			n = 6
+		case vm.OP_2SWAP:
+			n = 4
+		}
+		items, err := v.pop(st, n)
+		if err != nil {
+			return false, err
+		}
+		for _, i := range order {
+			st.stack = append(st.stack, items[i])
+		}
+
+	case vm.OP_PICK, vm.OP_ROLL:
+		n, err := v.popCount(st)
+		if err != nil {
+			return false, err
+		}
+		if n < 0 {
+			return true, nil
+		}
+		k, err := v.checkCount(st, n)
+		if err != nil {
+			return false, err
+		}
+		if err := v.need(st, k+1); err != nil {
+			return false, err
+		}
+		i := len(st.stack) - 1 - k
+		item := st.stack[i]
+		if op == vm.OP_ROLL {
+			st.stack = append(st.stack[:i], st.stack[i+1:]...)
+		}
+		st.stack = append(st.stack, item)
+
+	case vm.OP_IFDUP:
+		if err := v.need(st, 1); err != nil {
+			return false, err
+		}
+		top := st.stack[len(st.stack)-1]
+		switch {
+		case !top.known:
+			// Both may happen.
+			dup := st.fork(st.pc + inst.Len)
+			dup.stack = append(dup.stack, top)
+			v.queue = append(v.queue, dup)
+		case vm.AsBool(top.data):
+			st.stack = append(st.stack, top)
+		}
+
+	case vm.OP_DEPTH:
+		if v.bounded {
+			st.stack = append(st.stack, knownVerifyItem(vm.Int64Bytes(int64(len(st.stack)))))
+		} else {
+			st.stack = append(st.stack, verifyItem{depth: true})
+		}
+
+	case vm.OP_SIZE:
+		if err := v.need(st, 1); err != nil {
+			return false, err
+		}
+		item := verifyItem{}
+		if top := st.stack[len(st.stack)-1]; top.known {
+			item = knownVerifyItem(vm.Int64Bytes(int64(len(top.data))))
+		}
+		st.stack = append(st.stack, item)
+
+	case vm.OP_NOT, vm.OP_0NOTEQUAL:
+		items, err := v.pop(st, 1)
+		if err != nil {
+			return false, err
+		}
+		item := verifyItem{}
+		if items[0].known {
+			b := vm.AsBool(items[0].data)
+			if op == vm.OP_NOT {
+				b = !b
+			}
+			item = knownVerifyItem(vm.BoolBytes(b))
+		}
+		st.stack = append(st.stack, item)
+
+	case vm.OP_EQUAL, vm.OP_EQUALVERIFY:
+		items, err := v.pop(st, 2)
+		if err != nil {
+			return false, err
+		}
+		known := items[0].known && items[1].known
+		equal := known && bytes.Equal(items[0].data, items[1].data)
+		if op == vm.OP_EQUALVERIFY {
+			return known && !equal, nil
+		}
+		item := verifyItem{}
+		if known {
+			item = knownVerifyItem(vm.BoolBytes(equal))
+		}
+		st.stack = append(st.stack, item)
+
+	case vm.OP_NUMEQUAL, vm.OP_NUMEQUALVERIFY, vm.OP_NUMNOTEQUAL,
+		vm.OP_LESSTHAN, vm.OP_GREATERTHAN, vm.OP_LESSTHANOREQUAL, vm.OP_GREATERTHANOREQUAL:
+		items, err := v.pop(st, 2)
+		if err != nil {
+			return false, err
+		}
+		item := verifyItem{}
+		if items[0].known && items[1].known {
+			x, errx := vm.AsInt64(items[0].data)
+			y, erry := vm.AsInt64(items[1].data)
+			if errx != nil || erry != nil {
+				// not numbers
+				return true, nil
+			}
+			var b bool
+			switch op {
+			case vm.OP_NUMEQUAL, vm.OP_NUMEQUALVERIFY:
+				b = x == y
+			case vm.OP_NUMNOTEQUAL:
+				b = x != y
+			case vm.OP_LESSTHAN:
+				b = x < y
+			case vm.OP_GREATERTHAN:
+				b = x > y
+			case vm.OP_LESSTHANOREQUAL:
+				b = x <= y
+			default:
+				b = x >= y
+			}
+			item = knownVerifyItem(vm.BoolBytes(b))
+		}
+		if op == vm.OP_NUMEQUALVERIFY {
+			return item.known && !vm.AsBool(item.data), nil
+		}
+		st.stack = append(st.stack, item)
+
+	case vm.OP_CHECKMULTISIG:
+		numPubkeys, err := v.popCount(st)
+		if err != nil {
+			return false, err
+		}
+		numSigs, err := v.popCount(st)
+		if err != nil {
+			return false, err
+		}
+		if numPubkeys < 0 || numSigs < 0 {
+			return true, nil
+		}
+		if _, err := v.checkCount(st, numPubkeys); err != nil {
+			return false, err
+		}
+		if _, err := v.checkCount(st, numSigs); err != nil {
+			return false, err
+		}
+		n, err := v.checkCount(st, numPubkeys+1+numSigs)
+		if err != nil {
+			return false, err
+		}
+		if _, err := v.pop(st, n); err != nil {
+			return false, err
+		}
+		st.stack = append(st.stack, verifyItem{})
+
+	case vm.OP_CHECKPREDICATE:
+		if _, err := v.pop(st, 2); err != nil {
+			return false, err
+		}
+		if err := v.need(st, 1); err != nil {
+			return false, err
+		}
+		if st.stack[len(st.stack)-1].depth {
+			// The predicate takes the rest of the stack, whatever
+			// its depth.
+			st.stack = []verifyItem{{}}
+			break
+		}
+		n, err := v.popCount(st)
+		if err != nil {
+			return false, err
+		}
+		if n < 0 {
+			n = int64(len(st.stack))
+		}
+		k, err := v.checkCount(st, n)
+		if err != nil {
+			return false, err
+		}
+		if _, err := v.pop(st, k); err != nil {
+			return false, err
+		}
+		st.stack = append(st.stack, verifyItem{})
+
+	default:
+		// the expansion opcodes, which do nothing
+		if strings.HasPrefix(op.String(), "NOPx") {
+			break
+		}
+		return false, fmt.Errorf("cannot verify %s at %d", op, st.pc)
+	}
+	return false, nil
+}
@@ -0,0 +1,134 @@
+package compiler
+
+import (
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bytom/protocol/vm"
+)
+
+func TestVerifyBytecode(t *testing.T) {
+	cases := []struct {
+		prog  string
+		depth int
+		want  BytecodeReport
+	}{
+		{"1", 0, BytecodeReport{}},
+		{"ADD", -1, BytecodeReport{Needs: 2}},
+		{"3 PICK", -1, BytecodeReport{Needs: 4}},
+		{"DROP DEPTH", 3, BytecodeReport{}},
+		{"DEPTH 3 NUMEQUAL JUMPIF:$three 0 $three", 3, BytecodeReport{Unreachable: []uint32{8}}},
+		{"0 JUMPIF:$a 1 JUMP:$b $a 2 3 $b", 0, BytecodeReport{Unreachable: []uint32{12}}},
+		{"VERIFY 1", -1, BytecodeReport{Needs: 1}},
+		{"0 VERIFY 1", 0, BytecodeReport{Unreachable: []uint32{2}, Fails: true}},
+		{"FALSE", 0, BytecodeReport{Fails: true}},
+		{"FAIL", 0, BytecodeReport{Fails: true}},
+		{"1 TOALTSTACK FROMALTSTACK", 0, BytecodeReport{}},
+		{"$top 1 DROP JUMP:$top", 0, BytecodeReport{Fails: true}},
+		{"DEPTH 0x00 0 CHECKPREDICATE", -1, BytecodeReport{}},
+	}
+	for _, c := range cases {
+		prog, err := vm.Assemble(c.prog)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := VerifyBytecode(prog, c.depth)
+		if err != nil {
+			t.Errorf("%s: %s", c.prog, err)
+			continue
+		}
+		if !reflect.DeepEqual(*got, c.want) {
+			t.Errorf("%s: got %+v, want %+v", c.prog, *got, c.want)
+		}
+	}
+}
+
+func TestVerifyBytecodeErrors(t *testing.T) {
+	cases := []struct {
+		prog  string
+		depth int
+		want  string
+	}{
+		{"DROP", 0, "DROP at 0: data stack underflow"},
+		{"1 2 5 PICK", 2, "PICK at 3: data stack underflow"},
+		{"FROMALTSTACK", -1, "FROMALTSTACK at 0: alt stack underflow"},
+		{"1 DROP", 0, "a path ends with no result on the stack"},
+		{"DUP JUMPIF:$a 1 $a", 0, "DUP at 0: data stack underflow"},
+		{"PICK", 2, "PICK at 0 takes a count that is not known"},
+		{"$top 1 JUMP:$top", 0, "too many paths to verify"},
+		{"9223372036854775807 9223372036854775807 CHECKMULTISIG", -1, "CHECKMULTISIG at 18 takes 9223372036854775807 items, too many to verify"},
+		{"1 9223372036854775807 CHECKMULTISIG", 3, "CHECKMULTISIG at 10: data stack underflow"},
+		{"9223372036854775807 0 0 CHECKPREDICATE", -1, "CHECKPREDICATE at 11 takes 9223372036854775807 items, too many to verify"},
+	}
+	for _, c := range cases {
+		prog, err := vm.Assemble(c.prog)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = VerifyBytecode(prog, c.depth)
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: got error %v, want %q", c.prog, err, c.want)
+		}
+	}
+
+	// Counts too large to take from any stack, from untrusted programs.
+	counts := []struct {
+		prog  string
+		depth int
+		want  string
+	}{
+		{"08ffffffffffffff7f79", 1, "PICK at 9: data stack underflow"},
+		{"040000008079", -1, "PICK at 5 takes 2147483648 items, too many to verify"},
+		{"0400ca9a3b79", -1, "PICK at 5 takes 1000000000 items, too many to verify"},
+	}
+	for _, c := range counts {
+		b, _ := hex.DecodeString(c.prog)
+		_, err := VerifyBytecode(b, c.depth)
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: got error %v, want %q", c.prog, err, c.want)
+		}
+	}
+
+	for _, prog := range []string{"630200000051", "6306000000", "4c05"} {
+		b, _ := hex.DecodeString(prog)
+		if _, err := VerifyBytecode(b, 0); err == nil {
+			t.Errorf("%s: got no error", prog)
+		}
+	}
+}
+
+func TestVerifyBody(t *testing.T) {
+	for _, src := range []string{lockWithDeadline, threeClauses, manyClauses(8)} {
+		contracts, err := Compile(strings.NewReader(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		report, err := verifyBody(contracts[0])
+		if err != nil || len(report.Unreachable) > 0 || report.Fails {
+			t.Errorf("%s: got %+v, error %v", contracts[0].Name, report, err)
+		}
+	}
+
+	const constantIf = `
+contract Constant(x: Integer) locks value of asset {
+  clause spend(y: Integer) {
+    if 1 < 2 {
+      verify y == x
+    } else {
+      verify y > x
+    }
+    unlock value of asset
+  }
+}
+`
+	contracts, err := Compile(strings.NewReader(constantIf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	warnings := contracts[0].Warnings
+	if len(warnings) != 1 || warnings[0].Code != CodeUnreachable || warnings[0].Line != 7 {
+		t.Errorf("got warnings %v, want the else branch unreachable", warnings)
+	}
+}
@@ -0,0 +1,142 @@
+package compiler
+
+import (
+	"fmt"
+
+	"github.com/bytom/errors"
+	"github.com/bytom/protocol/vm"
+)
+
+// WitnessFor returns the arguments that spend an instantiation of
+// contract through the named clause, in the order they are given to
+// the program: the clause arguments in declaration order (unlike the
+// contract arguments, which Instantiate pushes last first), then the
+// clause selector if the contract has more than one clause.
+//
+// The selector is the index of the clause in Contract.Clauses,
+// encoded as by vm.Int64Bytes. It is not the clause offset reported
+// by the --shift flag, which is where the body jumps to for the clause.
+func WitnessFor(contract *Contract, clauseName string, args []ContractArg) ([][]byte, error) {
+	for i, clause := range contract.Clauses {
+		if clause.Name != clauseName {
+			continue
+		}
+		if len(args) != len(clause.Params) {
+			return nil, fmt.Errorf("got %d argument(s) for clause \"%s\", want %d", len(args), clauseName, len(clause.Params))
+		}
+		if err := checkArgs(clause.Params, args); err != nil {
+			return nil, errors.Wrapf(err, "clause \"%s\"", clauseName)
+		}
+
+		var result [][]byte
+		for _, arg := range args {
+			result = append(result, argBytes(arg))
+		}
+		if len(contract.Clauses) > 1 {
+			result = append(result, vm.Int64Bytes(int64(i)))
+		}
+		return result, nil
+	}
+	return nil, fmt.Errorf("contract \"%s\" has no clause \"%s\"", contract.Name, clauseName)
+}
+
+// Spend is a spend of a contract, decoded from the program and the
+// arguments of the spending input.
+type Spend struct {
+	// Clause is the clause through which the contract is spent.
+	Clause *Clause
+
+	// Args and ClauseArgs are the contract and clause arguments, keyed
+	// by parameter name. Args is nil if the program is not known.
+	Args       map[string]ContractArg
+	ClauseArgs map[string]ContractArg
+}
+
+// DecodeWitness works out which clause of contract a spend uses, from
+// the clause selector in witness, the arguments of the spending input,
+// and decodes the clause arguments. It is the inverse of WitnessFor.
+// If prog, the program being spent, is not nil, it must instantiate
+// contract, and the contract arguments are decoded too.
+//
+// The clause is the one the contract body jumps to for the selector,
+// so that selectors not made by WitnessFor, such as the clause offsets
+// printed by --shift, are decoded as the virtual machine runs them.
+func DecodeWitness(contract *Contract, prog []byte, witness [][]byte) (*Spend, error) {
+	spend := new(Spend)
+	if prog != nil {
+		var err error
+		if spend.Args, err = ParseInstance(contract, prog); err != nil {
+			return nil, err
+		}
+	}
+
+	args := witness
+	spend.Clause = contract.Clauses[0]
+	if len(contract.Clauses) > 1 {
+		if len(witness) == 0 {
+			return nil, errors.New("no clause selector")
+		}
+		args = witness[:len(witness)-1]
+		var err error
+		if spend.Clause, err = selectClause(contract, witness[len(witness)-1]); err != nil {
+			return nil, err
+		}
+	}
+
+	params := spend.Clause.Params
+	if len(args) != len(params) {
+		return nil, fmt.Errorf("got %d argument(s) for clause \"%s\", want %d", len(args), spend.Clause.Name, len(params))
+	}
+	spend.ClauseArgs = make(map[string]ContractArg)
+	for i, param := range params {
+		arg, err := decodeArg(param, args[i], false)
+		if err != nil {
+			return nil, errors.Wrapf(err, "clause \"%s\"", spend.Clause.Name)
+		}
+		spend.ClauseArgs[param.Name] = arg
+	}
+	return spend, nil
+}
+
+// selectClause returns the clause that the body of contract, which
+// has more than one clause, runs for selector.
+func selectClause(contract *Contract, selector []byte) (*Clause, error) {
+	if contract.Dispatch == DispatchSearch {
+		n, err := vm.AsInt64(selector)
+		if err != nil {
+			return nil, fmt.Errorf("bad clause selector %x", selector)
+		}
+		return contract.Clauses[searchClause(len(contract.Clauses), n)], nil
+	}
+
+	// Clauses 2 and later are selected with NUMEQUAL, which fails
+	// for a selector that is not an integer.
+	if len(contract.Clauses) > 2 {
+		n, err := vm.AsInt64(selector)
+		if err != nil {
+			return nil, fmt.Errorf("bad clause selector %x", selector)
+		}
+		if n >= 2 && n < int64(len(contract.Clauses)) {
+			return contract.Clauses[n], nil
+		}
+	}
+	// clause 1 with JUMPIF, or clause 0
+	if vm.AsBool(selector) {
+		return contract.Clauses[1], nil
+	}
+	return contract.Clauses[0], nil
+}
+
+// argBytes returns the value the virtual machine has on its stack for
+// arg.
+func argBytes(arg ContractArg) []byte {
+	switch {
+	case arg.B != nil:
+		return vm.BoolBytes(*arg.B)
+	case arg.I != nil:
+		return vm.Int64Bytes(*arg.I)
+	case arg.S != nil:
+		return *arg.S
+	}
+	return nil
+}
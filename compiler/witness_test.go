@@ -0,0 +1,151 @@
+package compiler
+
+import (
+	"bytes"
+	"testing"
+
+	chainjson "github.com/bytom/encoding/json"
+)
+
+const threeClauses = `
+contract Escrow(agent: PublicKey, sender: Program, recipient: Program) locks value of asset {
+  clause approve(sig: Signature) {
+    verify checkTxSig(agent, sig)
+    lock value of asset with recipient
+  }
+  clause reject(sig: Signature) {
+    verify checkTxSig(agent, sig)
+    lock value of asset with sender
+  }
+  clause split(sig: Signature, n: Integer, all: Boolean) {
+    verify checkTxSig(agent, sig)
+    verify n > 0 || all
+    unlock value of asset
+  }
+}
+`
+
+func TestWitnessFor(t *testing.T) {
+	sig := chainjson.HexBytes(bytes.Repeat([]byte{0x5a}, 64))
+	n, yes := int64(300), true
+
+	escrow := mustCompile(t, threeClauses)
+	cases := []struct {
+		clause string
+		args   []ContractArg
+		want   [][]byte
+	}{
+		{"approve", []ContractArg{{S: &sig}}, [][]byte{sig, {}}},
+		{"reject", []ContractArg{{S: &sig}}, [][]byte{sig, {1}}},
+		{"split", []ContractArg{{S: &sig}, {I: &n}, {B: &yes}}, [][]byte{sig, {0x2c, 0x01}, {1}, {2}}},
+	}
+	for _, c := range cases {
+		got, err := WitnessFor(escrow, c.clause, c.args)
+		if err != nil {
+			t.Fatalf("%s: %v", c.clause, err)
+		}
+		if len(got) != len(c.want) {
+			t.Fatalf("%s: got %x, want %x", c.clause, got, c.want)
+		}
+		for i := range got {
+			if !bytes.Equal(got[i], c.want[i]) {
+				t.Errorf("%s: got %x, want %x", c.clause, got, c.want)
+			}
+		}
+	}
+
+	// no selector for a single clause
+	lock := mustCompile(t, lockWithDeadline)
+	single := mustCompile(t, `
+contract Single(key: PublicKey) locks value of asset {
+  clause spend(sig: Signature) {
+    verify checkTxSig(key, sig)
+    unlock value of asset
+  }
+}
+`)
+	got, err := WitnessFor(single, "spend", []ContractArg{{S: &sig}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Errorf("got %x, want the signature alone", got)
+	}
+
+	for _, bad := range []struct {
+		clause string
+		args   []ContractArg
+	}{
+		{"frob", nil},
+		{"spend", nil},
+		{"spend", []ContractArg{{I: &n}}},
+	} {
+		if _, err := WitnessFor(lock, bad.clause, bad.args); err == nil {
+			t.Errorf("WitnessFor(%s, %+v) succeeded", bad.clause, bad.args)
+		}
+	}
+}
+
+func TestDecodeWitness(t *testing.T) {
+	sig := chainjson.HexBytes(bytes.Repeat([]byte{0x5a}, 64))
+	agent := chainjson.HexBytes(bytes.Repeat([]byte{0xaa}, 32))
+	dest := chainjson.HexBytes{0x00, 0x14, 0xba}
+	n, no := int64(300), false
+
+	escrow := mustCompile(t, threeClauses)
+	prog, err := Instantiate(escrow.Body, escrow.Params, escrow.Recursive, []ContractArg{{S: &agent}, {S: &dest}, {S: &dest}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	witness, err := WitnessFor(escrow, "split", []ContractArg{{S: &sig}, {I: &n}, {B: &no}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	spend, err := DecodeWitness(escrow, prog, witness)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spend.Clause.Name != "split" || !bytes.Equal(*spend.Args["agent"].S, agent) {
+		t.Errorf("got clause %s, args %+v", spend.Clause.Name, spend.Args)
+	}
+	if !bytes.Equal(*spend.ClauseArgs["sig"].S, sig) || *spend.ClauseArgs["n"].I != n || *spend.ClauseArgs["all"].B {
+		t.Errorf("got clause args %+v", spend.ClauseArgs)
+	}
+
+	// selectors as the body reads them
+	for _, c := range []struct {
+		selector []byte
+		want     string
+	}{
+		{nil, "approve"},
+		{[]byte{0}, "approve"},
+		{[]byte{1}, "reject"},
+		{[]byte{0x25, 0, 0, 0}, "reject"}, // a clause offset
+		{[]byte{2, 0}, "split"},
+	} {
+		w := [][]byte{sig, c.selector}
+		if c.want == "split" {
+			w = [][]byte{sig, {1}, {}, c.selector}
+		}
+		spend, err := DecodeWitness(escrow, nil, w)
+		if err != nil {
+			t.Fatalf("selector %x: %v", c.selector, err)
+		}
+		if spend.Clause.Name != c.want || spend.Args != nil {
+			t.Errorf("selector %x: got clause %s, want %s", c.selector, spend.Clause.Name, c.want)
+		}
+	}
+
+	for _, bad := range [][][]byte{
+		nil,                     // no selector
+		{sig, {1}, {}, {2}, {}}, // too many arguments
+		{sig, make([]byte, 9)},  // selector too long for NUMEQUAL
+	} {
+		if _, err := DecodeWitness(escrow, nil, bad); err == nil {
+			t.Errorf("DecodeWitness(%x) succeeded", bad)
+		}
+	}
+	if _, err := DecodeWitness(mustCompile(t, lockWithDeadline), prog, witness); err != ErrBodyMismatch {
+		t.Errorf("got error %v for another contract's program, want ErrBodyMismatch", err)
+	}
+}
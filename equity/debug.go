@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/equity/equity/debug"
+)
+
+var debugBreak []int
+
+func init() {
+	addRunFlags(debugCmd)
+	debugCmd.Flags().IntSliceVarP(&debugBreak, "break", "b", nil, "Comma-separated source lines on which to set breakpoints.")
+	equityCmd.AddCommand(debugCmd)
+}
+
+var debugCmd = &cobra.Command{
+	Use:   "debug <input_file> <clause> [clause_args...]",
+	Short: "Step through a contract clause on a local BVM",
+	Long: `Run a contract clause on a local BVM, as equity run does, and step
+through the instructions it executed. Each stop shows the Equity
+statement being executed, the stack with the names the compiler gave its
+values, and the gas remaining. Type "help" at the prompt for commands.`,
+	Example: "equity debug LockWithDeadline.equity expire --args <publicKey>,100,<dest> --height 101 --break 16",
+	Args:    cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		contract, res, err := runClause(args)
+		if err != nil {
+			os.Exit(-1)
+		}
+		d := debug.NewDebugger(contract, res, os.Stdin, os.Stdout)
+		for _, line := range debugBreak {
+			d.Break(line)
+		}
+		if err := d.Run(); err != nil {
+			fmt.Fprintln(os.Stderr, "Debugger error:", err)
+			os.Exit(-1)
+		}
+	},
+}
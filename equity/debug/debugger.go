@@ -0,0 +1,301 @@
+// Package debug steps through a run of a contract clause recorded by
+// the simulator, relating each instruction to the Equity statement it
+// was compiled from, and the virtual machine's stack to the names the
+// compiler gave its values.
+package debug
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bytom/errors"
+
+	"github.com/equity/compiler"
+	"github.com/equity/simulate"
+)
+
+// Debugger replays the instructions of a run, stopping after each
+// step or at breakpoints on source lines.
+type Debugger struct {
+	contract *compiler.Contract
+	res      *simulate.Result
+
+	in  *bufio.Scanner
+	out io.Writer
+
+	// cur is the index in the trace of the last instruction executed,
+	// or -1 before the first.
+	cur         int
+	breakpoints map[int]bool
+
+	// source lines by file name
+	sources map[string][]string
+}
+
+// NewDebugger returns a debugger for res, the result of running a
+// clause of contract, reading commands from r and writing to w.
+func NewDebugger(contract *compiler.Contract, res *simulate.Result, r io.Reader, w io.Writer) *Debugger {
+	return &Debugger{
+		contract:    contract,
+		res:         res,
+		in:          bufio.NewScanner(r),
+		out:         w,
+		cur:         -1,
+		breakpoints: make(map[int]bool),
+		sources:     make(map[string][]string),
+	}
+}
+
+// Break sets a breakpoint on a source line. Execution stops after the
+// first instruction of each statement that begins on the line.
+func (d *Debugger) Break(line int) {
+	d.breakpoints[line] = true
+}
+
+const help = `Commands:
+  step, s [n]     run the next instruction, or the next n
+  next, n         run to the next statement
+  continue, c     run to the next breakpoint, the failure or the end
+  break, b <line> set a breakpoint on a source line
+  clear <line>    remove the breakpoint on a line
+  breakpoints     list the breakpoints
+  print, p        print the current instruction and stack
+  help, h         print this help
+  quit, q         stop debugging
+An empty line repeats the previous command.`
+
+// Run reads and executes commands until the input ends or the user
+// quits.
+func (d *Debugger) Run() error {
+	fmt.Fprintf(d.out, "%d instructions recorded. Type \"help\" for commands.\n", len(d.res.Trace))
+	var last []string
+	for {
+		fmt.Fprint(d.out, "(debug) ")
+		if !d.in.Scan() {
+			fmt.Fprintln(d.out)
+			return d.in.Err()
+		}
+		fields := strings.Fields(d.in.Text())
+		if len(fields) == 0 {
+			fields = last
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		last = fields
+		if fields[0] == "quit" || fields[0] == "q" {
+			return nil
+		}
+		if err := d.command(fields[0], fields[1:]); err != nil {
+			fmt.Fprintln(d.out, err)
+		}
+	}
+}
+
+func (d *Debugger) command(cmd string, args []string) error {
+	switch cmd {
+	case "step", "s":
+		n := 1
+		if len(args) > 0 {
+			var err error
+			if n, err = strconv.Atoi(args[0]); err != nil || n < 1 {
+				return fmt.Errorf("bad count \"%s\"", args[0])
+			}
+		}
+		for ; n > 0 && d.step(); n-- {
+		}
+		d.show()
+
+	case "next", "n":
+		if d.step() {
+			stmt := d.statement(d.cur)
+			for !d.failed(d.cur) && d.cur+1 < len(d.res.Trace) && d.statement(d.cur+1) == stmt {
+				d.step()
+			}
+		}
+		d.show()
+
+	case "continue", "c":
+		for d.step() && !d.atBreakpoint(d.cur) && !d.failed(d.cur) {
+		}
+		d.show()
+
+	case "break", "b", "clear":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: %s <line>", cmd)
+		}
+		line, err := strconv.Atoi(args[0])
+		if err != nil || line < 1 {
+			return fmt.Errorf("bad line \"%s\"", args[0])
+		}
+		if cmd == "clear" {
+			delete(d.breakpoints, line)
+		} else {
+			d.Break(line)
+		}
+
+	case "breakpoints":
+		var lines []int
+		for line := range d.breakpoints {
+			lines = append(lines, line)
+		}
+		sort.Ints(lines)
+		for _, line := range lines {
+			fmt.Fprintf(d.out, "line %d: %s\n", line, d.sourceLine(d.sourceFile(), line))
+		}
+
+	case "print", "p":
+		d.show()
+
+	case "help", "h":
+		fmt.Fprintln(d.out, help)
+
+	default:
+		return fmt.Errorf("unknown command \"%s\"; type \"help\" for commands", cmd)
+	}
+	return nil
+}
+
+// step executes the next instruction, reporting whether there was
+// one.
+func (d *Debugger) step() bool {
+	if d.cur+1 >= len(d.res.Trace) {
+		d.cur = len(d.res.Trace)
+		return false
+	}
+	d.cur++
+	return true
+}
+
+// statement returns the statement from which the instruction at index
+// i of the trace was compiled. It is unknown for instructions of the
+// instantiated program, which was not compiled from a statement.
+func (d *Debugger) statement(i int) compiler.Location {
+	inst := d.res.Trace[i]
+	if inst.Depth == 0 {
+		return compiler.Location{}
+	}
+	step := d.contract.StepAt(inst.PC)
+	if step == nil {
+		return compiler.Location{}
+	}
+	return step.Statement
+}
+
+// atBreakpoint tells whether the instruction at index i of the trace
+// is the first of a statement on a line with a breakpoint.
+func (d *Debugger) atBreakpoint(i int) bool {
+	stmt := d.statement(i)
+	if stmt.Line == 0 || !d.breakpoints[stmt.Line] {
+		return false
+	}
+	return i == 0 || d.statement(i-1) != stmt
+}
+
+// failed tells whether the program failed at the instruction at index
+// i of the trace.
+func (d *Debugger) failed(i int) bool {
+	return d.res.FailedAt == &d.res.Trace[i]
+}
+
+// show prints the last instruction executed, the statement it belongs
+// to and the stack after it.
+func (d *Debugger) show() {
+	switch {
+	case d.cur < 0:
+		fmt.Fprintln(d.out, "Not started.")
+		return
+	case d.cur >= len(d.res.Trace):
+		if d.res.Pass {
+			fmt.Fprintln(d.out, "Finished: PASS")
+		} else {
+			fmt.Fprintln(d.out, "Finished: FAIL:", errors.Root(d.res.Err))
+		}
+		return
+	}
+
+	inst := d.res.Trace[d.cur]
+	where := "instantiated program"
+	if inst.Depth > 0 {
+		where = "contract body"
+	}
+	if stmt := d.statement(d.cur); stmt.Line > 0 {
+		fmt.Fprintf(d.out, "%s:%d: %s\n", d.contract.Name, stmt.Line, d.sourceLine(stmt.File, stmt.Line))
+	} else {
+		fmt.Fprintf(d.out, "%s: %s\n", d.contract.Name, where)
+	}
+	op := inst.Op
+	if len(inst.Data) > 0 {
+		op += " " + hex.EncodeToString(inst.Data)
+	}
+	fmt.Fprintf(d.out, "  pc %d %s (%s), gas left before it %d\n", inst.PC, op, where, inst.GasLeft)
+
+	if d.failed(d.cur) {
+		fmt.Fprintln(d.out, "  failed:", errors.Root(d.res.Err))
+	}
+	if inst.Stack == nil {
+		return
+	}
+
+	// The compiler knows the stack only between steps, so names are
+	// attached after the last instruction of a step.
+	var names []string
+	if inst.Depth > 0 {
+		step := d.contract.StepAt(inst.PC)
+		if step != nil && (d.cur+1 == len(d.res.Trace) || d.res.Trace[d.cur+1].Depth == 0 || d.contract.StepAt(d.res.Trace[d.cur+1].PC) != step) {
+			names = step.Names()
+		}
+	}
+	fmt.Fprintln(d.out, "  stack (top first):")
+	for i := len(inst.Stack) - 1; i >= 0; i-- {
+		// names describe the top of the stack
+		var name string
+		if j := len(names) - (len(inst.Stack) - i); j >= 0 {
+			name = names[j]
+		}
+		fmt.Fprintf(d.out, "    %-24s %s\n", name, formatItem(inst.Stack[i]))
+	}
+}
+
+func formatItem(item []byte) string {
+	switch {
+	case len(item) == 0:
+		return "(empty)"
+	case len(item) > 32:
+		return fmt.Sprintf("%x... (%d bytes)", item[:32], len(item))
+	}
+	return hex.EncodeToString(item)
+}
+
+// sourceFile returns the name of the file the contract was compiled
+// from, or "" if it is not known.
+func (d *Debugger) sourceFile() string {
+	for _, step := range d.contract.Steps {
+		if step.Statement.File != "" {
+			return step.Statement.File
+		}
+	}
+	return ""
+}
+
+// sourceLine returns the text of a line of a source file, or "" if it
+// cannot be read.
+func (d *Debugger) sourceLine(file string, line int) string {
+	lines, ok := d.sources[file]
+	if !ok && file != "" {
+		if src, err := ioutil.ReadFile(file); err == nil {
+			lines = strings.Split(string(src), "\n")
+		}
+		d.sources[file] = lines
+	}
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+	return strings.TrimSpace(lines[line-1])
+}
@@ -0,0 +1,83 @@
+package debug
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	chainjson "github.com/bytom/encoding/json"
+
+	"github.com/equity/compiler"
+	"github.com/equity/simulate"
+)
+
+const lockWithDeadline = `contract LockWithDeadline(publicKey: PublicKey, deadline: Integer, dest: Program) locks value of asset {
+  clause spend(sig: Signature) {
+    verify checkTxSig(publicKey, sig)
+    unlock value of asset
+  }
+  clause expire(n: Integer) {
+    verify above(deadline)
+    verify n > 2
+    lock value of asset with dest
+  }
+}
+`
+
+func TestDebugger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "LockWithDeadline.equity")
+	if err := ioutil.WriteFile(file, []byte(lockWithDeadline), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	contracts, err := compiler.Compile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contract := contracts[0]
+
+	pub := chainjson.HexBytes(make([]byte, 32))
+	dest := chainjson.HexBytes{0x00, 0x14, 0x01}
+	deadline, n := int64(100), int64(2)
+	args := []compiler.ContractArg{{S: &pub}, {I: &deadline}, {S: &dest}}
+	ctx := &simulate.Context{BlockHeight: 101, Amount: 1000}
+	res, err := simulate.Run(contract, args, "expire", []compiler.ContractArg{{I: &n}}, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	d := NewDebugger(contract, res, strings.NewReader("continue\n\n\nquit\n"), &out)
+	d.Break(8)
+	if err := d.Run(); err != nil {
+		t.Fatal(err)
+	}
+	got := out.String()
+	for _, want := range []string{
+		// the breakpoint
+		"(debug) LockWithDeadline:8: verify n > 2\n",
+		// the failure
+		"  pc 25 VERIFY (contract body)",
+		"  failed: VERIFY failed\n",
+		"(debug) Finished: FAIL: VERIFY failed\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output does not contain %q:\n%s", want, got)
+		}
+	}
+	if !strings.Contains(got, "\n    n ") {
+		t.Errorf("stack values are not named:\n%s", got)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/equity/compiler"
+	"github.com/equity/equity/disasm"
+)
+
+var disasmContracts []string
+
+func init() {
+	disasmCmd.Flags().StringSliceVar(&disasmContracts, "contract", nil, "Comma-separated equity source files whose contracts the program may instantiate.")
+	equityCmd.AddCommand(disasmCmd)
+}
+
+var disasmCmd = &cobra.Command{
+	Use:   "disasm <program>",
+	Short: "Disassemble a control program",
+	Long: `Disassemble a control program given in hex. A program built by
+instantiating a contract is split into its arguments, the wrapper that
+runs the body, and the body. If the body is that of a contract compiled
+from a file given with --contract, the arguments are named by its
+parameters and the body is listed with its clause labels and the stack
+after each step.`,
+	Example: "equity disasm <program> --contract LockPosition",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := handleDisasm(args[0]); err != nil {
+			os.Exit(-1)
+		}
+	},
+}
+
+func handleDisasm(program string) error {
+	prog, err := hex.DecodeString(program)
+	if err != nil {
+		fmt.Println("Decode the program error:", err)
+		return err
+	}
+
+	var contracts []*compiler.Contract
+	for _, file := range disasmContracts {
+		found, err := compileFile(file)
+		if err != nil {
+			return err
+		}
+		contracts = append(contracts, found...)
+	}
+
+	if err := disasm.Write(os.Stdout, prog, contracts); err != nil {
+		fmt.Println("Disassemble the program error:", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+// Package disasm disassembles control programs, annotating those that
+// instantiate a known contract with the names of its parameters and
+// clauses and the stack the compiler expects at each instruction.
+package disasm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/bytom/errors"
+	"github.com/bytom/protocol/vm"
+
+	"github.com/equity/compiler"
+)
+
+// Write writes the disassembly of prog to w. If prog instantiates one
+// of contracts, the listing is annotated with its semantics.
+func Write(w io.Writer, prog []byte, contracts []*compiler.Contract) error {
+	inst, err := compiler.SplitInstance(prog)
+	if errors.Root(err) == compiler.ErrNotInstance {
+		text, err := vm.Disassemble(prog)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, "Not an instantiated contract:")
+		fmt.Fprintln(w, text)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var contract *compiler.Contract
+	for _, c := range contracts {
+		if bytes.Equal(c.Body, inst.Body) {
+			contract = c
+			break
+		}
+	}
+
+	layout := "DEPTH <body> 0 CHECKPREDICATE"
+	if inst.Recursive {
+		layout = "<body> DEPTH OVER 0 CHECKPREDICATE"
+	}
+	if contract != nil {
+		fmt.Fprintf(w, "Instantiated contract %s (%s)\n", contract.Name, layout)
+	} else {
+		fmt.Fprintf(w, "Instantiated contract with unknown body (%s)\n", layout)
+	}
+
+	fmt.Fprintln(w, "\nArguments:")
+	if len(inst.Args) == 0 {
+		fmt.Fprintln(w, "    none")
+	}
+	var pc uint32
+	for i, x := range inst.Insts[:inst.Start] {
+		// arguments are pushed last first
+		arg := inst.Start - 1 - i
+		name := fmt.Sprintf("arg %d", arg+1)
+		if contract != nil && arg < len(contract.Params) {
+			param := contract.Params[arg]
+			name = fmt.Sprintf("%s: %s", param.Name, param.Type)
+		}
+		fmt.Fprintf(w, "    %04x  %-40s # %s\n", pc, formatInst(x), name)
+		pc += x.Len
+	}
+
+	body := inst.Start + 1
+	if inst.Recursive {
+		body = inst.Start
+	}
+	fmt.Fprintln(w, "\nWrapper:")
+	for i, x := range inst.Insts[inst.Start:] {
+		text := formatInst(x)
+		if inst.Start+i == body {
+			text = fmt.Sprintf("%s <body, %d bytes>", x.Op, len(inst.Body))
+		}
+		fmt.Fprintf(w, "    %04x  %s\n", pc, text)
+		pc += x.Len
+	}
+
+	fmt.Fprintln(w, "\nBody:")
+	if contract == nil {
+		text, err := vm.Disassemble(inst.Body)
+		if err != nil {
+			return errors.Wrap(err, "disassembling body")
+		}
+		fmt.Fprintln(w, "   ", text)
+		return nil
+	}
+	return writeBody(w, contract)
+}
+
+// writeBody lists the instructions of a contract body, with the labels
+// the compiler gave jump targets and the stack after each step.
+func writeBody(w io.Writer, contract *compiler.Contract) error {
+	insts, err := vm.ParseProgram(contract.Body)
+	if err != nil {
+		return errors.Wrap(err, "parsing body")
+	}
+	labels := bodyLabels(contract, insts)
+
+	var pc uint32
+	for i, x := range insts {
+		if label, ok := labels[pc]; ok {
+			fmt.Fprintf(w, "  %s:\n", label)
+		}
+		text := formatInst(x)
+		if x.Op == vm.OP_JUMP || x.Op == vm.OP_JUMPIF {
+			target := binary.LittleEndian.Uint32(x.Data)
+			if label, ok := labels[target]; ok {
+				text = fmt.Sprintf("%s:$%s", x.Op, label)
+			}
+		}
+
+		// the compiler knows the stack between steps
+		var comment string
+		if step := contract.StepAt(pc); step != nil {
+			next := pc + x.Len
+			if i == len(insts)-1 || contract.StepAt(next) != step {
+				comment = "# " + step.Stack
+			}
+		}
+		fmt.Fprintln(w, strings.TrimRight(fmt.Sprintf("    %04x  %-40s %s", pc, text, comment), " "))
+		pc += x.Len
+	}
+	if label, ok := labels[pc]; ok {
+		fmt.Fprintf(w, "  %s:\n", label)
+	}
+	return nil
+}
+
+// bodyLabels maps the offsets of the jump targets in a contract body
+// to the labels the compiler gave them: clause names for the clauses
+// the body jumps to. The labels are recovered from Contract.Opcodes,
+// in which a label precedes the instruction it marks.
+func bodyLabels(contract *compiler.Contract, insts []vm.Instruction) map[uint32]string {
+	labels := make(map[uint32]string)
+	var pc uint32
+	for _, op := range strings.Fields(contract.Opcodes) {
+		if strings.HasPrefix(op, "$") {
+			if _, ok := labels[pc]; !ok {
+				labels[pc] = op[1:]
+			}
+			continue
+		}
+		if len(insts) == 0 {
+			// Opcodes does not match Body
+			return nil
+		}
+		pc += insts[0].Len
+		insts = insts[1:]
+	}
+	return labels
+}
+
+func formatInst(x vm.Instruction) string {
+	switch {
+	case x.Op >= vm.OP_1 && x.Op <= vm.OP_16, len(x.Data) == 0:
+		return x.Op.String()
+	}
+	return fmt.Sprintf("%s 0x%x", x.Op, x.Data)
+}
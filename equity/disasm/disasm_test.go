@@ -0,0 +1,78 @@
+package disasm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	chainjson "github.com/bytom/encoding/json"
+
+	"github.com/equity/compiler"
+)
+
+const lockWithDeadline = `
+contract LockWithDeadline(publicKey: PublicKey, deadline: Integer, dest: Program) locks value of asset {
+  clause spend(sig: Signature) {
+    verify checkTxSig(publicKey, sig)
+    unlock value of asset
+  }
+  clause expire() {
+    verify above(deadline)
+    lock value of asset with dest
+  }
+}
+`
+
+func TestWrite(t *testing.T) {
+	contracts, err := compiler.Compile(strings.NewReader(lockWithDeadline))
+	if err != nil {
+		t.Fatal(err)
+	}
+	contract := contracts[0]
+	key := chainjson.HexBytes(bytes.Repeat([]byte{0xaa}, 32))
+	dest := chainjson.HexBytes{0x00, 0x14, 0xba}
+	deadline := int64(1000)
+	args := []compiler.ContractArg{{S: &key}, {I: &deadline}, {S: &dest}}
+	prog, err := compiler.Instantiate(contract.Body, contract.Params, contract.Recursive, args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := Write(&out, prog, contracts); err != nil {
+		t.Fatal(err)
+	}
+	got := out.String()
+	for _, want := range []string{
+		"Instantiated contract LockWithDeadline (DEPTH <body> 0 CHECKPREDICATE)\n",
+		"    0000  DATA_3 0x0014ba                          # dest: Program\n",
+		"    0004  DATA_2 0xe803                            # deadline: Integer\n",
+		"    0007  DATA_32 0xaaaa",
+		"# publicKey: PublicKey\n",
+		"    0029  DATA_29 <body, 29 bytes>\n",
+		"JUMPIF:$expire",
+		"\n  expire:\n",
+		"# [... <clause selector> dest deadline publicKey 3]\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output does not contain %q:\n%s", want, got)
+		}
+	}
+
+	out.Reset()
+	if err := Write(&out, prog, nil); err != nil {
+		t.Fatal(err)
+	}
+	got = out.String()
+	if !strings.Contains(got, "unknown body") || !strings.Contains(got, "# arg 3\n") || !strings.Contains(got, "JUMPIF:$alpha") {
+		t.Errorf("got unannotated output:\n%s", got)
+	}
+
+	out.Reset()
+	if err := Write(&out, []byte{0x51, 0x52, 0x93}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); got != "Not an instantiated contract:\n0x01 0x02 ADD\n" {
+		t.Errorf("got %q", got)
+	}
+}
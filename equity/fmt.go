@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/equity/compiler"
+)
+
+func init() {
+	fmtCmd.Flags().BoolVar(&fmtCheck, "check", false, "List files whose formatting differs and exit with status 1 if there are any.")
+	fmtCmd.Flags().BoolVar(&fmtWrite, "write", false, "Write the formatted source back to the files.")
+	equityCmd.AddCommand(fmtCmd)
+}
+
+var (
+	fmtCheck = false
+	fmtWrite = false
+)
+
+var fmtCmd = &cobra.Command{
+	Use:   "fmt <input_file>...",
+	Short: "Format equity source files",
+	Long:  "Format equity source files. The formatted source is printed unless --check or --write is given.",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		unformatted, err := handleFormat(args)
+		if err != nil {
+			os.Exit(-1)
+		}
+		if unformatted {
+			os.Exit(1)
+		}
+	},
+}
+
+// handleFormat formats each file, reporting whether any of them
+// differ from their formatting.
+func handleFormat(files []string) (bool, error) {
+	unformatted := false
+	for _, file := range files {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			fmt.Printf("An error [%v] occurred on reading the file, please check whether the file exists or can be accessed.\n", err)
+			return false, err
+		}
+
+		formatted, err := compiler.Format(src)
+		if err != nil {
+			fmt.Printf("Format contract file %s failed:\n", file)
+			fmt.Println(err)
+			return false, err
+		}
+
+		changed := !bytes.Equal(src, formatted)
+		switch {
+		case fmtCheck:
+			if changed {
+				fmt.Println(file)
+				unformatted = true
+			}
+		case fmtWrite:
+			if changed {
+				if err := ioutil.WriteFile(file, formatted, 0644); err != nil {
+					fmt.Printf("Write the formatted file %s error: %v\n", file, err)
+					return false, err
+				}
+			}
+		default:
+			os.Stdout.Write(formatted)
+		}
+	}
+	return unformatted, nil
+}
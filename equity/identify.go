@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+
+	"github.com/equity/compiler"
+	"github.com/equity/registry"
+)
+
+var identifyRegistry = "."
+
+func init() {
+	identifyCmd.Flags().StringVar(&identifyRegistry, "registry", ".", "Directory of equity sources (*.equity) and compiled contracts (*.json) to identify programs against.")
+	equityCmd.AddCommand(identifyCmd)
+}
+
+var identifyCmd = &cobra.Command{
+	Use:   "identify <program>...",
+	Short: "Identify the contracts that control programs instantiate",
+	Long: `Identify the contract that each control program, given in hex,
+instantiates, among the contracts in the registry directory, and print
+the arguments with which it was instantiated.`,
+	Example: "equity identify <program> --registry ./contracts",
+	Args:    cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		identified, err := handleIdentify(args)
+		if err != nil {
+			os.Exit(-1)
+		}
+		if !identified {
+			os.Exit(1)
+		}
+	},
+}
+
+// handleIdentify identifies programs, reporting whether all of them
+// were identified.
+func handleIdentify(programs []string) (bool, error) {
+	opts, err := compileOptions()
+	if err != nil {
+		fmt.Println(err)
+		return false, err
+	}
+	r, err := registry.LoadWithOptions(identifyRegistry, opts)
+	if err != nil {
+		fmt.Println("Load the registry error:", err)
+		return false, err
+	}
+
+	identified := true
+	for _, program := range programs {
+		prog, err := hex.DecodeString(program)
+		if err != nil {
+			fmt.Println("Decode the program error:", err)
+			return false, err
+		}
+
+		fmt.Printf("======= %.16s... =======\n", program)
+		m, err := r.Identify(prog)
+		if err != nil {
+			fmt.Println("Not identified:", err)
+			identified = false
+			continue
+		}
+		fmt.Println("Contract:", m.Contract.Name)
+		fmt.Println("Recursive:", m.Recursive)
+		fmt.Println("Arguments:")
+		for _, param := range m.Contract.Params {
+			fmt.Printf("    %s: %s\n", param.Name, formatArg(m.Args[param.Name]))
+		}
+	}
+	return identified, nil
+}
+
+func formatArg(arg compiler.ContractArg) string {
+	switch {
+	case arg.B != nil:
+		return strconv.FormatBool(*arg.B)
+	case arg.I != nil:
+		return strconv.FormatInt(*arg.I, 10)
+	case arg.S != nil:
+		return hex.EncodeToString(*arg.S)
+	}
+	return ""
+}
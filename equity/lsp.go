@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/equity/equity/lsp"
+)
+
+func init() {
+	equityCmd.AddCommand(lspCmd)
+}
+
+var lspCmd = &cobra.Command{
+	Use:   "lsp",
+	Short: "Run the equity language server over stdin and stdout",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := lsp.NewServer(os.Stdin, os.Stdout).Serve(); err != nil {
+			fmt.Fprintln(os.Stderr, "Language server error:", err)
+			os.Exit(-1)
+		}
+	},
+}
@@ -0,0 +1,401 @@
+// Package lsp implements a Language Server Protocol server for Equity,
+// speaking JSON-RPC over a pair of streams such as stdin and stdout.
+//
+// The server publishes the compiler's diagnostics as documents change,
+// and answers hover, go-to-definition, and completion requests from
+// the names resolved by compiler.Analyze.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/textproto"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bytom/errors"
+
+	"github.com/equity/compiler"
+)
+
+// Server is an Equity language server.
+type Server struct {
+	in  *bufio.Reader
+	out io.Writer
+
+	// open documents and their most recent analyses, by URI
+	docs     map[string]*document
+	shutdown bool
+}
+
+type document struct {
+	path     string
+	text     string
+	analysis *compiler.Analysis
+}
+
+// NewServer returns a server reading requests from r and writing
+// responses to w.
+func NewServer(r io.Reader, w io.Writer) *Server {
+	return &Server{
+		in:   bufio.NewReader(r),
+		out:  w,
+		docs: make(map[string]*document),
+	}
+}
+
+// Serve handles requests until the client sends "exit" or closes the
+// input stream.
+func (s *Server) Serve() error {
+	for {
+		body, err := s.readMessage()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var req request
+		if err := json.Unmarshal(body, &req); err != nil {
+			if err := s.replyError(nil, codeParseError, err.Error()); err != nil {
+				return err
+			}
+			continue
+		}
+		if req.Method == "exit" {
+			return nil
+		}
+		if err := s.handle(&req); err != nil {
+			return err
+		}
+	}
+}
+
+// readMessage reads the body of the next message, framed by a
+// Content-Length header.
+func (s *Server) readMessage() ([]byte, error) {
+	header, err := textproto.NewReader(s.in).ReadMIMEHeader()
+	if err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, errors.Wrap(err, "reading message header")
+	}
+	n, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing Content-Length")
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(s.in, body); err != nil {
+		return nil, errors.Wrap(err, "reading message body")
+	}
+	return body, nil
+}
+
+func (s *Server) write(msg interface{}) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return errors.Wrap(err, "encoding message")
+	}
+	_, err = fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
+
+func (s *Server) reply(id *json.RawMessage, result interface{}) error {
+	return s.write(&response{JSONRPC: "2.0", ID: id, Result: result})
+}
+
+func (s *Server) replyError(id *json.RawMessage, code int, msg string) error {
+	return s.write(&errorResponse{JSONRPC: "2.0", ID: id, Error: &responseError{Code: code, Message: msg}})
+}
+
+func (s *Server) notify(method string, params interface{}) error {
+	return s.write(&notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+func (s *Server) handle(req *request) error {
+	var (
+		result interface{}
+		err    error
+	)
+	switch req.Method {
+	case "initialize":
+		result = &initializeResult{
+			Capabilities: serverCapabilities{
+				TextDocumentSync:   syncFull,
+				HoverProvider:      true,
+				DefinitionProvider: true,
+				CompletionProvider: completionProvider{TriggerCharacters: []string{}},
+			},
+			ServerInfo: serverInfo{Name: "equity", Version: compiler.VersionWithCommit(compiler.GitCommit)},
+		}
+
+	case "shutdown":
+		s.shutdown = true
+
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err = json.Unmarshal(req.Params, &params); err == nil {
+			return s.update(params.TextDocument.URI, params.TextDocument.Text)
+		}
+
+	case "textDocument/didChange":
+		var params didChangeParams
+		if err = json.Unmarshal(req.Params, &params); err == nil && len(params.ContentChanges) > 0 {
+			// with full sync, the last change holds the whole document
+			text := params.ContentChanges[len(params.ContentChanges)-1].Text
+			return s.update(params.TextDocument.URI, text)
+		}
+
+	case "textDocument/didClose":
+		var params didCloseParams
+		if err = json.Unmarshal(req.Params, &params); err == nil {
+			delete(s.docs, params.TextDocument.URI)
+			return s.notify("textDocument/publishDiagnostics", &publishDiagnosticsParams{URI: params.TextDocument.URI, Diagnostics: []diagnostic{}})
+		}
+
+	case "textDocument/hover":
+		var params textDocumentPositionParams
+		if err = json.Unmarshal(req.Params, &params); err == nil {
+			result = s.hover(&params)
+		}
+
+	case "textDocument/definition":
+		var params textDocumentPositionParams
+		if err = json.Unmarshal(req.Params, &params); err == nil {
+			result = s.definition(&params)
+		}
+
+	case "textDocument/completion":
+		var params textDocumentPositionParams
+		if err = json.Unmarshal(req.Params, &params); err == nil {
+			result = s.completion(&params)
+		}
+
+	default:
+		if req.ID == nil || strings.HasPrefix(req.Method, "$/") {
+			// notifications that are not understood are ignored
+			return nil
+		}
+		return s.replyError(req.ID, codeMethodNotFound, fmt.Sprintf("method not found: %s", req.Method))
+	}
+
+	if req.ID == nil {
+		return nil
+	}
+	if err != nil {
+		return s.replyError(req.ID, codeInvalidParams, err.Error())
+	}
+	if s.shutdown && req.Method != "shutdown" {
+		return s.replyError(req.ID, codeInvalidRequest, "server is shutting down")
+	}
+	return s.reply(req.ID, result)
+}
+
+// update analyzes the new text of a document and publishes its
+// diagnostics.
+func (s *Server) update(uri, text string) error {
+	path := uriToPath(uri)
+	doc := &document{path: path, text: text, analysis: analyze(path, text)}
+	s.docs[uri] = doc
+
+	diags := []diagnostic{}
+	for _, d := range doc.analysis.Diagnostics {
+		diag := diagnostic{
+			Range:    toRange(text, d.Location),
+			Severity: severityError,
+			Code:     d.Code,
+			Source:   "equity",
+			Message:  d.Message,
+		}
+		if d.Severity == compiler.SeverityWarning {
+			diag.Severity = severityWarning
+		}
+		if d.File != path {
+			// problems in imported files are shown at the top of the
+			// document that imports them
+			diag.Range = lspRange{}
+			diag.Message = d.Error()
+		}
+		diags = append(diags, diag)
+	}
+	return s.notify("textDocument/publishDiagnostics", &publishDiagnosticsParams{URI: uri, Diagnostics: diags})
+}
+
+// analyze runs the compiler over a document. Imports are resolved
+// relative to the document's directory, which is where its author
+// expects to run the compiler.
+func analyze(path, text string) *compiler.Analysis {
+	opts := compiler.Options{Resolve: compiler.DirResolver(filepath.Dir(path))}
+	return compiler.AnalyzeWithOptions([]byte(text), path, opts)
+}
+
+func (s *Server) lookup(params *textDocumentPositionParams) (*document, *compiler.Symbol, *compiler.Location) {
+	doc := s.docs[params.TextDocument.URI]
+	if doc == nil {
+		return nil, nil, nil
+	}
+	line := params.Position.Line + 1
+	col := byteCol(lineOf(doc.text, line), params.Position.Character)
+	sym, loc := doc.analysis.Lookup(doc.path, line, col)
+	return doc, sym, loc
+}
+
+func (s *Server) hover(params *textDocumentPositionParams) *hover {
+	doc, sym, loc := s.lookup(params)
+	if sym == nil {
+		return nil
+	}
+	var desc string
+	switch {
+	case sym.Signature != "":
+		desc = sym.Signature
+	case sym.Type != "":
+		desc = fmt.Sprintf("%s: %s", sym.Name, sym.Type)
+	default:
+		desc = sym.Name
+	}
+	r := toRange(doc.text, *loc)
+	return &hover{
+		Contents: markupContent{Kind: "markdown", Value: fmt.Sprintf("```equity\n%s\n```\n%s", desc, sym.Role)},
+		Range:    &r,
+	}
+}
+
+func (s *Server) definition(params *textDocumentPositionParams) *location {
+	_, sym, _ := s.lookup(params)
+	if sym == nil || sym.Line == 0 {
+		// keywords and built-ins have no definition in source
+		return nil
+	}
+	return &location{URI: pathToURI(sym.File), Range: toRange(s.textOf(sym.File), sym.Location)}
+}
+
+// textOf returns the text of the named file: that of its open document
+// if there is one, or else what is on disk.
+func (s *Server) textOf(path string) string {
+	for _, doc := range s.docs {
+		if doc.path == path {
+			return doc.text
+		}
+	}
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return string(buf)
+}
+
+func (s *Server) completion(params *textDocumentPositionParams) []completionItem {
+	items := []completionItem{}
+	doc := s.docs[params.TextDocument.URI]
+	if doc == nil {
+		return items
+	}
+	seen := make(map[string]bool)
+	for _, sym := range doc.analysis.Symbols {
+		if seen[sym.Name] {
+			continue
+		}
+		seen[sym.Name] = true
+		item := completionItem{Label: sym.Name, Detail: sym.Type}
+		switch sym.Role {
+		case "keyword":
+			item.Kind = kindKeyword
+		case "built-in function":
+			item.Kind = kindFunction
+			item.Detail = sym.Signature
+		case "contract":
+			item.Kind = kindClass
+			item.Detail = sym.Signature
+		case "clause":
+			item.Kind = kindMethod
+		default:
+			item.Kind = kindVariable
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// toRange converts l, a range of text, to an LSP range.
+func toRange(text string, l compiler.Location) lspRange {
+	if l.Line == 0 {
+		return lspRange{}
+	}
+	return lspRange{
+		Start: position{Line: l.Line - 1, Character: utf16Col(lineOf(text, l.Line), l.Col)},
+		End:   position{Line: l.EndLine - 1, Character: utf16Col(lineOf(text, l.EndLine), l.EndCol)},
+	}
+}
+
+// lineOf returns line n of text, counting from 1, without its newline.
+func lineOf(text string, n int) string {
+	for ; n > 1; n-- {
+		i := strings.IndexByte(text, '\n')
+		if i < 0 {
+			return ""
+		}
+		text = text[i+1:]
+	}
+	if i := strings.IndexByte(text, '\n'); i >= 0 {
+		text = text[:i]
+	}
+	return text
+}
+
+// utf16Col converts col, a byte offset into line as the compiler
+// counts columns, to the UTF-16 code units before it, as LSP counts
+// characters. Offsets past the end of line count one for each byte.
+func utf16Col(line string, col int) int {
+	if col > len(line) {
+		return utf16Col(line, len(line)) + col - len(line)
+	}
+	var n int
+	for _, r := range line[:col] {
+		n += utf16Len(r)
+	}
+	return n
+}
+
+// byteCol is the inverse of utf16Col. A character in the middle of a
+// surrogate pair is taken to be the rune after it.
+func byteCol(line string, char int) int {
+	for i, r := range line {
+		if char <= 0 {
+			return i
+		}
+		char -= utf16Len(r)
+	}
+	return len(line) + char
+}
+
+// utf16Len returns the number of UTF-16 code units that encode r.
+func utf16Len(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+func uriToPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+func pathToURI(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	u := url.URL{Scheme: "file", Path: filepath.ToSlash(path)}
+	return u.String()
+}
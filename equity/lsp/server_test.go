@@ -0,0 +1,280 @@
+package lsp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/equity/compiler"
+)
+
+// The string literal holds an é, one UTF-16 code unit in two bytes,
+// and an emoji, two code units in four bytes, so the columns after it
+// differ from the compiler's by three.
+const lockSrc = `contract Lock(publicKey: PublicKey) locks valueAmount of valueAsset {
+  clause spend(sig: Signature, word: String) {
+    verify sha3("é😀") == sha3(word) && checkTxSig(publicKey, sig)
+    unlock valueAmount of valueAsset
+  }
+}
+`
+
+const lockURI = "file:///contracts/lock.equity"
+
+// message is any message the server writes.
+type message struct {
+	ID     *json.RawMessage `json:"id"`
+	Method string           `json:"method"`
+	Params json.RawMessage  `json:"params"`
+	Result json.RawMessage  `json:"result"`
+	Error  *responseError   `json:"error"`
+}
+
+func frame(body string) string {
+	return fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+// call returns a framed request, or a notification if id is 0.
+func call(t *testing.T, id int, method string, params interface{}) string {
+	req := map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params}
+	if id != 0 {
+		req["id"] = id
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return frame(string(body))
+}
+
+func open(t *testing.T, uri, text string) string {
+	return call(t, 0, "textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri, "languageId": "equity", "version": 1, "text": text},
+	})
+}
+
+func at(t *testing.T, id int, method, uri string, line, char int) string {
+	return call(t, id, method, map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri},
+		"position":     position{Line: line, Character: char},
+	})
+}
+
+// converse runs a server over input and returns the messages it
+// writes.
+func converse(t *testing.T, input string) []message {
+	var out bytes.Buffer
+	if err := NewServer(strings.NewReader(input), &out).Serve(); err != nil {
+		t.Fatal(err)
+	}
+	var (
+		msgs []message
+		r    = NewServer(&out, nil)
+	)
+	for {
+		body, err := r.readMessage()
+		if err == io.EOF {
+			return msgs
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		var msg message
+		if err := json.Unmarshal(body, &msg); err != nil {
+			t.Fatalf("decoding %s: %s", body, err)
+		}
+		msgs = append(msgs, msg)
+	}
+}
+
+func decode(t *testing.T, raw json.RawMessage, v interface{}) {
+	if err := json.Unmarshal(raw, v); err != nil {
+		t.Fatalf("decoding %s: %s", raw, err)
+	}
+}
+
+func TestFraming(t *testing.T) {
+	input := "Content-Length: 2\r\nContent-Type: application/vscode-jsonrpc; charset=utf-8\r\n\r\n{]" +
+		open(t, lockURI, lockSrc) +
+		call(t, 1, "shutdown", nil) +
+		call(t, 0, "exit", nil) +
+		call(t, 2, "shutdown", nil)
+	msgs := converse(t, input)
+	if len(msgs) != 3 {
+		t.Fatalf("got %d messages, want 3: %+v", len(msgs), msgs)
+	}
+	if msgs[0].Error == nil || msgs[0].Error.Code != codeParseError {
+		t.Errorf("got %+v, want a parse error", msgs[0])
+	}
+	if msgs[1].Method != "textDocument/publishDiagnostics" {
+		t.Errorf("got %+v, want diagnostics of the document", msgs[1])
+	}
+	if msgs[2].ID == nil || string(*msgs[2].ID) != "1" || msgs[2].Error != nil {
+		t.Errorf("got %+v, want the reply to shutdown", msgs[2])
+	}
+
+	err := NewServer(strings.NewReader("Content-Type: text/plain\r\n\r\n{}"), ioutil.Discard).Serve()
+	if err == nil || !strings.Contains(err.Error(), "Content-Length") {
+		t.Errorf("got error %v without a Content-Length, want one parsing it", err)
+	}
+}
+
+func TestInitialize(t *testing.T) {
+	msgs := converse(t, call(t, 1, "initialize", map[string]interface{}{"capabilities": map[string]interface{}{}}))
+	if len(msgs) != 1 {
+		t.Fatalf("got %d messages, want 1", len(msgs))
+	}
+	var result initializeResult
+	decode(t, msgs[0].Result, &result)
+	caps := result.Capabilities
+	if caps.TextDocumentSync != syncFull || !caps.HoverProvider || !caps.DefinitionProvider {
+		t.Errorf("got capabilities %+v", caps)
+	}
+	if result.ServerInfo.Name != "equity" {
+		t.Errorf("got server name %q, want equity", result.ServerInfo.Name)
+	}
+}
+
+func TestPublishDiagnostics(t *testing.T) {
+	src := strings.Replace(lockSrc, "sha3(word)", "sha3(wrd)", 1)
+	msgs := converse(t, open(t, lockURI, src))
+	if len(msgs) != 1 || msgs[0].Method != "textDocument/publishDiagnostics" {
+		t.Fatalf("got %+v, want diagnostics", msgs)
+	}
+	var params publishDiagnosticsParams
+	decode(t, msgs[0].Params, &params)
+	if params.URI != lockURI {
+		t.Errorf("got URI %s, want %s", params.URI, lockURI)
+	}
+
+	// word, which is no longer used, is reported too
+	var got []diagnostic
+	for _, d := range params.Diagnostics {
+		if strings.Contains(d.Message, "wrd") {
+			got = append(got, d)
+		}
+	}
+	want := []diagnostic{{
+		Range:    lspRange{Start: position{Line: 2, Character: 31}, End: position{Line: 2, Character: 34}},
+		Severity: severityError,
+		Code:     compiler.CodeUndefined,
+		Source:   "equity",
+		Message:  "undefined reference: \"wrd\"",
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+
+	msgs = converse(t, open(t, lockURI, lockSrc))
+	decode(t, msgs[0].Params, &params)
+	if len(params.Diagnostics) != 0 {
+		t.Errorf("got %+v, want no diagnostics", params.Diagnostics)
+	}
+}
+
+func TestHoverAndDefinition(t *testing.T) {
+	msgs := converse(t, open(t, lockURI, lockSrc)+
+		at(t, 1, "textDocument/hover", lockURI, 2, 32)+
+		at(t, 2, "textDocument/definition", lockURI, 2, 32)+
+		at(t, 3, "textDocument/hover", lockURI, 2, 18))
+	if len(msgs) != 4 {
+		t.Fatalf("got %d messages, want 4", len(msgs))
+	}
+
+	var h hover
+	decode(t, msgs[1].Result, &h)
+	if !strings.Contains(h.Contents.Value, "word: String") {
+		t.Errorf("got hover %q, want the type of word", h.Contents.Value)
+	}
+	wantRange := lspRange{Start: position{Line: 2, Character: 31}, End: position{Line: 2, Character: 35}}
+	if h.Range == nil || *h.Range != wantRange {
+		t.Errorf("got hover range %+v, want %+v", h.Range, wantRange)
+	}
+
+	var loc location
+	decode(t, msgs[2].Result, &loc)
+	want := location{URI: lockURI, Range: lspRange{Start: position{Line: 1, Character: 31}, End: position{Line: 1, Character: 35}}}
+	if loc != want {
+		t.Errorf("got definition %+v, want %+v", loc, want)
+	}
+
+	// between the quotes there is nothing to hover over
+	if string(msgs[3].Result) != "null" {
+		t.Errorf("got hover %s inside the string, want null", msgs[3].Result)
+	}
+}
+
+func TestImportsResolvedBesideDocument(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lsp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	inner := `contract Inner(key: PublicKey) locks value of asset {
+  clause spend(sig: Signature) {
+    verify checkTxSig(key, sig)
+    unlock value of asset
+  }
+}
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "Inner"), []byte(inner), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outer := `import "./Inner"
+
+contract Outer(key: PublicKey) locks value of asset {
+  clause move() {
+    lock value of asset with Inner(key)
+  }
+}
+`
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	uri := pathToURI(filepath.Join(dir, "outer.equity"))
+	msgs := converse(t, open(t, uri, outer)+at(t, 1, "textDocument/definition", uri, 4, 30))
+	if got, _ := os.Getwd(); got != wd {
+		t.Errorf("working directory changed to %s", got)
+	}
+
+	var params publishDiagnosticsParams
+	decode(t, msgs[0].Params, &params)
+	if len(params.Diagnostics) != 0 {
+		t.Errorf("got %+v, want no diagnostics", params.Diagnostics)
+	}
+	var loc location
+	decode(t, msgs[1].Result, &loc)
+	want := location{URI: pathToURI(filepath.Join(dir, "Inner")), Range: lspRange{Start: position{Character: 9}, End: position{Character: 14}}}
+	if loc != want {
+		t.Errorf("got definition %+v, want %+v", loc, want)
+	}
+}
+
+func TestColumns(t *testing.T) {
+	line := `a "é😀" b`
+	cases := []struct{ col, char int }{
+		{0, 0},
+		{3, 3},
+		{5, 4},
+		{9, 6},
+		{11, 8},
+		{13, 10},
+	}
+	for _, c := range cases {
+		if got := utf16Col(line, c.col); got != c.char {
+			t.Errorf("utf16Col(%d) = %d, want %d", c.col, got, c.char)
+		}
+		if got := byteCol(line, c.char); got != c.col {
+			t.Errorf("byteCol(%d) = %d, want %d", c.char, got, c.col)
+		}
+	}
+}
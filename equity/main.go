@@ -1,11 +1,11 @@
 package main
 
 import (
-	"bufio"
 	"encoding/hex"
 	"fmt"
 	"os"
 	"runtime"
+	"strings"
 
 	"github.com/spf13/cobra"
 
@@ -18,23 +18,47 @@ const (
 	strShift    string = "shift"
 	strInstance string = "instance"
 	strAst      string = "ast"
+	strEstGas   string = "estimate-gas"
 	strVersion  string = "version"
+	strOptimize string = "optimize"
+	strPasses   string = "passes"
+	strImport   string = "import-dir"
+	strVM       string = "vm-version"
+	strWerror   string = "werror"
+	strNoSteps  string = "no-steps"
+	strNoSrcMap string = "no-source-map"
 )
 
 var (
-	bin      = false
-	shift    = false
-	instance = false
-	ast      = false
-	version  = false
+	bin         = false
+	shift       = false
+	instance    = false
+	ast         = false
+	estimateGas = false
+	version     = false
+	optimize    = "default"
+	passes      []string
+	imports     []string
+	vmVersion   uint64
+	werror      = false
+	noSteps     = false
+	noSrcMap    = false
 )
 
 func init() {
-	equityCmd.PersistentFlags().BoolVar(&bin, strBin, false, "Binary of the contracts in hex.")
-	equityCmd.PersistentFlags().BoolVar(&shift, strShift, false, "Function shift of the contracts.")
-	equityCmd.PersistentFlags().BoolVar(&instance, strInstance, false, "Object of the Instantiated contracts.")
-	equityCmd.PersistentFlags().BoolVar(&ast, strAst, false, "AST of the contracts.")
+	equityCmd.Flags().BoolVar(&bin, strBin, false, "Binary of the contracts in hex.")
+	equityCmd.Flags().BoolVar(&shift, strShift, false, "Function shift of the contracts.")
+	equityCmd.Flags().BoolVar(&instance, strInstance, false, "Object of the Instantiated contracts.")
+	equityCmd.Flags().BoolVar(&ast, strAst, false, "AST of the contracts.")
+	equityCmd.Flags().BoolVar(&estimateGas, strEstGas, false, "Estimated gas of spending the contracts through each clause.")
 	equityCmd.PersistentFlags().BoolVar(&version, strVersion, false, "Version of equity compiler.")
+	equityCmd.PersistentFlags().StringVarP(&optimize, strOptimize, "O", "default", "Optimization level of the contracts: none, default, or size to also schedule the stack of each clause.")
+	equityCmd.PersistentFlags().StringSliceVar(&passes, strPasses, nil, "Comma-separated optimization passes to run in place of those of the level: fold, share, schedule, peephole, dispatch, merge.")
+	equityCmd.PersistentFlags().StringSliceVar(&imports, strImport, nil, "Comma-separated directories in which to look for imported files before the working directory.")
+	equityCmd.PersistentFlags().Uint64Var(&vmVersion, strVM, 1, "Version of the virtual machine the contracts run on.")
+	equityCmd.PersistentFlags().BoolVar(&werror, strWerror, false, "Fail on warnings as on errors.")
+	equityCmd.PersistentFlags().BoolVar(&noSteps, strNoSteps, false, "Leave out the compiler steps that the debugger and disassembler show.")
+	equityCmd.PersistentFlags().BoolVar(&noSrcMap, strNoSrcMap, false, "Leave out the source map of the contracts.")
 }
 
 func main() {
@@ -68,6 +92,35 @@ var equityCmd = &cobra.Command{
 	},
 }
 
+// compileOptions returns the options of the compiler that the flags
+// select.
+func compileOptions() (compiler.Options, error) {
+	opts := compiler.Options{
+		VMVersion:        vmVersion,
+		WarningsAsErrors: werror,
+		OmitSteps:        noSteps,
+		OmitSourceMap:    noSrcMap,
+	}
+	switch optimize {
+	case "none":
+		opts.Optimize = compiler.OptNone
+	case "default":
+	case "size":
+		opts.Optimize = compiler.OptSize
+	default:
+		return opts, fmt.Errorf("unknown optimization level \"%s\", want none, default or size", optimize)
+	}
+	if passes != nil {
+		// An empty list runs no passes, where nil would run those
+		// of the level.
+		opts.Passes = append([]string{}, passes...)
+	}
+	if len(imports) > 0 {
+		opts.Resolve = compiler.DirResolver(imports...)
+	}
+	return opts, nil
+}
+
 func handleCompiled(args []string) error {
 	contractFile, err := os.Open(args[0])
 	if err != nil {
@@ -76,13 +129,25 @@ func handleCompiled(args []string) error {
 	}
 	defer contractFile.Close()
 
-	reader := bufio.NewReader(contractFile)
-	contracts, err := compiler.Compile(reader)
+	opts, err := compileOptions()
 	if err != nil {
-		fmt.Println("Compile contract failed:", err)
+		fmt.Println(err)
 		return err
 	}
 
+	contracts, err := compiler.CompileWithOptions(contractFile, opts)
+	if err != nil {
+		fmt.Println("Compile contract failed:")
+		fmt.Println(err)
+		return err
+	}
+
+	for _, contract := range contracts {
+		for _, w := range contract.Warnings {
+			fmt.Println("Warning:", w)
+		}
+	}
+
 	// Print the result for all contracts
 	for i, contract := range contracts {
 		fmt.Printf("======= %v =======\n", contract.Name)
@@ -103,7 +168,26 @@ func handleCompiled(args []string) error {
 				fmt.Printf("    %s:  %v\n", clause, shift)
 			}
 			fmt.Printf("\nNOTE: \n    If the contract contains only one clause, Users don't need clause selector when unlock contract." +
-				"\n    Furthermore, there is no signification for ending clause shift except for display.\n\n")
+				"\n    Furthermore, there is no signification for ending clause shift except for display.\n")
+			if contract.Dispatch == compiler.DispatchSearch {
+				fmt.Printf("    The contract selects its clause by a binary search, so the clause shift is the index of the clause, not its offset.\n")
+			}
+			fmt.Println()
+		}
+
+		if estimateGas {
+			fmt.Println("Gas:")
+			for _, clause := range contract.Clauses {
+				if clause.Gas == nil {
+					fmt.Printf("    %s:  unknown\n", clause.Name)
+					continue
+				}
+				fmt.Printf("    %s:  %s\n", clause.Name, formatGas(clause.Gas.Gas))
+				for _, path := range clause.Gas.Paths {
+					fmt.Printf("        if %s:  %s\n", strings.Join(path.Conditions, " && "), formatGas(path.Gas))
+				}
+			}
+			fmt.Println()
 		}
 
 		if instance {
@@ -149,3 +233,16 @@ func handleCompiled(args []string) error {
 
 	return nil
 }
+
+// formatGas formats a range of gas, noting whether it grows with the
+// length of arguments.
+func formatGas(g compiler.Gas) string {
+	s := fmt.Sprint(g.Best)
+	if g.Worst != g.Best {
+		s = fmt.Sprintf("%d to %d", g.Best, g.Worst)
+	}
+	if g.Unbounded {
+		s += " (more for longer arguments)"
+	}
+	return s
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/bytom/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/equity/compiler"
+	equ "github.com/equity/equity/util"
+	"github.com/equity/simulate"
+)
+
+var (
+	runContract string
+	runArgs     []string
+	runHeight   uint64
+	runAmount   uint64
+	runAsset    string
+	runSigHash  string
+	runGas      int64
+	runTx       string
+	runInput    int
+)
+
+func init() {
+	addRunFlags(runCmd)
+	equityCmd.AddCommand(runCmd)
+}
+
+// addRunFlags adds the flags that describe a run to cmd.
+func addRunFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&runContract, "contract", "", "Name of the contract to run (default the last in the file).")
+	cmd.Flags().StringSliceVar(&runArgs, "args", nil, "Comma-separated arguments with which to instantiate the contract.")
+	cmd.Flags().Uint64Var(&runHeight, "height", 0, "Block height of the spending transaction.")
+	cmd.Flags().Uint64Var(&runAmount, "amount", 0, "Amount of the value locked by the contract.")
+	cmd.Flags().StringVar(&runAsset, "asset", "", "Asset ID of the value locked by the contract in hex (default all zeros).")
+	cmd.Flags().StringVar(&runSigHash, "sighash", "", "Transaction signature hash in hex, signed by Signature arguments (default all zeros).")
+	cmd.Flags().StringVar(&runTx, "tx", "", "JSON file describing the spending transaction, which overrides --height, --amount, --asset and --sighash.")
+	cmd.Flags().IntVar(&runInput, "input", 0, "Index of the transaction input that spends the contract.")
+	cmd.Flags().Int64Var(&runGas, "gas", simulate.DefaultGasLimit, "Gas limit of the run.")
+}
+
+var runCmd = &cobra.Command{
+	Use:     "run <input_file> <clause> [clause_args...]",
+	Short:   "Run a contract clause on a local BVM",
+	Example: "equity run TradeOffer cancel <sellerSig> --args <assetRequested>,<amountRequested>,<seller>,<cancelKey>",
+	Args:    cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		pass, err := handleRun(args)
+		if err != nil {
+			os.Exit(-1)
+		}
+		if !pass {
+			os.Exit(1)
+		}
+	},
+}
+
+func handleRun(args []string) (bool, error) {
+	contract, res, err := runClause(args)
+	if err != nil {
+		return false, err
+	}
+	printResult(contract, args[1], res)
+	return res.Pass, nil
+}
+
+// runClause runs the clause named by args[1] of the contract compiled
+// from the file args[0], with the clause arguments args[2:] and the
+// flags that describe the run.
+func runClause(args []string) (*compiler.Contract, *simulate.Result, error) {
+	contract, err := loadContract(args[0], runContract)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var tx *simulate.Tx
+	if runTx != "" {
+		if tx, err = loadTx(runTx); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	params := make([]string, len(runArgs))
+	for i, arg := range runArgs {
+		if params[i], err = resolveKey(tx, contract.Params, i, arg); err != nil {
+			return nil, nil, err
+		}
+	}
+	contractArgs, err := equ.ConvertArguments(contract, params)
+	if err != nil {
+		fmt.Println("Convert arguments into contract parameters error:", err)
+		return nil, nil, err
+	}
+
+	clauseName := args[1]
+	var clause *compiler.Clause
+	for _, c := range contract.Clauses {
+		if c.Name == clauseName {
+			clause = c
+		}
+	}
+	if clause == nil {
+		err = fmt.Errorf("contract \"%s\" has no clause \"%s\"", contract.Name, clauseName)
+		fmt.Println(err)
+		return nil, nil, err
+	}
+	params = make([]string, len(args)-2)
+	for i, arg := range args[2:] {
+		if params[i], err = resolveKey(tx, clause.Params, i, arg); err != nil {
+			return nil, nil, err
+		}
+	}
+	clauseArgs, err := equ.ConvertParams(clause.Params, params)
+	if err != nil {
+		fmt.Println("Convert arguments into clause parameters error:", err)
+		return nil, nil, err
+	}
+
+	ctx := &simulate.Context{BlockHeight: runHeight, Amount: runAmount}
+	if tx != nil {
+		if ctx, err = tx.Context(runInput); err != nil {
+			fmt.Println(err)
+			return nil, nil, err
+		}
+	} else {
+		if ctx.AssetID, err = decodeHexFlag("asset", runAsset); err != nil {
+			return nil, nil, err
+		}
+		if ctx.TxSigHash, err = decodeHexFlag("sighash", runSigHash); err != nil {
+			return nil, nil, err
+		}
+	}
+	ctx.GasLimit = runGas
+
+	res, err := simulate.Run(contract, contractArgs, clauseName, clauseArgs, ctx)
+	if err != nil {
+		fmt.Println("Run contract clause error:", err)
+		return nil, nil, err
+	}
+	return contract, res, nil
+}
+
+func printResult(contract *compiler.Contract, clauseName string, res *simulate.Result) {
+	fmt.Printf("======= %s.%s =======\n", contract.Name, clauseName)
+	if res.Pass {
+		fmt.Println("Result: PASS")
+	} else {
+		fmt.Println("Result: FAIL")
+		fmt.Println("Error:", errors.Root(res.Err))
+		if inst := res.FailedAt; inst != nil {
+			where := "instantiated program"
+			if inst.Depth > 0 {
+				where = "contract body"
+			}
+			fmt.Printf("Failed at: pc %d %s (%s)\n", inst.PC, inst.Op, where)
+		}
+	}
+	fmt.Println("Gas used:", res.GasUsed)
+	if len(res.Outputs) > 0 {
+		fmt.Println("Outputs checked:")
+		for _, out := range res.Outputs {
+			fmt.Printf("    %d: amount %d asset %x program %x\n", out.Index, out.Amount, out.AssetID, out.ControlProgram)
+		}
+	}
+}
+
+// loadContract compiles the file and returns the named contract, or
+// the last contract in it if name is empty.
+func loadContract(file, name string) (*compiler.Contract, error) {
+	contracts, err := compileFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if name == "" {
+		return contracts[len(contracts)-1], nil
+	}
+	for _, contract := range contracts {
+		if contract.Name == name {
+			return contract, nil
+		}
+	}
+	err = fmt.Errorf("contract \"%s\" is not found in %s", name, file)
+	fmt.Println(err)
+	return nil, err
+}
+
+// compileFile compiles the contracts in a file.
+func compileFile(file string) ([]*compiler.Contract, error) {
+	opts, err := compileOptions()
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+
+	contractFile, err := os.Open(file)
+	if err != nil {
+		fmt.Printf("An error [%v] occurred on opening the file, please check whether the file exists or can be accessed.\n", err)
+		return nil, err
+	}
+	defer contractFile.Close()
+
+	contracts, err := compiler.CompileWithOptions(contractFile, opts)
+	if err != nil {
+		fmt.Println("Compile contract failed:")
+		fmt.Println(err)
+		return nil, err
+	}
+	return contracts, nil
+}
+
+func loadTx(file string) (*simulate.Tx, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		fmt.Printf("An error [%v] occurred on reading the transaction file.\n", err)
+		return nil, err
+	}
+	tx, err := simulate.ParseTx(data)
+	if err != nil {
+		fmt.Println("Parse transaction error:", err)
+		return nil, err
+	}
+	return tx, nil
+}
+
+// resolveKey replaces an argument of the form "@name" for a PublicKey
+// or Signature parameter with the public key, or the signature of the
+// spending input, of the key so named in the transaction.
+func resolveKey(tx *simulate.Tx, params []*compiler.Param, i int, arg string) (string, error) {
+	if tx == nil || i >= len(params) || !strings.HasPrefix(arg, "@") {
+		return arg, nil
+	}
+	var (
+		key []byte
+		err error
+	)
+	switch params[i].Type {
+	case "PublicKey":
+		key, err = tx.PublicKey(arg[1:])
+	case "Signature", "Sign":
+		key, err = tx.Sign(arg[1:], runInput)
+	default:
+		return arg, nil
+	}
+	if err != nil {
+		fmt.Printf("Resolve argument \"%s\" error: %v\n", arg, err)
+		return "", err
+	}
+	return hex.EncodeToString(key), nil
+}
+
+func decodeHexFlag(flag, value string) ([]byte, error) {
+	if value == "" {
+		return nil, nil
+	}
+	b, err := hex.DecodeString(value)
+	if err != nil {
+		fmt.Printf("Decode the --%s flag error: %v\n", flag, err)
+		return nil, err
+	}
+	return b, nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bytom/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/equity/compiler"
+	"github.com/equity/simulate"
+)
+
+func init() {
+	testCmd.Flags().BoolVarP(&testVerbose, "verbose", "v", false, "Print every test, not just the failures.")
+	equityCmd.AddCommand(testCmd)
+}
+
+var testVerbose = false
+
+var testCmd = &cobra.Command{
+	Use:   "test [path...]",
+	Short: "Run the tests declared in equity source files",
+	Long: `Run the tests declared with test blocks in equity source files, on a local BVM.
+
+A path names a file, a directory whose *.equity files are tested, or a
+directory followed by "/...", whose subdirectories are tested too. The
+default is the current directory.`,
+	Example: "equity test ./...",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			args = []string{"."}
+		}
+		pass, err := handleTest(args)
+		if err != nil {
+			os.Exit(-1)
+		}
+		if !pass {
+			os.Exit(1)
+		}
+	},
+}
+
+// handleTest runs the tests in the files named by paths, reporting
+// whether all of them passed.
+func handleTest(paths []string) (bool, error) {
+	var files []string
+	for _, path := range paths {
+		found, err := testFiles(path)
+		if err != nil {
+			fmt.Printf("An error [%v] occurred on finding the files in %s.\n", err, path)
+			return false, err
+		}
+		files = append(files, found...)
+	}
+
+	opts, err := compileOptions()
+	if err != nil {
+		fmt.Println(err)
+		return false, err
+	}
+
+	pass := true
+	for _, file := range files {
+		ok, err := testFile(file, opts)
+		if err != nil {
+			return false, err
+		}
+		pass = pass && ok
+	}
+	return pass, nil
+}
+
+// testFiles returns the files to test for path.
+func testFiles(path string) ([]string, error) {
+	recursive := false
+	if path == "..." || strings.HasSuffix(path, "/...") {
+		recursive = true
+		path = strings.TrimSuffix(strings.TrimSuffix(path, "..."), "/")
+		if path == "" {
+			path = "."
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	var files []string
+	err = filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if file != path && !recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasSuffix(file, ".equity") {
+			files = append(files, file)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// testFile compiles a file with opts and runs its tests, reporting
+// whether they all passed. Imports are found relative to the file's
+// directory, then in the --import-dir directories.
+func testFile(file string, opts compiler.Options) (bool, error) {
+	inputFile, err := os.Open(file)
+	if err != nil {
+		fmt.Printf("An error [%v] occurred on opening the file, please check whether the file exists or can be accessed.\n", err)
+		return false, err
+	}
+	defer inputFile.Close()
+
+	opts.Resolve = compiler.DirResolver(append([]string{filepath.Dir(file)}, imports...)...)
+	contracts, tests, err := compiler.CompileTestsWithOptions(inputFile, opts)
+	if err != nil {
+		fmt.Println(err)
+		fmt.Printf("FAIL\t%s\t[compile error]\n", file)
+		return false, nil
+	}
+	if len(tests) == 0 {
+		fmt.Printf("?   \t%s\t[no tests]\n", file)
+		return true, nil
+	}
+
+	failed := 0
+	for _, test := range tests {
+		res := simulate.RunTest(contracts, test)
+		if !res.Pass {
+			failed++
+		}
+		if res.Pass && !testVerbose {
+			continue
+		}
+		printTestResult(file, res)
+	}
+
+	if failed > 0 {
+		fmt.Printf("FAIL\t%s\t%d of %d tests failed\n", file, failed, len(tests))
+		return false, nil
+	}
+	fmt.Printf("ok  \t%s\t%d tests\n", file, len(tests))
+	return true, nil
+}
+
+func printTestResult(file string, res *simulate.TestResult) {
+	status := "PASS"
+	if !res.Pass {
+		status = "FAIL"
+	}
+	where := fmt.Sprintf("%s:%d", filepath.Base(file), res.Test.Location.Line)
+	if res.Run == nil {
+		fmt.Printf("--- %s: %s (%s)\n", status, res.Test.Name, where)
+		fmt.Println("    cannot run the test:", res.Err)
+		return
+	}
+	fmt.Printf("--- %s: %s (%s, gas %d)\n", status, res.Test.Name, where, res.Run.GasUsed)
+	if res.Pass {
+		return
+	}
+	if res.Run.Pass {
+		fmt.Println("    expected the clause to fail, but it passed")
+		return
+	}
+	reason := errors.Root(res.Run.Err).Error()
+	if inst := res.Run.FailedAt; inst != nil {
+		reason += fmt.Sprintf(" at pc %d %s", inst.PC, inst.Op)
+	}
+	fmt.Println("    expected the clause to pass, but it failed:", reason)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	equ "github.com/equity/equity/util"
+)
+
+var txtemplateContract string
+
+func init() {
+	txtemplateCmd.Flags().StringVar(&txtemplateContract, "contract", "", "Name of the contract to spend (default the last in the file).")
+	equityCmd.AddCommand(txtemplateCmd)
+}
+
+var txtemplateCmd = &cobra.Command{
+	Use:   "txtemplate <input_file> [clause...]",
+	Short: "Print build-transaction requests that spend a contract",
+	Long: `Print the requests to the build-transaction API of a Bytom node that
+spend a contract through its clauses, or through the given clauses. A
+clause with if-else statements gets a request for each outcome, as its
+outputs differ. Values in angle brackets are placeholders to be filled
+in before the request is sent.`,
+	Example: "equity txtemplate FixedLimitProfit profit",
+	Args:    cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := handleTxTemplate(args); err != nil {
+			os.Exit(-1)
+		}
+	},
+}
+
+func handleTxTemplate(args []string) error {
+	contract, err := loadContract(args[0], txtemplateContract)
+	if err != nil {
+		return err
+	}
+
+	clauses := args[1:]
+	if len(clauses) == 0 {
+		for _, clause := range contract.Clauses {
+			clauses = append(clauses, clause.Name)
+		}
+	}
+	for _, clause := range clauses {
+		templates, err := equ.TxTemplates(contract, clause)
+		if err != nil {
+			fmt.Println("Generate transaction template error:", err)
+			return err
+		}
+		for _, t := range templates {
+			fmt.Printf("======= %s.%s =======\n", contract.Name, t.Clause)
+			if len(t.Conditions) > 0 {
+				fmt.Printf("If: %s\n", strings.Join(t.Conditions, " && "))
+			}
+			data, err := equ.JSONMarshal(t, true)
+			if err != nil {
+				fmt.Println("Marshal the transaction template to json error:", err)
+				return err
+			}
+			fmt.Printf("%s\n\n", data)
+		}
+	}
+	return nil
+}
@@ -22,8 +22,17 @@ func InstantiateContract(contract *compiler.Contract, args []compiler.ContractAr
 
 // ConvertArguments convert input argument into contract argument
 func ConvertArguments(contract *compiler.Contract, args []string) ([]compiler.ContractArg, error) {
+	return ConvertParams(contract.Params, args)
+}
+
+// ConvertParams convert input argument into the argument of contract or clause parameters
+func ConvertParams(params []*compiler.Param, args []string) ([]compiler.ContractArg, error) {
+	if len(args) < len(params) {
+		return nil, errors.New("the number of input arguments is less than the number of parameters")
+	}
+
 	var contractArgs []compiler.ContractArg
-	for i, p := range contract.Params {
+	for i, p := range params {
 		var argument compiler.ContractArg
 		switch p.Type {
 		case "Boolean":
@@ -67,9 +76,9 @@ func ConvertArguments(contract *compiler.Contract, args []string) ([]compiler.Co
 			}
 			argument.S = (*chainjson.HexBytes)(&commonValue)
 
-		case "Sign":
+		case "Sign", "Signature":
 			if len(args[i]) != 128 {
-				return nil, errors.New("mismatch length for Sign argument")
+				return nil, errors.New("mismatch length for Sign/Signature argument")
 			}
 
 			signValue, err := hex.DecodeString(args[i])
@@ -24,6 +24,10 @@ func Shift(contract *compiler.Contract) (map[string]string, error) {
 		return clauseMap, nil
 	}
 
+	if contract.Dispatch == compiler.DispatchSearch {
+		return searchShift(contract)
+	}
+
 	instructions, err := vm.ParseProgram(contract.Body)
 	if err != nil {
 		return nil, err
@@ -52,6 +56,27 @@ func Shift(contract *compiler.Contract) (map[string]string, error) {
 		clauseMap[clause.Name] = hex.EncodeToString(jumpifData[i-1])
 	}
 
+	return endShift(contract, clauseMap)
+}
+
+// searchShift statistics the clause selectors of a contract that finds
+// its clause by a binary search: the index of each clause, which the
+// search compares the selector with, in place of its offset
+func searchShift(contract *compiler.Contract) (map[string]string, error) {
+	clauseMap := make(map[string]string)
+	for i, clause := range contract.Clauses {
+		var buffer bytes.Buffer
+		if err := binary.Write(&buffer, binary.LittleEndian, uint32(i)); err != nil {
+			return nil, err
+		}
+		clauseMap[clause.Name] = hex.EncodeToString(buffer.Bytes())
+	}
+
+	return endShift(contract, clauseMap)
+}
+
+// endShift adds the offset of the end of the contract body to clauseMap
+func endShift(contract *compiler.Contract, clauseMap map[string]string) (map[string]string, error) {
 	var buffer bytes.Buffer
 	if err := binary.Write(&buffer, binary.LittleEndian, uint32(len(contract.Body))); err != nil {
 		return nil, err
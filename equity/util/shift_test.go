@@ -0,0 +1,66 @@
+package equity
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/bytom/protocol/vm"
+
+	"github.com/equity/compiler"
+)
+
+func TestShift(t *testing.T) {
+	var clauses []string
+	for i := 0; i < 6; i++ {
+		clauses = append(clauses, fmt.Sprintf("  clause c%d(x: Integer) {\n    verify x == %d\n    unlock value of asset\n  }\n", i, i+10))
+	}
+	src := "contract Many() locks value of asset {\n" + strings.Join(clauses, "") + "}\n"
+
+	for _, opts := range []compiler.Options{{}, {Optimize: compiler.OptNone}} {
+		contracts, err := compiler.CompileWithOptions(strings.NewReader(src), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contract := contracts[0]
+		shifts, err := Shift(contract)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(shifts) != 7 || shifts["c0"] != firstClauseShift {
+			t.Fatalf("dispatch %q: got shifts %v", contract.Dispatch, shifts)
+		}
+
+		for i := 1; i < 6; i++ {
+			b, err := hex.DecodeString(shifts[fmt.Sprintf("c%d", i)])
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Under a binary search, the shift is the clause selector.
+			if contract.Dispatch == compiler.DispatchSearch {
+				spend, err := compiler.DecodeWitness(contract, nil, [][]byte{vm.Int64Bytes(int64(i + 10)), b})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if spend.Clause.Name != fmt.Sprintf("c%d", i) {
+					t.Errorf("selector %x selects clause %s, want c%d", b, spend.Clause.Name, i)
+				}
+				continue
+			}
+
+			// Each clause pushes its own number first, after dropping
+			// the clause selector if the body leaves it.
+			offset := binary.LittleEndian.Uint32(b)
+			code := contract.Body[offset:]
+			if code[0] == byte(vm.OP_DROP) {
+				code = code[1:]
+			}
+			if code[0] != byte(vm.OP_1)+byte(i+9) {
+				t.Errorf("dispatch %q: clause c%d at %d starts with %x", contract.Dispatch, i, offset, code)
+			}
+		}
+	}
+}
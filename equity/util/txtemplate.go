@@ -0,0 +1,168 @@
+package equity
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/equity/compiler"
+)
+
+// btmAssetID is the asset ID of BTM, in which transaction fees are paid.
+const btmAssetID = "ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"
+
+// TxTemplate is a request to the build-transaction API of a Bytom node
+// that spends a contract through one of its clauses. Values that the
+// contract cannot determine are placeholders in angle brackets, such
+// as "<outputID>" or "<amountBill>", to be filled in before the request
+// is sent.
+type TxTemplate struct {
+	// Clause is the clause through which the contract is spent.
+	Clause string `json:"-"`
+
+	// Conditions is the outcome of the clause's if-else statements
+	// that the outputs of the template assume, if it has any.
+	Conditions []string `json:"-"`
+
+	BaseTransaction *string   `json:"base_transaction"`
+	Actions         []*Action `json:"actions"`
+	TTL             int       `json:"ttl"`
+	TimeRange       uint64    `json:"time_range"`
+}
+
+// Action is an action of a build-transaction request.
+type Action struct {
+	Type string `json:"type"`
+
+	// for spend_account_unspent_output
+	OutputID  string      `json:"output_id,omitempty"`
+	Arguments []*Argument `json:"arguments,omitempty"`
+
+	// for spend_account
+	AccountID string `json:"account_id,omitempty"`
+
+	// for control_program and spend_account
+	Amount         interface{} `json:"amount,omitempty"`
+	AssetID        string      `json:"asset_id,omitempty"`
+	ControlProgram string      `json:"control_program,omitempty"`
+}
+
+// Argument is an argument of a spend_account_unspent_output action:
+// raw_tx_signature, data, string, integer or boolean.
+type Argument struct {
+	Type    string                 `json:"type"`
+	RawData map[string]interface{} `json:"raw_data"`
+}
+
+// TxTemplates returns the build-transaction requests that spend
+// contract through the named clause: one for each outcome of the
+// clause's if-else statements, as the outputs differ between them.
+// Each request spends the contract's output with the clause arguments
+// and the clause selector, pays to a control program for each lock
+// statement (and to a receiver for each unlock statement), and spends
+// BTM from an account for the fee.
+func TxTemplates(contract *compiler.Contract, clauseName string) ([]*TxTemplate, error) {
+	var clause *compiler.Clause
+	for _, c := range contract.Clauses {
+		if c.Name == clauseName {
+			clause = c
+		}
+	}
+	if clause == nil {
+		return nil, fmt.Errorf("contract \"%s\" has no clause \"%s\"", contract.Name, clauseName)
+	}
+
+	spend := &Action{Type: "spend_account_unspent_output", OutputID: "<outputID>"}
+	for _, param := range clause.Params {
+		spend.Arguments = append(spend.Arguments, argumentFor(param))
+	}
+	if len(contract.Clauses) > 1 {
+		for i, c := range contract.Clauses {
+			if c == clause {
+				spend.Arguments = append(spend.Arguments, &Argument{Type: "integer", RawData: map[string]interface{}{"value": i}})
+			}
+		}
+	}
+
+	// Each if-else statement doubles the templates. The conditions of
+	// nested statements are taken to be independent.
+	templates := []*TxTemplate{{Clause: clause.Name}}
+	for _, cond := range clause.CondValues {
+		var next []*TxTemplate
+		for _, t := range templates {
+			for _, branch := range []bool{true, false} {
+				values, desc := cond.TrueBodyValues, cond.Condition.Source
+				if !branch {
+					values, desc = cond.FalseBodyValues, "!"+desc
+				}
+				u := &TxTemplate{Clause: t.Clause, Conditions: append(append([]string{}, t.Conditions...), desc)}
+				u.Actions = append(append([]*Action{}, t.Actions...), outputsFor(contract, values)...)
+				next = append(next, u)
+			}
+		}
+		templates = next
+	}
+
+	fee := &Action{Type: "spend_account", AccountID: "<accountID>", Amount: "<fee>", AssetID: btmAssetID}
+	for _, t := range templates {
+		t.Actions = append([]*Action{spend}, append(outputsFor(contract, clause.Values), t.Actions...)...)
+		t.Actions = append(t.Actions, fee)
+	}
+	return templates, nil
+}
+
+// argumentFor returns the argument for a clause parameter.
+func argumentFor(param *compiler.Param) *Argument {
+	placeholder := "<" + param.Name + ">"
+	switch param.Type {
+	case "Signature", "Sign":
+		return &Argument{Type: "raw_tx_signature", RawData: map[string]interface{}{
+			"xpub":            "<xpub of " + param.Name + ">",
+			"derivation_path": []string{"<derivation path of " + param.Name + ">"},
+		}}
+	case "Integer", "Amount":
+		return &Argument{Type: "integer", RawData: map[string]interface{}{"value": placeholder}}
+	case "Boolean":
+		return &Argument{Type: "boolean", RawData: map[string]interface{}{"value": placeholder}}
+	case "String":
+		return &Argument{Type: "string", RawData: map[string]interface{}{"value": placeholder}}
+	}
+	return &Argument{Type: "data", RawData: map[string]interface{}{"value": placeholder}}
+}
+
+// outputsFor returns the control_program actions for values locked or
+// unlocked by a clause.
+func outputsFor(contract *compiler.Contract, values []compiler.ValueInfo) []*Action {
+	var result []*Action
+	for _, v := range values {
+		amount, asset := v.Amount, v.Asset
+		if amount == "" {
+			amount = contract.Value.Amount
+		}
+		if asset == "" {
+			asset = contract.Value.Asset
+		}
+		program := v.Program
+		if program == "" {
+			// unlocked, to be paid wherever the spender likes
+			program = "receiver"
+		}
+		out := &Action{Type: "control_program", AssetID: placeholder(asset), ControlProgram: placeholder(program)}
+		if n, err := strconv.ParseInt(amount, 10, 64); err == nil {
+			out.Amount = n
+		} else {
+			out.Amount = placeholder(amount)
+		}
+		result = append(result, out)
+	}
+	return result
+}
+
+// placeholder returns the hex of an expression if it is a hex
+// literal, and a placeholder for it otherwise.
+func placeholder(expr string) string {
+	if strings.HasPrefix(expr, "0x") {
+		return expr[2:]
+	}
+	return "<" + expr + ">"
+}
@@ -0,0 +1,75 @@
+package equity
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/equity/compiler"
+)
+
+const lockWithDeadline = `
+contract LockWithDeadline(publicKey: PublicKey, deadline: Integer, dest: Program, refund: Program) locks value of asset {
+  clause spend(sig: Signature) {
+    verify checkTxSig(publicKey, sig)
+    unlock value of asset
+  }
+  clause expire(n: Integer, fast: Boolean) {
+    verify above(deadline)
+    if fast {
+      lock value of asset with dest
+    } else {
+      lock n of asset with refund
+      unlock value of asset
+    }
+  }
+}
+`
+
+func TestTxTemplates(t *testing.T) {
+	contracts, err := compiler.Compile(strings.NewReader(lockWithDeadline))
+	if err != nil {
+		t.Fatal(err)
+	}
+	contract := contracts[0]
+
+	templates, err := TxTemplates(contract, "spend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(templates) != 1 {
+		t.Fatalf("got %d templates for spend, want 1", len(templates))
+	}
+	actions := templates[0].Actions
+	if len(actions) != 3 || actions[0].Type != "spend_account_unspent_output" || actions[1].ControlProgram != "<receiver>" || actions[2].Type != "spend_account" {
+		t.Fatalf("got actions %+v", actions)
+	}
+	args := actions[0].Arguments
+	if len(args) != 2 || args[0].Type != "raw_tx_signature" || args[1].Type != "integer" || args[1].RawData["value"] != 0 {
+		t.Errorf("got arguments %+v %+v", args[0], args[1])
+	}
+
+	templates, err = TxTemplates(contract, "expire")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(templates) != 2 || templates[0].Conditions[0] != "fast" || templates[1].Conditions[0] != "!fast" {
+		t.Fatalf("got templates %+v", templates)
+	}
+	args = templates[0].Actions[0].Arguments
+	if len(args) != 3 || args[0].Type != "integer" || args[1].Type != "boolean" || args[2].RawData["value"] != 1 {
+		t.Errorf("got arguments %+v", args)
+	}
+	if outs := templates[0].Actions[1:]; len(outs) != 2 || outs[0].ControlProgram != "<dest>" || outs[0].Amount != "<value>" {
+		t.Errorf("got true branch actions %+v", outs)
+	}
+	if outs := templates[1].Actions[1:]; len(outs) != 3 || outs[0].ControlProgram != "<refund>" || outs[0].Amount != "<n>" || outs[1].ControlProgram != "<receiver>" {
+		t.Errorf("got false branch actions %+v", outs)
+	}
+
+	if got := placeholder("0x0014ba"); got != "0014ba" {
+		t.Errorf("got placeholder %s for a hex literal, want 0014ba", got)
+	}
+	if _, err := TxTemplates(contract, "frob"); err == nil {
+		t.Error("got no error for an unknown clause")
+	}
+}
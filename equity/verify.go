@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bytom/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/equity/compiler"
+)
+
+var verifyDepth = -1
+
+func init() {
+	verifyCmd.Flags().IntVar(&verifyDepth, "depth", -1, "Number of items on the stack when the program starts, if known.")
+	equityCmd.AddCommand(verifyCmd)
+}
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify-bytecode <program>",
+	Short: "Check a control program without running it",
+	Long: `Check a control program, or a contract body, given in hex, without
+running it: every JUMP and JUMPIF lands on an instruction, no path takes
+more from the stacks than they hold, and every path ends with a result.
+It prints how many items the program reads from the stack it starts with
+and the offsets of code that no path reaches. If the program
+instantiates a contract, its body is checked too.
+
+With --depth, every path is checked against the stack the program
+starts with, including the paths through the other clauses of a
+contract.`,
+	Example: "equity verify-bytecode <program> --depth 2",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := handleVerify(args[0]); err != nil {
+			os.Exit(-1)
+		}
+	},
+}
+
+func handleVerify(program string) error {
+	prog, err := hex.DecodeString(program)
+	if err != nil {
+		fmt.Println("Decode the program error:", err)
+		return err
+	}
+
+	if err := printVerify("program", prog, verifyDepth); err != nil {
+		return err
+	}
+
+	inst, err := compiler.SplitInstance(prog)
+	if errors.Root(err) == compiler.ErrNotInstance {
+		return nil
+	}
+	if err != nil {
+		fmt.Println("Split the program error:", err)
+		return err
+	}
+
+	// The body starts with the stack the program did, and the
+	// arguments, and itself if the contract is recursive.
+	depth := -1
+	if verifyDepth >= 0 {
+		depth = verifyDepth + len(inst.Args)
+		if inst.Recursive {
+			depth++
+		}
+	}
+	fmt.Println()
+	return printVerify("body", inst.Body, depth)
+}
+
+func printVerify(what string, prog []byte, depth int) error {
+	report, err := compiler.VerifyBytecode(prog, depth)
+	if err != nil {
+		fmt.Printf("Verify the %s error: %s\n", what, err)
+		return err
+	}
+	fmt.Printf("%s: verified\n", strings.Title(what))
+	if depth < 0 {
+		fmt.Printf("    needs:       %d item(s) on the stack\n", report.Needs)
+	}
+	for _, pc := range report.Unreachable {
+		fmt.Printf("    unreachable: code at offset %d\n", pc)
+	}
+	if report.Fails {
+		fmt.Println("    every path fails")
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	chainjson "github.com/bytom/encoding/json"
+	"github.com/spf13/cobra"
+
+	"github.com/equity/compiler"
+	equ "github.com/equity/equity/util"
+)
+
+var witnessContract string
+
+func init() {
+	witnessCmd.Flags().StringVar(&witnessContract, "contract", "", "Name of the contract to spend (default the last in the file).")
+	equityCmd.AddCommand(witnessCmd)
+}
+
+var witnessCmd = &cobra.Command{
+	Use:   "witness <input_file> <clause> [clause_args...]",
+	Short: "Build the arguments that spend a contract through a clause",
+	Long: `Build the arguments that spend a contract through a clause, and print
+them as a JSON list of hex strings, in the order they are given to the
+program:
+the clause arguments in declaration order, then the clause selector if
+the contract has more than one clause.`,
+	Example: "equity witness TradeOffer cancel <sellerSig>",
+	Args:    cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := handleWitness(args); err != nil {
+			os.Exit(-1)
+		}
+	},
+}
+
+func handleWitness(args []string) error {
+	contract, err := loadContract(args[0], witnessContract)
+	if err != nil {
+		return err
+	}
+
+	clauseName := args[1]
+	var clause *compiler.Clause
+	for _, c := range contract.Clauses {
+		if c.Name == clauseName {
+			clause = c
+		}
+	}
+	if clause == nil {
+		err = fmt.Errorf("contract \"%s\" has no clause \"%s\"", contract.Name, clauseName)
+		fmt.Println(err)
+		return err
+	}
+	if len(args)-2 != len(clause.Params) {
+		err = fmt.Errorf("got %d argument(s) for clause \"%s\", want %d", len(args)-2, clauseName, len(clause.Params))
+		fmt.Println(err)
+		return err
+	}
+	clauseArgs, err := equ.ConvertParams(clause.Params, args[2:])
+	if err != nil {
+		fmt.Println("Convert arguments into clause parameters error:", err)
+		return err
+	}
+
+	witness, err := compiler.WitnessFor(contract, clauseName, clauseArgs)
+	if err != nil {
+		fmt.Println("Build the witness error:", err)
+		return err
+	}
+	hexArgs := make([]chainjson.HexBytes, len(witness))
+	for i, arg := range witness {
+		hexArgs[i] = arg
+	}
+	data, err := equ.JSONMarshal(hexArgs, false)
+	if err != nil {
+		fmt.Println("Marshal the witness to json error:", err)
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
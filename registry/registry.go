@@ -0,0 +1,203 @@
+// Package registry identifies the Equity contract that a control
+// program instantiates, among a set of known contracts, so that
+// indexers can make sense of the outputs they see.
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bytom/crypto/sha3pool"
+	"github.com/bytom/errors"
+
+	"github.com/equity/compiler"
+)
+
+// ErrUnknown is returned by Identify for a program that instantiates
+// a contract that is not in the registry.
+var ErrUnknown = errors.New("program instantiates an unknown contract")
+
+// Registry is a set of contracts indexed by the hash of their bodies.
+type Registry struct {
+	byHash map[[32]byte]*compiler.Contract
+}
+
+// New returns an empty registry.
+func New() *Registry {
+	return &Registry{byHash: make(map[[32]byte]*compiler.Contract)}
+}
+
+// Add adds contracts to the registry. A contract whose body is already
+// in the registry is ignored, so the first of several contracts with
+// the same body is the one identified.
+func (r *Registry) Add(contracts ...*compiler.Contract) {
+	for _, contract := range contracts {
+		h := hash(contract.Body)
+		if _, ok := r.byHash[h]; !ok {
+			r.byHash[h] = contract
+		}
+	}
+}
+
+// Len returns the number of contracts in the registry.
+func (r *Registry) Len() int {
+	return len(r.byHash)
+}
+
+// Load returns a registry of the contracts in a directory and its
+// subdirectories: those compiled from Equity sources in *.equity
+// files, and the compiled contracts in *.json files, as printed by
+// "equity --ast", either one contract or a list of them. Imports in
+// a source file are found relative to its directory.
+//
+// A source is compiled at each optimization level, so that programs
+// compiled at any of them are identified.
+func Load(dir string) (*Registry, error) {
+	return LoadWithOptions(dir, compiler.Options{})
+}
+
+// levels are the optimization levels at which sources are compiled,
+// the default first, so that the contracts it compiles are the ones
+// identified where a body is the same at several levels.
+var levels = []compiler.OptLevel{compiler.OptDefault, compiler.OptSize, compiler.OptNone}
+
+// LoadWithOptions is Load, compiling sources with opts at each
+// optimization level, or, if opts name the passes to run, with those
+// alone. Imports not found beside a source are resolved with
+// opts.Resolve, if set.
+func LoadWithOptions(dir string, opts compiler.Options) (*Registry, error) {
+	r := New()
+	err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		var contracts []*compiler.Contract
+		switch filepath.Ext(file) {
+		case ".equity":
+			contracts, err = compileFile(file, opts)
+		case ".json":
+			contracts, err = readFile(file)
+		default:
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, file)
+		}
+		r.Add(contracts...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func compileFile(file string, opts compiler.Options) ([]*compiler.Contract, error) {
+	src, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	opts.Resolve = resolver(filepath.Dir(file), opts.Resolve)
+
+	all := levels
+	if opts.Passes != nil {
+		all = []compiler.OptLevel{opts.Optimize}
+	}
+	var result []*compiler.Contract
+	for _, level := range all {
+		opts.Optimize = level
+		contracts, err := compiler.CompileWithOptions(bytes.NewReader(src), opts)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, contracts...)
+	}
+	return result, nil
+}
+
+// resolver returns an ImportResolver that looks for a relative path in
+// dir before resolving it with next, if any.
+func resolver(dir string, next compiler.ImportResolver) compiler.ImportResolver {
+	inDir := compiler.DirResolver(dir)
+	if next == nil {
+		return inDir
+	}
+	return func(path string) (string, []byte, error) {
+		if !filepath.IsAbs(path) {
+			if _, err := os.Stat(filepath.Join(dir, path)); err == nil {
+				return inDir(path)
+			}
+		}
+		return next(path)
+	}
+}
+
+func readFile(file string) ([]*compiler.Contract, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var contracts []*compiler.Contract
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "[") {
+		err = json.Unmarshal(data, &contracts)
+	} else {
+		contract := new(compiler.Contract)
+		err = json.Unmarshal(data, contract)
+		contracts = append(contracts, contract)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, contract := range contracts {
+		if contract.Name == "" || len(contract.Body) == 0 {
+			return nil, fmt.Errorf("contract without name or body_bytecode")
+		}
+	}
+	return contracts, nil
+}
+
+// Match describes the contract that a program instantiates.
+type Match struct {
+	Contract *compiler.Contract
+
+	// Args is the arguments of the instantiation, keyed by parameter
+	// name.
+	Args map[string]compiler.ContractArg
+
+	// Recursive tells whether the contract is recursive: whether its
+	// clauses may lock value again with the same contract, and so the
+	// program may be a re-lock of earlier value.
+	Recursive bool
+}
+
+// Identify returns the contract in the registry that prog
+// instantiates, with the arguments of the instantiation. It returns
+// compiler.ErrNotInstance if prog is not an instantiated contract,
+// and ErrUnknown if the contract is not in the registry.
+func (r *Registry) Identify(prog []byte) (*Match, error) {
+	inst, err := compiler.SplitInstance(prog)
+	if err != nil {
+		return nil, err
+	}
+	contract, ok := r.byHash[hash(inst.Body)]
+	if !ok {
+		return nil, ErrUnknown
+	}
+	args, err := compiler.ParseInstance(contract, prog)
+	if err != nil {
+		return nil, errors.Wrapf(err, "contract %s", contract.Name)
+	}
+	return &Match{Contract: contract, Args: args, Recursive: contract.Recursive}, nil
+}
+
+func hash(body []byte) [32]byte {
+	var h [32]byte
+	sha3pool.Sum256(h[:], body)
+	return h
+}
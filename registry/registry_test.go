@@ -0,0 +1,223 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	chainjson "github.com/bytom/encoding/json"
+	"github.com/bytom/errors"
+
+	"github.com/equity/compiler"
+)
+
+const lockWithDeadline = `
+contract LockWithDeadline(publicKey: PublicKey, deadline: Integer, dest: Program) locks value of asset {
+  clause spend(sig: Signature) {
+    verify checkTxSig(publicKey, sig)
+    unlock value of asset
+  }
+  clause expire() {
+    verify above(deadline)
+    lock value of asset with dest
+  }
+}
+`
+
+const relock = `
+contract Relock(owner: PublicKey) locks value of asset {
+  clause split(sig: Signature, a: Amount) {
+    verify checkTxSig(owner, sig)
+    lock a of asset with Relock(owner)
+    unlock value of asset
+  }
+}
+`
+
+func TestIdentify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// one contract as source, the other as a compiled artifact
+	if err := ioutil.WriteFile(filepath.Join(dir, "LockWithDeadline.equity"), []byte(lockWithDeadline), 0644); err != nil {
+		t.Fatal(err)
+	}
+	contracts, err := compiler.Compile(strings.NewReader(relock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	artifact, err := json.Marshal(contracts[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "build"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "build", "Relock.json"), artifact, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Len() != 2 {
+		t.Fatalf("got %d contracts, want 2", r.Len())
+	}
+
+	key := chainjson.HexBytes(bytes.Repeat([]byte{0xaa}, 32))
+	dest := chainjson.HexBytes{0x00, 0x14, 0xba}
+	deadline := int64(1000)
+	lock, err := compiler.Compile(strings.NewReader(lockWithDeadline))
+	if err != nil {
+		t.Fatal(err)
+	}
+	prog, err := compiler.Instantiate(lock[0].Body, lock[0].Params, false, []compiler.ContractArg{{S: &key}, {I: &deadline}, {S: &dest}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := r.Identify(prog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Contract.Name != "LockWithDeadline" || m.Recursive || *m.Args["deadline"].I != deadline || !bytes.Equal(*m.Args["dest"].S, dest) {
+		t.Errorf("got %s recursive %v args %+v", m.Contract.Name, m.Recursive, m.Args)
+	}
+
+	prog, err = compiler.Instantiate(contracts[0].Body, contracts[0].Params, true, []compiler.ContractArg{{S: &key}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err = r.Identify(prog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Contract.Name != "Relock" || !m.Recursive || !bytes.Equal(*m.Args["owner"].S, key) {
+		t.Errorf("got %s recursive %v args %+v", m.Contract.Name, m.Recursive, m.Args)
+	}
+
+	prog, err = compiler.Instantiate([]byte{0x51}, nil, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Identify(prog); err != ErrUnknown {
+		t.Errorf("got error %v for an unknown contract, want ErrUnknown", err)
+	}
+	p2wpkh := append([]byte{0x00, 0x14}, make([]byte, 20)...)
+	if _, err := r.Identify(p2wpkh); errors.Root(err) != compiler.ErrNotInstance {
+		t.Errorf("got error %v for a non-instance, want ErrNotInstance", err)
+	}
+}
+
+func TestIdentifyAtEachLevel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// 2 * 3 is folded except at OptNone
+	const fee = `
+contract Fee(owner: PublicKey, fee: Integer) locks value of asset {
+  clause pay(sig: Signature) {
+    verify checkTxSig(owner, sig)
+    verify fee > 2 * 3
+    unlock value of asset
+  }
+}
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "Fee.equity"), []byte(fee), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	key := chainjson.HexBytes(bytes.Repeat([]byte{0xaa}, 32))
+	amount := int64(10)
+	instance := func(opts compiler.Options) []byte {
+		contracts, err := compiler.CompileWithOptions(strings.NewReader(fee), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prog, err := compiler.Instantiate(contracts[0].Body, contracts[0].Params, false, []compiler.ContractArg{{S: &key}, {I: &amount}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return prog
+	}
+
+	r, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Len() != 2 {
+		t.Fatalf("got %d contracts, want 2", r.Len())
+	}
+	for _, level := range []compiler.OptLevel{compiler.OptNone, compiler.OptDefault, compiler.OptSize} {
+		m, err := r.Identify(instance(compiler.Options{Optimize: level}))
+		if err != nil {
+			t.Fatalf("level %d: %s", level, err)
+		}
+		if m.Contract.Name != "Fee" || *m.Args["fee"].I != amount {
+			t.Errorf("level %d: got %s args %+v", level, m.Contract.Name, m.Args)
+		}
+	}
+
+	// naming the passes compiles with those alone
+	r, err = LoadWithOptions(dir, compiler.Options{Passes: []string{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Identify(instance(compiler.Options{Passes: []string{}})); err != nil {
+		t.Error(err)
+	}
+	if _, err := r.Identify(instance(compiler.Options{})); err != ErrUnknown {
+		t.Errorf("got error %v for a program compiled with other passes, want ErrUnknown", err)
+	}
+}
+
+func TestLoadImports(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.Mkdir(filepath.Join(dir, "src"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "src", "Relock"), []byte(relock), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outer := `import "./Relock"
+
+contract Outer(owner: PublicKey) locks value of asset {
+  clause move() {
+    lock value of asset with Relock(owner)
+  }
+}
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "src", "Outer.equity"), []byte(outer), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.Getwd(); got != wd {
+		t.Errorf("working directory changed to %s", got)
+	}
+	if r.Len() != 2 {
+		t.Errorf("got %d contracts, want Outer and Relock", r.Len())
+	}
+}
@@ -0,0 +1,265 @@
+// Package simulate executes compiled Equity contracts on the Bytom
+// virtual machine offline, against a mock transaction context, so
+// that clauses can be tried without a node.
+package simulate
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/bytom/consensus"
+	"github.com/bytom/errors"
+	"github.com/bytom/protocol/vm"
+
+	"github.com/equity/compiler"
+)
+
+// DefaultGasLimit is the gas available to a run that does not set
+// one: the most that a transaction may use.
+const DefaultGasLimit = consensus.MaxGasAmount
+
+// Context is the mock transaction context in which a contract runs.
+type Context struct {
+	// BlockHeight is the height of the block containing the spend.
+	BlockHeight uint64
+
+	// Amount and AssetID describe the value locked by the contract.
+	// A nil AssetID is taken as all zeros.
+	Amount  uint64
+	AssetID []byte
+
+	// TxSigHash is the hash returned by TXSIGHASH, which checkTxSig
+	// signatures must sign. A nil TxSigHash is taken as all zeros.
+	TxSigHash []byte
+
+	// CheckOutput reports whether the transaction has an output at
+	// index with the given value and control program. If it is nil,
+	// every output is taken to be present.
+	CheckOutput func(index uint64, amount uint64, assetID []byte, vmVersion uint64, code []byte) (bool, error)
+
+	// GasLimit is the gas available to the run. If it is zero,
+	// DefaultGasLimit is used.
+	GasLimit int64
+}
+
+// Output is an output that a program required the transaction to
+// have, as passed to CHECKOUTPUT.
+type Output struct {
+	Index          uint64
+	Amount         uint64
+	AssetID        []byte
+	VMVersion      uint64
+	ControlProgram []byte
+}
+
+// Instruction is an instruction executed by the virtual machine.
+type Instruction struct {
+	// Depth is 0 for the instantiated program and 1 for the contract
+	// body that it runs with CHECKPREDICATE. PC is relative to the
+	// program at that depth, so at depth 1 it is an offset into
+	// Contract.Body.
+	Depth int
+	PC    uint32
+
+	Op   string
+	Data []byte
+
+	// GasLeft is the gas remaining before the instruction.
+	GasLeft int64
+
+	// Stack is the data stack after the instruction, bottom first.
+	// It is nil for an instruction that failed.
+	Stack [][]byte
+}
+
+// Result describes the outcome of running a contract clause.
+type Result struct {
+	// Pass tells whether the program succeeded.
+	Pass bool
+
+	// GasUsed is the gas consumed by the program.
+	GasUsed int64
+
+	// Err is the reason the program failed.
+	Err error
+
+	// FailedAt is the instruction at which the program failed.
+	FailedAt *Instruction
+
+	// Outputs is the list of outputs the program checked for, in the
+	// order it checked them.
+	Outputs []Output
+
+	// Trace is the list of instructions executed.
+	Trace []Instruction
+}
+
+// Run instantiates contract with args and executes its program,
+// unlocking it through the named clause with clauseArgs as the
+// witness.
+func Run(contract *compiler.Contract, args []compiler.ContractArg, clauseName string, clauseArgs []compiler.ContractArg, ctx *Context) (*Result, error) {
+	prog, err := compiler.Instantiate(contract.Body, contract.Params, contract.Recursive, args)
+	if err != nil {
+		return nil, errors.Wrap(err, "instantiating contract")
+	}
+	witness, err := compiler.WitnessFor(contract, clauseName, clauseArgs)
+	if err != nil {
+		return nil, err
+	}
+
+	gasLimit := ctx.GasLimit
+	if gasLimit == 0 {
+		gasLimit = DefaultGasLimit
+	}
+
+	res := new(Result)
+	trace, gasLeft, err := verify(newContext(ctx, prog, witness, res), gasLimit)
+	res.Trace = trace
+	res.GasUsed = gasLimit - gasLeft
+	res.Pass = err == nil
+	if err == nil {
+		return res, nil
+	}
+
+	res.Err = err
+	if len(trace) == 0 {
+		return res, nil
+	}
+	failed := len(trace) - 1
+	if trace[failed].Depth > 0 && errors.Root(err) == vm.ErrFalseVMResult {
+		// CHECKPREDICATE reports only that the contract body failed,
+		// not why. Run the body alone on the stack it was given to
+		// find out.
+		bodyArgs := append([][]byte{}, witness...)
+		for i := len(args) - 1; i >= 0; i-- {
+			bodyArgs = append(bodyArgs, argBytes(args[i]))
+		}
+		if contract.Recursive {
+			bodyArgs = append(bodyArgs, contract.Body)
+		}
+		_, _, bodyErr := verify(newContext(ctx, contract.Body, bodyArgs, new(Result)), gasLimit)
+		if bodyErr != nil {
+			res.Err = bodyErr
+		}
+		if errors.Root(bodyErr) == vm.ErrFalseVMResult {
+			// The body ran to its end with a false result. Blame the
+			// instruction that produced it rather than the jumps after.
+			for failed > 0 && trace[failed-1].Depth == trace[failed].Depth && (trace[failed].Op == "JUMP" || trace[failed].Op == "NOP") {
+				failed--
+			}
+		} else {
+			// any stack read for the failed instruction was written by
+			// the parent after the body stopped
+			trace[failed].Stack = nil
+		}
+	}
+	res.FailedAt = &trace[failed]
+	return res, nil
+}
+
+func argBytes(arg compiler.ContractArg) []byte {
+	switch {
+	case arg.B != nil:
+		return vm.BoolBytes(*arg.B)
+	case arg.I != nil:
+		return vm.Int64Bytes(*arg.I)
+	case arg.S != nil:
+		return *arg.S
+	}
+	return nil
+}
+
+func newContext(ctx *Context, code []byte, args [][]byte, res *Result) *vm.Context {
+	var (
+		txVersion   = uint64(1)
+		blockHeight = ctx.BlockHeight
+		amount      = ctx.Amount
+		assetID     = ctx.AssetID
+		sigHash     = ctx.TxSigHash
+	)
+	if assetID == nil {
+		assetID = make([]byte, 32)
+	}
+	if sigHash == nil {
+		sigHash = make([]byte, 32)
+	}
+	return &vm.Context{
+		VMVersion:   1,
+		Code:        code,
+		Arguments:   args,
+		TxVersion:   &txVersion,
+		BlockHeight: &blockHeight,
+		Amount:      &amount,
+		AssetID:     &assetID,
+		TxSigHash:   func() []byte { return sigHash },
+		CheckOutput: func(index uint64, amount uint64, assetID []byte, vmVersion uint64, code []byte, expansion bool) (bool, error) {
+			res.Outputs = append(res.Outputs, Output{Index: index, Amount: amount, AssetID: assetID, VMVersion: vmVersion, ControlProgram: code})
+			if ctx.CheckOutput == nil {
+				return true, nil
+			}
+			return ctx.CheckOutput(index, amount, assetID, vmVersion, code)
+		},
+	}
+}
+
+// traceMu serializes uses of vm.TraceOut, which is global.
+var traceMu sync.Mutex
+
+// verify runs vm.Verify, tracing the instructions it executes.
+func verify(ctx *vm.Context, gasLimit int64) ([]Instruction, int64, error) {
+	traceMu.Lock()
+	defer traceMu.Unlock()
+
+	var buf bytes.Buffer
+	saved := vm.TraceOut
+	vm.TraceOut = &buf
+	defer func() { vm.TraceOut = saved }()
+
+	gasLeft, err := vm.Verify(ctx, gasLimit)
+	return parseTrace(buf.String()), gasLeft, err
+}
+
+// parseTrace parses the lines written to vm.TraceOut: for each
+// instruction, "vm <depth> pc <pc> limit <gas> <op> [<data>]"
+// followed by "  stack <n>: <item>" lines, top of stack first. The
+// stack after CHECKPREDICATE is written after the lines of the child
+// program, and is dropped.
+func parseTrace(out string) []Instruction {
+	var (
+		trace []Instruction
+		skip  bool // whether the stack lines being read belong to an earlier instruction
+	)
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) >= 7 && fields[0] == "vm":
+			var inst Instruction
+			if _, err := fmt.Sscanf(line, "vm %d pc %d limit %d %s", &inst.Depth, &inst.PC, &inst.GasLeft, &inst.Op); err != nil {
+				continue
+			}
+			if len(fields) > 7 {
+				inst.Data, _ = hex.DecodeString(fields[7])
+			}
+			trace = append(trace, inst)
+			skip = false
+
+		case len(fields) >= 2 && fields[0] == "stack" && len(trace) > 0:
+			last := &trace[len(trace)-1]
+			if fields[1] == "0:" && last.Stack != nil {
+				skip = true
+			}
+			if skip {
+				continue
+			}
+			item := []byte{}
+			if len(fields) > 2 {
+				item, _ = hex.DecodeString(fields[2])
+			}
+			last.Stack = append([][]byte{item}, last.Stack...)
+		}
+	}
+	return trace
+}
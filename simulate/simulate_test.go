@@ -0,0 +1,416 @@
+package simulate
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/bytom/crypto/ed25519"
+	chainjson "github.com/bytom/encoding/json"
+	"github.com/bytom/errors"
+	"github.com/bytom/protocol/vm"
+
+	"github.com/equity/compiler"
+)
+
+const lockWithDeadline = `
+contract LockWithDeadline(publicKey: PublicKey, deadline: Integer, dest: Program) locks value of asset {
+  clause spend(sig: Signature) {
+    verify checkTxSig(publicKey, sig)
+    unlock value of asset
+  }
+  clause expire(n: Integer) {
+    verify above(deadline)
+    verify n > 2
+    lock value of asset with dest
+  }
+}
+`
+
+func compile(t *testing.T, src string) *compiler.Contract {
+	contracts, err := compiler.Compile(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return contracts[len(contracts)-1]
+}
+
+func bytesArg(b []byte) compiler.ContractArg {
+	return compiler.ContractArg{S: (*chainjson.HexBytes)(&b)}
+}
+
+func intArg(n int64) compiler.ContractArg {
+	return compiler.ContractArg{I: &n}
+}
+
+func TestRun(t *testing.T) {
+	contract := compile(t, lockWithDeadline)
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigHash := bytes.Repeat([]byte{0x5a}, 32)
+	dest := []byte{0x00, 0x14, 0x01}
+	args := []compiler.ContractArg{bytesArg(pub), intArg(100), bytesArg(dest)}
+
+	cases := []struct {
+		name       string
+		clause     string
+		clauseArgs []compiler.ContractArg
+		height     uint64
+		wantErr    error
+		wantOp     string
+	}{
+		{"good signature", "spend", []compiler.ContractArg{bytesArg(ed25519.Sign(priv, sigHash))}, 0, nil, ""},
+		{"bad signature", "spend", []compiler.ContractArg{bytesArg(make([]byte, 64))}, 0, vm.ErrFalseVMResult, "CHECKSIG"},
+		{"after deadline", "expire", []compiler.ContractArg{intArg(3)}, 101, nil, ""},
+		{"before deadline", "expire", []compiler.ContractArg{intArg(3)}, 100, vm.ErrVerifyFailed, "VERIFY"},
+		{"small n", "expire", []compiler.ContractArg{intArg(2)}, 101, vm.ErrVerifyFailed, "VERIFY"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := &Context{BlockHeight: c.height, Amount: 1000, TxSigHash: sigHash}
+			res, err := Run(contract, args, c.clause, c.clauseArgs, ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Pass != (c.wantErr == nil) {
+				t.Fatalf("Pass = %v, error %v", res.Pass, res.Err)
+			}
+			if res.GasUsed <= 0 {
+				t.Errorf("GasUsed = %d, want > 0", res.GasUsed)
+			}
+			if c.wantErr == nil {
+				return
+			}
+			if errors.Root(res.Err) != c.wantErr {
+				t.Errorf("error %v, want %v", res.Err, c.wantErr)
+			}
+			if res.FailedAt == nil || res.FailedAt.Depth != 1 || res.FailedAt.Op != c.wantOp {
+				t.Errorf("failed at %+v, want %s in the contract body", res.FailedAt, c.wantOp)
+			}
+		})
+	}
+}
+
+func TestRunOutputs(t *testing.T) {
+	contract := compile(t, lockWithDeadline)
+	dest := []byte{0x00, 0x14, 0x01}
+	args := []compiler.ContractArg{bytesArg(make([]byte, 32)), intArg(100), bytesArg(dest)}
+	asset := bytes.Repeat([]byte{0x01}, 32)
+
+	ctx := &Context{BlockHeight: 101, Amount: 1000, AssetID: asset}
+	res, err := Run(contract, args, "expire", []compiler.ContractArg{intArg(3)}, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Pass {
+		t.Fatal(res.Err)
+	}
+	want := Output{Index: 0, Amount: 1000, AssetID: asset, VMVersion: 1, ControlProgram: dest}
+	if len(res.Outputs) != 1 || !outputEqual(res.Outputs[0], want) {
+		t.Errorf("outputs %+v, want [%+v]", res.Outputs, want)
+	}
+
+	ctx.CheckOutput = func(index uint64, amount uint64, assetID []byte, vmVersion uint64, code []byte) (bool, error) {
+		return false, nil
+	}
+	res, err = Run(contract, args, "expire", []compiler.ContractArg{intArg(3)}, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Pass || res.FailedAt == nil || res.FailedAt.Op != "CHECKOUTPUT" {
+		t.Errorf("got pass %v at %+v, want failure at CHECKOUTPUT", res.Pass, res.FailedAt)
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	contract := compile(t, lockWithDeadline)
+	args := []compiler.ContractArg{bytesArg(make([]byte, 32)), intArg(100), bytesArg(nil)}
+	if _, err := Run(contract, args, "frob", nil, &Context{}); err == nil {
+		t.Error("expected error for unknown clause")
+	}
+	if _, err := Run(contract, args, "expire", nil, &Context{}); err == nil {
+		t.Error("expected error for missing clause argument")
+	}
+	if _, err := Run(contract, args[:1], "expire", []compiler.ContractArg{intArg(3)}, &Context{}); err == nil {
+		t.Error("expected error for missing contract argument")
+	}
+}
+
+func outputEqual(a, b Output) bool {
+	return a.Index == b.Index && a.Amount == b.Amount && bytes.Equal(a.AssetID, b.AssetID) && a.VMVersion == b.VMVersion && bytes.Equal(a.ControlProgram, b.ControlProgram)
+}
+
+func TestGasEstimate(t *testing.T) {
+	contract := compile(t, lockWithDeadline)
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigHash := bytes.Repeat([]byte{0x5a}, 32)
+	args := []compiler.ContractArg{bytesArg(pub), intArg(100), bytesArg([]byte{0x00, 0x14, 0x01})}
+
+	cases := []struct {
+		clause     string
+		clauseArgs []compiler.ContractArg
+	}{
+		{"spend", []compiler.ContractArg{bytesArg(ed25519.Sign(priv, sigHash))}},
+		{"expire", []compiler.ContractArg{intArg(3)}},
+	}
+	for i, c := range cases {
+		ctx := &Context{BlockHeight: 101, Amount: 1000, TxSigHash: sigHash}
+		res, err := Run(contract, args, c.clause, c.clauseArgs, ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.Pass {
+			t.Fatalf("%s: %v", c.clause, res.Err)
+		}
+		gas := contract.Clauses[i].Gas
+		if gas == nil || gas.Best != res.GasUsed || gas.Worst != res.GasUsed {
+			t.Errorf("%s: estimated gas %+v, used %d", c.clause, gas, res.GasUsed)
+		}
+
+		var (
+			depth int
+			size  int64
+		)
+		for _, inst := range res.Trace {
+			if inst.Depth != 1 {
+				continue
+			}
+			if len(inst.Stack) > depth {
+				depth = len(inst.Stack)
+			}
+			var n int64
+			for _, item := range inst.Stack {
+				n += int64(len(item))
+			}
+			if n > size {
+				size = n
+			}
+		}
+		stk := contract.Clauses[i].Stack
+		if stk == nil || stk.Depth != depth || stk.Bytes < size {
+			t.Errorf("%s: estimated stack %+v, used %d items of %d bytes", c.clause, stk, depth, size)
+		}
+	}
+}
+
+const shared = `
+contract Shared(limit: Integer) locks value of asset {
+  clause spend(x: Integer, y: Integer) {
+    verify (x + y) * (x + y) > limit
+    if x != 0 {
+      verify y / x + y / x < limit
+    }
+    unlock value of asset
+  }
+}
+`
+
+func TestSharedSubexpressions(t *testing.T) {
+	contract := compile(t, shared)
+	ops := make(map[string]int)
+	for _, op := range strings.Fields(contract.Opcodes) {
+		ops[op]++
+	}
+	if ops["DIV"] != 1 {
+		t.Errorf("got %s, want one DIV", contract.Opcodes)
+	}
+
+	cases := []struct {
+		x, y int64
+		pass bool
+	}{
+		{3, 4, true},
+		{1, 1, false},
+		{1, 30, false},
+
+		// The division is evaluated only where x is not zero.
+		{0, 7, true},
+	}
+	for _, c := range cases {
+		args := []compiler.ContractArg{intArg(40)}
+		res, err := Run(contract, args, "spend", []compiler.ContractArg{intArg(c.x), intArg(c.y)}, &Context{Amount: 1000})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Pass != c.pass {
+			t.Errorf("spend(%d, %d): Pass = %v, error %v", c.x, c.y, res.Pass, res.Err)
+		}
+	}
+}
+
+const sums = `
+contract Sums(a: Integer, b: Integer, c: Integer, d: Integer, e: Integer, f: Integer, g: Integer, h: Integer, key: PublicKey) locks value of asset {
+  clause spend(x: Integer, y: Integer) {
+    verify x > h
+    verify y < g + h
+    verify x + y < a + b + c + d + e + f
+    unlock value of asset
+  }
+  clause cancel(sig: Signature) {
+    verify checkTxSig(key, sig)
+    unlock value of asset
+  }
+}
+`
+
+func TestScheduledStack(t *testing.T) {
+	contracts, err := compiler.CompileWithOptions(strings.NewReader(sums), compiler.Options{Optimize: compiler.OptSize})
+	if err != nil {
+		t.Fatal(err)
+	}
+	contract := contracts[0]
+
+	cases := []struct {
+		x, y int64
+		pass bool
+	}{
+		{10, 10, true},
+		{8, 10, false},
+		{10, 16, false},
+		{10, 11, false},
+		{9, 1, true},
+	}
+	for _, c := range cases {
+		args := []compiler.ContractArg{intArg(1), intArg(2), intArg(3), intArg(4), intArg(5), intArg(6), intArg(7), intArg(8), bytesArg(make([]byte, 32))}
+		res, err := Run(contract, args, "spend", []compiler.ContractArg{intArg(c.x), intArg(c.y)}, &Context{Amount: 1000})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Pass != c.pass {
+			t.Errorf("spend(%d, %d): Pass = %v, error %v", c.x, c.y, res.Pass, res.Err)
+		}
+	}
+}
+
+const sixClauses = `
+contract Six(key: PublicKey) locks value of asset {
+  clause c0(x: Integer) {
+    verify x == 0
+    unlock value of asset
+  }
+  clause c1(x: Integer) {
+    verify x == 1
+    unlock value of asset
+  }
+  clause c2(x: Integer) {
+    verify x == 2
+    unlock value of asset
+  }
+  clause c3(x: Integer) {
+    verify x == 3
+    unlock value of asset
+  }
+  clause c4(x: Integer) {
+    verify x == 4
+    unlock value of asset
+  }
+  clause c5(sig: Signature) {
+    verify checkTxSig(key, sig)
+    unlock value of asset
+  }
+}
+`
+
+func TestSearchDispatch(t *testing.T) {
+	for _, opts := range []compiler.Options{{}, {Optimize: compiler.OptNone}} {
+		contracts, err := compiler.CompileWithOptions(strings.NewReader(sixClauses), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contract := contracts[0]
+		pub, priv, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sigHash := bytes.Repeat([]byte{0x5a}, 32)
+		args := []compiler.ContractArg{bytesArg(pub)}
+
+		for i, clause := range contract.Clauses {
+			clauseArgs := []compiler.ContractArg{intArg(int64(i))}
+			if i == 5 {
+				clauseArgs = []compiler.ContractArg{bytesArg(ed25519.Sign(priv, sigHash))}
+			}
+			res, err := Run(contract, args, clause.Name, clauseArgs, &Context{Amount: 1000, TxSigHash: sigHash})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !res.Pass {
+				t.Errorf("dispatch %q, %s: %v", contract.Dispatch, clause.Name, res.Err)
+			}
+			if gas := clause.Gas; gas == nil || gas.Best > res.GasUsed || gas.Worst < res.GasUsed {
+				t.Errorf("dispatch %q, %s: estimated gas %+v, used %d", contract.Dispatch, clause.Name, gas, res.GasUsed)
+			}
+			if i == 5 {
+				continue
+			}
+			res, err = Run(contract, args, clause.Name, []compiler.ContractArg{intArg(int64(i + 1))}, &Context{Amount: 1000})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Pass {
+				t.Errorf("dispatch %q, %s: passed with the argument of another clause", contract.Dispatch, clause.Name)
+			}
+		}
+	}
+}
+
+const mergedTails = `
+contract Tails(key: PublicKey, deadline: Integer, dest: Program) locks value of asset {
+  clause spend(sig: Signature, late: Boolean) {
+    verify checkTxSig(key, sig)
+    if late {
+      verify above(deadline)
+      lock value of asset with dest
+    } else {
+      verify below(deadline)
+      lock value of asset with dest
+    }
+  }
+  clause expire() {
+    verify above(deadline)
+    lock value of asset with dest
+  }
+}
+`
+
+func TestMergedTails(t *testing.T) {
+	contract := compile(t, mergedTails)
+	if !strings.Contains(contract.Opcodes, "$_tail_0") {
+		t.Fatalf("got %s, want the branches merged", contract.Opcodes)
+	}
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigHash := bytes.Repeat([]byte{0x5a}, 32)
+	dest := []byte{0x00, 0x14, 0x01}
+	args := []compiler.ContractArg{bytesArg(pub), intArg(100), bytesArg(dest)}
+	sig := bytesArg(ed25519.Sign(priv, sigHash))
+
+	cases := []struct {
+		late   bool
+		height uint64
+		pass   bool
+	}{
+		{true, 101, true},
+		{true, 100, false},
+		{false, 99, true},
+		{false, 100, false},
+	}
+	for _, c := range cases {
+		late := c.late
+		ctx := &Context{BlockHeight: c.height, Amount: 1000, TxSigHash: sigHash}
+		res, err := Run(contract, args, "spend", []compiler.ContractArg{sig, {B: &late}}, ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Pass != c.pass {
+			t.Errorf("spend(late %v) at %d: Pass = %v, error %v", c.late, c.height, res.Pass, res.Err)
+		}
+	}
+}
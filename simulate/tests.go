@@ -0,0 +1,166 @@
+package simulate
+
+import (
+	"fmt"
+
+	"github.com/bytom/crypto/sha3pool"
+	chainjson "github.com/bytom/encoding/json"
+	"github.com/bytom/errors"
+
+	"github.com/equity/compiler"
+)
+
+// TestResult is the outcome of a test declared in Equity source.
+type TestResult struct {
+	Test *compiler.Test
+
+	// Pass tells whether the test passed: whether the clause passed or
+	// failed as the test expected.
+	Pass bool
+
+	// Err is the reason the test could not be run, if it could not.
+	Err error
+
+	// Run is the result of running the clause, if it was run.
+	Run *Result
+}
+
+// RunTest runs test, which may use any of contracts. The spending
+// transaction has the locked value as its only input, and the outputs
+// the test lists.
+func RunTest(contracts []*compiler.Contract, test *compiler.Test) *TestResult {
+	res := &TestResult{Test: test}
+	res.Run, res.Err = runTest(contracts, test)
+	if res.Err == nil {
+		res.Pass = res.Run.Pass == test.ExpectPass
+	}
+	return res
+}
+
+func runTest(contracts []*compiler.Contract, test *compiler.Test) (*Result, error) {
+	r := &testRunner{
+		contracts: make(map[string]*compiler.Contract),
+		tx:        &Tx{Keys: make(map[string]chainjson.HexBytes)},
+	}
+	for _, contract := range contracts {
+		r.contracts[contract.Name] = contract
+	}
+	contract, ok := r.contracts[test.Contract]
+	if !ok {
+		return nil, fmt.Errorf("contract \"%s\" is not found", test.Contract)
+	}
+	if test.BlockHeight < 0 || test.Amount < 0 {
+		return nil, errors.New("negative block height or amount")
+	}
+
+	r.tx.BlockHeight = uint64(test.BlockHeight)
+	in := TxInput{Amount: uint64(test.Amount), AssetID: make([]byte, 32)}
+	if test.Asset != nil {
+		asset, err := r.bytes(test.Asset)
+		if err != nil {
+			return nil, errors.Wrap(err, "asset")
+		}
+		in.AssetID = asset
+	}
+	r.tx.Inputs = []TxInput{in}
+
+	for i, o := range test.Outputs {
+		if o.Amount < 0 {
+			return nil, fmt.Errorf("output %d: negative amount", i)
+		}
+		out := TxOutput{Amount: uint64(o.Amount)}
+		var err error
+		if out.AssetID, err = r.bytes(o.Asset); err != nil {
+			return nil, errors.Wrapf(err, "output %d asset", i)
+		}
+		if out.ControlProgram, err = r.bytes(o.Program); err != nil {
+			return nil, errors.Wrapf(err, "output %d program", i)
+		}
+		r.tx.Outputs = append(r.tx.Outputs, out)
+	}
+
+	// the transaction is complete, so signatures may now be made
+	args, err := r.args(test.Args)
+	if err != nil {
+		return nil, errors.Wrap(err, "contract arguments")
+	}
+	clauseArgs, err := r.args(test.ClauseArgs)
+	if err != nil {
+		return nil, errors.Wrap(err, "clause arguments")
+	}
+
+	ctx, err := r.tx.Context(0)
+	if err != nil {
+		return nil, err
+	}
+	if len(test.Outputs) == 0 {
+		ctx.CheckOutput = nil
+	}
+	return Run(contract, args, test.Clause, clauseArgs, ctx)
+}
+
+// testRunner resolves the values in a test against the transaction
+// being built for it.
+type testRunner struct {
+	contracts map[string]*compiler.Contract
+	tx        *Tx
+}
+
+func (r *testRunner) args(values []*compiler.TestValue) ([]compiler.ContractArg, error) {
+	var result []compiler.ContractArg
+	for _, v := range values {
+		arg, err := r.arg(v)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, arg)
+	}
+	return result, nil
+}
+
+func (r *testRunner) arg(v *compiler.TestValue) (compiler.ContractArg, error) {
+	var (
+		b   []byte
+		err error
+	)
+	switch {
+	case v.PublicKey != "":
+		b, err = r.tx.PublicKey(r.key(v.PublicKey))
+	case v.Signature != "":
+		b, err = r.tx.Sign(r.key(v.Signature), 0)
+	case v.Contract != "":
+		contract, ok := r.contracts[v.Contract]
+		if !ok {
+			return compiler.ContractArg{}, fmt.Errorf("contract \"%s\" is not found", v.Contract)
+		}
+		var args []compiler.ContractArg
+		if args, err = r.args(v.Args); err == nil {
+			b, err = compiler.Instantiate(contract.Body, contract.Params, contract.Recursive, args)
+		}
+	default:
+		return compiler.ContractArg{B: v.B, I: v.I, S: v.S}, nil
+	}
+	if err != nil {
+		return compiler.ContractArg{}, err
+	}
+	return compiler.ContractArg{S: (*chainjson.HexBytes)(&b)}, nil
+}
+
+func (r *testRunner) bytes(v *compiler.TestValue) ([]byte, error) {
+	arg, err := r.arg(v)
+	if err != nil {
+		return nil, err
+	}
+	return argBytes(arg), nil
+}
+
+// key adds the named test key to the transaction, if it is not there
+// yet, and returns its name. Its seed is the hash of the name.
+func (r *testRunner) key(name string) string {
+	if _, ok := r.tx.Keys[name]; !ok {
+		seed := make([]byte, 32)
+		sha3pool.Sum256(seed, []byte(name))
+		r.tx.Keys[name] = seed
+	}
+	return name
+}
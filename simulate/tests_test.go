@@ -0,0 +1,61 @@
+package simulate
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/equity/compiler"
+)
+
+func TestRunTest(t *testing.T) {
+	src := lockWithDeadline + `
+test "spend" {
+  contract LockWithDeadline(publicKey(alice), 100, 0x0014aa)
+  clause spend(signature(alice))
+  expect pass
+}
+
+test "spend expected to fail" {
+  contract LockWithDeadline(publicKey(alice), 100, 0x0014aa)
+  clause spend(signature(alice))
+  expect fail
+}
+
+test "expire to the wrong program" {
+  contract LockWithDeadline(publicKey(alice), 100, 0x0014aa) locks 10 of 0x0101010101010101010101010101010101010101010101010101010101010101
+  height 101
+  clause expire(3)
+  output 10 of 0x0101010101010101010101010101010101010101010101010101010101010101 with LockWithDeadline(publicKey(bob), 100, 0x0014aa)
+  expect fail
+}
+
+test "negative amount" {
+  contract LockWithDeadline(publicKey(alice), 100, 0x0014aa) locks -1 of 0x00
+  clause spend(signature(alice))
+  expect pass
+}
+`
+	contracts, tests, err := compiler.CompileTests(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		pass, run, clausePass bool
+	}{
+		{true, true, true},
+		{false, true, true},
+		{true, true, false},
+		{false, false, false},
+	}
+	for i, test := range tests {
+		res := RunTest(contracts, test)
+		if res.Pass != want[i].pass || (res.Run != nil) != want[i].run {
+			t.Errorf("%s: got pass %v, run %v, error %v", test.Name, res.Pass, res.Run != nil, res.Err)
+			continue
+		}
+		if res.Run != nil && res.Run.Pass != want[i].clausePass {
+			t.Errorf("%s: clause passed %v, want %v", test.Name, res.Run.Pass, want[i].clausePass)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package simulate
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	"github.com/bytom/crypto/ed25519"
+	"github.com/bytom/crypto/sha3pool"
+	chainjson "github.com/bytom/encoding/json"
+	"github.com/bytom/errors"
+)
+
+// Tx describes a transaction that spends a contract, against which
+// the contract's CHECKOUTPUT and signature checks are simulated. It is
+// read from JSON such as:
+//
+//	{
+//	  "block_height": 150,
+//	  "inputs": [{"amount": 1000, "asset_id": "c2c2...c2"}],
+//	  "outputs": [
+//	    {"amount": 600, "asset_id": "c2c2...c2", "control_program": "0014..."},
+//	    {"amount": 400, "asset_id": "c2c2...c2", "control_program": "0014..."}
+//	  ],
+//	  "keys": {"banker": "<32-byte ed25519 seed>"}
+//	}
+type Tx struct {
+	// BlockHeight is the height of the block containing the
+	// transaction.
+	BlockHeight uint64 `json:"block_height"`
+
+	// Inputs is the list of values spent by the transaction.
+	Inputs []TxInput `json:"inputs"`
+
+	// Outputs is the list of values created by the transaction, in
+	// the positions at which lock statements check for them.
+	Outputs []TxOutput `json:"outputs"`
+
+	// Keys maps names to the ed25519 private keys, or their 32-byte
+	// seeds, that sign the transaction.
+	Keys map[string]chainjson.HexBytes `json:"keys,omitempty"`
+}
+
+// TxInput is a value spent by a transaction.
+type TxInput struct {
+	Amount  uint64             `json:"amount"`
+	AssetID chainjson.HexBytes `json:"asset_id"`
+}
+
+// TxOutput is a value created by a transaction.
+type TxOutput struct {
+	Amount         uint64             `json:"amount"`
+	AssetID        chainjson.HexBytes `json:"asset_id"`
+	ControlProgram chainjson.HexBytes `json:"control_program"`
+
+	// VMVersion is the version of the control program. If it is zero,
+	// 1 is used.
+	VMVersion uint64 `json:"vm_version,omitempty"`
+}
+
+// ParseTx reads a transaction description from JSON.
+func ParseTx(data []byte) (*Tx, error) {
+	tx := new(Tx)
+	if err := json.Unmarshal(data, tx); err != nil {
+		return nil, errors.Wrap(err, "parsing transaction")
+	}
+	for i, in := range tx.Inputs {
+		if len(in.AssetID) != 32 {
+			return nil, fmt.Errorf("input %d: asset_id is %d bytes, want 32", i, len(in.AssetID))
+		}
+	}
+	for i, out := range tx.Outputs {
+		if len(out.AssetID) != 32 {
+			return nil, fmt.Errorf("output %d: asset_id is %d bytes, want 32", i, len(out.AssetID))
+		}
+	}
+	for name, key := range tx.Keys {
+		if len(key) != 32 && len(key) != ed25519.PrivateKeySize {
+			return nil, fmt.Errorf("key \"%s\" is %d bytes, want a 32-byte seed or a %d-byte private key", name, len(key), ed25519.PrivateKeySize)
+		}
+	}
+	return tx, nil
+}
+
+// Context returns the context in which the input at index, which
+// spends a contract, is checked.
+func (tx *Tx) Context(index int) (*Context, error) {
+	if index < 0 || index >= len(tx.Inputs) {
+		return nil, fmt.Errorf("transaction has no input %d", index)
+	}
+	in := tx.Inputs[index]
+	return &Context{
+		BlockHeight: tx.BlockHeight,
+		Amount:      in.Amount,
+		AssetID:     in.AssetID,
+		TxSigHash:   tx.SigHash(index),
+		CheckOutput: tx.checkOutput,
+	}, nil
+}
+
+// checkOutput reports whether the transaction has the described
+// output at index.
+func (tx *Tx) checkOutput(index uint64, amount uint64, assetID []byte, vmVersion uint64, code []byte) (bool, error) {
+	if index >= uint64(len(tx.Outputs)) {
+		return false, nil
+	}
+	out := tx.Outputs[index]
+	outVersion := out.VMVersion
+	if outVersion == 0 {
+		outVersion = 1
+	}
+	return out.Amount == amount && bytes.Equal(out.AssetID, assetID) && outVersion == vmVersion && bytes.Equal(out.ControlProgram, code), nil
+}
+
+// SigHash returns the hash that signatures in the witness of the
+// input at index must sign. It is not the hash a Bytom node computes,
+// but like that one it commits to the transaction's inputs and
+// outputs and to the input being signed.
+func (tx *Tx) SigHash(index int) []byte {
+	var buf bytes.Buffer
+	writeUint64 := func(n uint64) {
+		binary.Write(&buf, binary.LittleEndian, n)
+	}
+	writeBytes := func(b []byte) {
+		writeUint64(uint64(len(b)))
+		buf.Write(b)
+	}
+
+	writeUint64(uint64(index))
+	writeUint64(uint64(len(tx.Inputs)))
+	for _, in := range tx.Inputs {
+		writeUint64(in.Amount)
+		writeBytes(in.AssetID)
+	}
+	writeUint64(uint64(len(tx.Outputs)))
+	for _, out := range tx.Outputs {
+		writeUint64(out.Amount)
+		writeBytes(out.AssetID)
+		writeUint64(out.VMVersion)
+		writeBytes(out.ControlProgram)
+	}
+
+	hash := make([]byte, 32)
+	sha3pool.Sum256(hash, buf.Bytes())
+	return hash
+}
+
+// PublicKey returns the public key of the named signing key.
+func (tx *Tx) PublicKey(name string) ([]byte, error) {
+	priv, err := tx.privateKey(name)
+	if err != nil {
+		return nil, err
+	}
+	return priv.Public().(ed25519.PublicKey), nil
+}
+
+// Sign returns the signature by the named key of the hash for the
+// input at index.
+func (tx *Tx) Sign(name string, index int) ([]byte, error) {
+	priv, err := tx.privateKey(name)
+	if err != nil {
+		return nil, err
+	}
+	return ed25519.Sign(priv, tx.SigHash(index)), nil
+}
+
+func (tx *Tx) privateKey(name string) (ed25519.PrivateKey, error) {
+	key, ok := tx.Keys[name]
+	if !ok {
+		return nil, fmt.Errorf("transaction has no key \"%s\"", name)
+	}
+	if len(key) == ed25519.PrivateKeySize {
+		return ed25519.PrivateKey(key), nil
+	}
+	_, priv, err := ed25519.GenerateKey(bytes.NewReader(key))
+	if err != nil {
+		return nil, errors.Wrapf(err, "deriving key \"%s\"", name)
+	}
+	return priv, nil
+}
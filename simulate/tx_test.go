@@ -0,0 +1,177 @@
+package simulate
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	chainjson "github.com/bytom/encoding/json"
+	"github.com/bytom/errors"
+	"github.com/bytom/protocol/vm"
+
+	"github.com/equity/compiler"
+)
+
+func TestParseTx(t *testing.T) {
+	asset := "c2c2c2c2c2c2c2c2c2c2c2c2c2c2c2c2c2c2c2c2c2c2c2c2c2c2c2c2c2c2c2c2"
+	tx, err := ParseTx([]byte(`{
+		"block_height": 150,
+		"inputs": [{"amount": 1000, "asset_id": "` + asset + `"}],
+		"outputs": [{"amount": 600, "asset_id": "` + asset + `", "control_program": "0014aa"}],
+		"keys": {"alice": "0101010101010101010101010101010101010101010101010101010101010101"}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, err := tx.Context(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctx.BlockHeight != 150 || ctx.Amount != 1000 || len(ctx.AssetID) != 32 {
+		t.Errorf("got context %+v", ctx)
+	}
+	if ok, _ := ctx.CheckOutput(0, 600, tx.Outputs[0].AssetID, 1, []byte{0x00, 0x14, 0xaa}); !ok {
+		t.Error("output 0 not found")
+	}
+	if ok, _ := ctx.CheckOutput(1, 600, tx.Outputs[0].AssetID, 1, []byte{0x00, 0x14, 0xaa}); ok {
+		t.Error("found output 1 in a transaction with one output")
+	}
+	if _, err := tx.Context(1); err == nil {
+		t.Error("expected error for missing input")
+	}
+	if _, err := tx.Sign("bob", 0); err == nil {
+		t.Error("expected error for missing key")
+	}
+
+	if _, err := ParseTx([]byte(`{"inputs": [{"amount": 1, "asset_id": "c2"}]}`)); err == nil {
+		t.Error("expected error for short asset ID")
+	}
+}
+
+// TestFixedLimitProfitOutputs checks that the profit clause of
+// FixedLimitProfit requires exactly the right outputs, in the right
+// positions.
+func TestFixedLimitProfitOutputs(t *testing.T) {
+	src, err := ioutil.ReadFile("../compiler/equitytest/FixedLimitProfit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	contract := compile(t, string(src))
+
+	var (
+		billAsset    = bytes.Repeat([]byte{0xb1}, 32)
+		capitalAsset = bytes.Repeat([]byte{0xca}, 32)
+		banker       = []byte{0x00, 0x14, 0xba}
+		saver        = []byte{0x00, 0x14, 0x5a}
+	)
+	tx := &Tx{
+		BlockHeight: 101,
+		Keys:        map[string]chainjson.HexBytes{"banker": bytes.Repeat([]byte{0x07}, 32)},
+	}
+	bankerKey, err := tx.PublicKey("banker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := []compiler.ContractArg{
+		bytesArg(billAsset),
+		intArg(1000000000), // totalAmountBill
+		intArg(10000),      // totalAmountCapital
+		intArg(100),        // expireBlockHeight
+		intArg(200),        // additionalBlockHeight
+		bytesArg(banker),
+		bytesArg(bankerKey),
+	}
+	relock, err := compiler.Instantiate(contract.Body, contract.Params, contract.Recursive, args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inputs := []TxInput{{Amount: 10000, AssetID: capitalAsset}, {Amount: 300000000, AssetID: billAsset}}
+	partial := []TxOutput{
+		{Amount: 300000000, AssetID: billAsset, ControlProgram: banker},
+		{Amount: 3000, AssetID: capitalAsset, ControlProgram: saver},
+		{Amount: 7000, AssetID: capitalAsset, ControlProgram: relock},
+	}
+
+	// the gain equals the locked capital, so none is locked again
+	lastInputs := []TxInput{{Amount: 3000, AssetID: capitalAsset}, {Amount: 300000000, AssetID: billAsset}}
+
+	cases := []struct {
+		name    string
+		inputs  []TxInput
+		outputs []TxOutput
+		pass    bool
+	}{
+		{"partial", inputs, partial, true},
+		{"partial swapped", inputs, []TxOutput{partial[0], partial[2], partial[1]}, false},
+		{"partial short", inputs, []TxOutput{partial[0], {Amount: 2999, AssetID: capitalAsset, ControlProgram: saver}, partial[2]}, false},
+		{"partial wrong asset", inputs, []TxOutput{partial[0], {Amount: 3000, AssetID: billAsset, ControlProgram: saver}, partial[2]}, false},
+		{"partial missing relock", inputs, partial[:2], false},
+		{"last", lastInputs, partial[:2], true},
+		{"last relocked", lastInputs, []TxOutput{partial[0], {Amount: 3000, AssetID: capitalAsset, ControlProgram: relock}}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tx.Inputs, tx.Outputs = c.inputs, c.outputs
+			ctx, err := tx.Context(0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := Run(contract, args, "profit", []compiler.ContractArg{intArg(300000000), bytesArg(saver)}, ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Pass != c.pass {
+				t.Fatalf("Pass = %v, error %v", res.Pass, res.Err)
+			}
+			if !c.pass && (res.FailedAt == nil || res.FailedAt.Op != "VERIFY" || lastOp(res.Trace[:len(res.Trace)-1]) != "CHECKOUTPUT") {
+				t.Errorf("failed at %+v, want the VERIFY of a CHECKOUTPUT", res.FailedAt)
+			}
+		})
+	}
+
+	t.Run("cancel", func(t *testing.T) {
+		tx.BlockHeight = 201
+		tx.Inputs, tx.Outputs = inputs[:1], nil
+		sig, err := tx.Sign("banker", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, err := tx.Context(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := Run(contract, args, "cancel", []compiler.ContractArg{bytesArg(sig)}, ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.Pass {
+			t.Fatal(res.Err)
+		}
+
+		// a signature over another transaction does not unlock it
+		tx.Outputs = partial
+		ctx, err = tx.Context(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err = Run(contract, args, "cancel", []compiler.ContractArg{bytesArg(sig)}, ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Pass || errors.Root(res.Err) != vm.ErrFalseVMResult {
+			t.Errorf("got pass %v, error %v; want false result", res.Pass, res.Err)
+		}
+	})
+}
+
+// lastOp returns the last instruction of trace other than a JUMP,
+// which may join the code of a branch to code it shares with another.
+func lastOp(trace []Instruction) string {
+	for i := len(trace) - 1; i >= 0; i-- {
+		if trace[i].Op != "JUMP" {
+			return trace[i].Op
+		}
+	}
+	return ""
+}